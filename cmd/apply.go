@@ -3,7 +3,12 @@ package cmd
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
 
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/dfmgr"
 	"github.com/cetincetindag/dfmgr/pkg/stow"
 	"github.com/cetincetindag/dfmgr/pkg/utils"
 	"github.com/spf13/cobra"
@@ -11,16 +16,23 @@ import (
 
 var (
 	applySelectiveFlag bool
+	applyDryRun        bool
+	applyJSON          bool
+	applyProfile       string
+	applyOverwrite     bool
 )
 
 var applyCmd = &cobra.Command{
 	Use:   "apply",
 	Short: "Apply dotfiles to the home directory",
-	Long:  `Create symlinks for dotfiles in your repository to your home directory using GNU stow.`,
+	Long: `Create symlinks for dotfiles in your repository to your home directory.
+Links are created by the built-in linker, or by GNU stow when link_backend is set to "stow".
+Use --dry-run to preview the plan without changing anything, and --profile to choose which
+packages this machine receives (see dfmgr profile).`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := runApplyCommand(); err != nil {
 			utils.Error("Failed to apply dotfiles: %s", err)
-			os.Exit(1)
+			utils.Exit(1)
 		}
 	},
 }
@@ -28,15 +40,138 @@ var applyCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(applyCmd)
 	applyCmd.Flags().BoolVarP(&applySelectiveFlag, "selective", "s", false, "Selectively apply dotfiles")
+	applyCmd.Flags().BoolVarP(&applyDryRun, "dry-run", "n", false, "Show what would be done without changing anything")
+	applyCmd.Flags().BoolVar(&applyJSON, "json", false, "Print the dry-run plan as JSON")
+	applyCmd.Flags().StringVar(&applyProfile, "profile", "", "Apply the packages of a profile and keep using it on this machine")
+	applyCmd.Flags().BoolVar(&applyOverwrite, "overwrite-edited", false, "Back up and replace rendered files that were edited by hand")
 }
 
 func runApplyCommand() error {
+	if applyProfile != "" {
+		if err := useProfile(applyProfile, !applyDryRun); err != nil {
+			return err
+		}
+	}
+
+	if applyDryRun {
+		return runApplyDryRun()
+	}
+
 	utils.Info("Applying dotfiles to home directory...")
-	
-	if err := stow.ApplyDotfiles(applySelectiveFlag); err != nil {
+
+	if err := applyDotfiles(applySelectiveFlag, applyOverwrite); err != nil {
 		return fmt.Errorf("failed to apply dotfiles: %w", err)
 	}
-	
+
 	utils.Success("Successfully applied dotfiles")
 	return nil
-} 
\ No newline at end of file
+}
+
+// applyDotfiles applies the packages of this machine, or the ones chosen on
+// the terminal with selective set. With overwrite set, rendered files edited
+// by hand are replaced too.
+func applyDotfiles(selective, overwrite bool) error {
+	var packages []string
+	if selective {
+		var err error
+		if packages, err = stow.SelectPackages(true); err != nil {
+			return err
+		}
+		if len(packages) == 0 {
+			utils.Warning("No packages to apply")
+			return nil
+		}
+	}
+
+	manager, err := newManager()
+	if err != nil {
+		return err
+	}
+	_, err = manager.Apply(dfmgr.ApplyOptions{Packages: packages, OverwriteEdited: overwrite})
+	return err
+}
+
+func runApplyDryRun() error {
+	var packages []string
+	if applySelectiveFlag {
+		var err error
+		if packages, err = stow.SelectPackages(true); err != nil {
+			return err
+		}
+		if len(packages) == 0 {
+			utils.Warning("No packages to apply")
+			return nil
+		}
+	}
+
+	manager, err := newManager()
+	if err != nil {
+		return err
+	}
+	result, err := manager.Apply(dfmgr.ApplyOptions{Packages: packages, DryRun: true, OverwriteEdited: applyOverwrite})
+	if err != nil {
+		return err
+	}
+	plan := result.Plan
+
+	if applyJSON || utils.JSONOutput() {
+		return printJSON(plan)
+	}
+
+	if len(plan.Actions) == 0 && len(plan.Conflicts) == 0 {
+		utils.Success("Nothing to do, %d link(s) already in place", len(plan.Linked))
+		return nil
+	}
+
+	printPlan(plan)
+	utils.Info("Dry run: no changes were made")
+	return nil
+}
+
+func printPlan(plan *stow.Plan) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ACTION\tPACKAGE\tTARGET\tSOURCE")
+
+	for _, action := range plan.Actions {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", action.Type, action.Package, displayPath(action.Target), displayPath(action.Source))
+	}
+	for _, action := range plan.Linked {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "ok", action.Package, displayPath(action.Target), displayPath(action.Source))
+	}
+	for _, c := range plan.Conflicts {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "conflict", c.Package, displayPath(c.Target), c.Reason)
+	}
+	for _, c := range plan.Skipped {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "skipped", c.Package, displayPath(c.Target), c.Reason)
+	}
+	for _, c := range plan.Overrides {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", "override", c.Package, displayPath(c.Target), c.Reason)
+	}
+	w.Flush()
+
+	counts := make(map[stow.ActionType]int)
+	for _, action := range plan.Actions {
+		counts[action.Type]++
+	}
+	fmt.Printf("\n%d to link, %d to render, %d to decrypt, %d already in place, %d to back up, %d to remove, %d directories to create, %d conflicts\n",
+		counts[stow.ActionLink], counts[stow.ActionRender], counts[stow.ActionDecrypt], len(plan.Linked), counts[stow.ActionBackup],
+		counts[stow.ActionRemove]+counts[stow.ActionUnlink], counts[stow.ActionMkdir], len(plan.Conflicts))
+	if len(plan.Skipped) > 0 {
+		fmt.Printf("%d secrets skipped\n", len(plan.Skipped))
+	}
+	if len(plan.Overrides) > 0 {
+		fmt.Printf("%d file(s) overridden by a higher layer\n", len(plan.Overrides))
+	}
+}
+
+// displayPath shortens paths below the home directory to ~/...
+func displayPath(path string) string {
+	if path == "" {
+		return "-"
+	}
+	home := config.Home()
+	if rel, err := filepath.Rel(home, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.Join("~", rel)
+	}
+	return path
+}
@@ -2,30 +2,33 @@ package cmd
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
+	"strings"
 
 	"github.com/cetincetindag/dfmgr/pkg/config"
 	"github.com/cetincetindag/dfmgr/pkg/git"
-	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/hooks"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
 	"github.com/cetincetindag/dfmgr/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
 var (
 	selectiveFlag bool
+	hooksFlag     bool
 )
 
 var cloneCmd = &cobra.Command{
-	Use:   "clone [username]",
+	Use:   "clone <username|repository>",
 	Short: "Clone a dotfiles repository",
-	Long:  `Clone a dotfiles repository from GitHub and apply the configurations to your system.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Clone a dotfiles repository and apply the configurations to your system.
+The repository is a username, whose "dotfiles" repository is cloned from git_host (github.com
+by default), owner/repo, host:owner/repo, a full ssh://, https:// or file:// URL, an scp-style
+address such as git@host:owner/repo.git, or the path of a local repository.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		username := args[0]
-		if err := runCloneCommand(username); err != nil {
+		if err := runCloneCommand(args[0]); err != nil {
 			utils.Error("Failed to clone: %s", err)
-			os.Exit(1)
+			utils.Exit(1)
 		}
 	},
 }
@@ -33,51 +36,114 @@ var cloneCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(cloneCmd)
 	cloneCmd.Flags().BoolVarP(&selectiveFlag, "selective", "s", false, "Selectively apply dotfiles")
+	cloneCmd.Flags().BoolVar(&hooksFlag, "hooks", false, "Run the hooks of a repository you do not own")
 }
 
-func runCloneCommand(username string) error {
-	if !utils.IsValidGitHubUsername(username) {
-		return fmt.Errorf("invalid GitHub username format")
+func runCloneCommand(repository string) error {
+	remote, err := git.ParseRemote(repository, "dotfiles")
+	if err != nil {
+		return err
 	}
 
-	repo := "dotfiles"
 	destPath := config.CurrentConfig.LocalPath
 
 	if utils.IsGitRepo(destPath) {
 		return fmt.Errorf("destination directory already contains a Git repository")
 	}
 
-	utils.Info("Cloning %s's dotfiles repository", username)
+	utils.Info("Cloning dotfiles repository %s", remote)
 
-	if err := git.CloneRepo(username, repo, destPath); err != nil {
+	if err := git.CloneRepo(remote, destPath); err != nil {
 		return fmt.Errorf("failed to clone repository: %w", err)
 	}
 
 	utils.Success("Successfully cloned repository to %s", destPath)
 
-	configPath := filepath.Join(destPath, ".dfmgr.json")
-	if _, err := os.Stat(configPath); err == nil {
-		utils.Info("Found dfmgr configuration file in repository")
-		config.CurrentConfig.GithubUsername = username
-		config.CurrentConfig.DotfilesRepo = repo
-		config.CurrentConfig.LocalPath = destPath
-		if err := config.SaveConfig(); err != nil {
-			utils.Warning("Failed to save configuration: %s", err)
-		}
-	} else {
-		utils.Warning("No dfmgr configuration file found in repository")
-		config.CurrentConfig.GithubUsername = username
-		config.CurrentConfig.DotfilesRepo = repo
-		config.CurrentConfig.LocalPath = destPath
-		if err := config.SaveConfig(); err != nil {
-			utils.Warning("Failed to save configuration: %s", err)
-		}
+	user := config.CurrentConfig.GithubUsername
+	if remote.Owner != "" {
+		config.CurrentConfig.GithubUsername = remote.Owner
+	}
+	config.CurrentConfig.DotfilesRepo = remote.Repo
+	config.CurrentConfig.RemoteURL = remote.URL
+	config.CurrentConfig.LocalPath = destPath
+	if err := config.SaveConfig(); err != nil {
+		utils.Warning("Failed to save configuration: %s", err)
+	}
+	
+	if err := checkManifest(destPath); err != nil {
+		return err
+	}
+	if err := skipForeignHooks(remote, user, destPath); err != nil {
+		return err
 	}
 
-	if err := stow.ApplyDotfiles(selectiveFlag); err != nil {
+	if err := applyDotfiles(selectiveFlag, false); err != nil {
 		return fmt.Errorf("failed to apply dotfiles: %w", err)
 	}
 
-	utils.Success("Successfully applied dotfiles from %s/%s", username, repo)
+	utils.Success("Successfully applied dotfiles from %s", remote)
 	return nil
-} 
\ No newline at end of file
+}
+
+// checkManifest validates the manifest of a freshly cloned repository so that
+// nothing is applied from a repository dfmgr would misread.
+func checkManifest(repoPath string) error {
+	m, err := manifest.Load(repoPath)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		utils.Info("No %s found in repository, every top-level directory is a package", manifest.FileName)
+		return nil
+	}
+
+	utils.Info("Found %s in repository", manifest.FileName)
+	if err := manifest.Join(m.Validate(repoPath)); err != nil {
+		return fmt.Errorf("refusing to apply: %w", err)
+	}
+	return nil
+}
+
+// skipForeignHooks decides whether the apply that follows cloning or
+// forking remote into repoPath runs the hooks of its manifest. The hooks of
+// a repository that belongs to someone other than user run the commands of
+// someone else, so they are listed and only run when the user agrees, or
+// passed --hooks.
+func skipForeignHooks(remote *git.Remote, user, repoPath string) error {
+	if hooksFlag || hooks.Disabled || remote.Owner == "" || strings.EqualFold(remote.Owner, user) {
+		return nil
+	}
+	m, err := manifest.Load(repoPath)
+	if err != nil || m == nil {
+		return err
+	}
+	
+	var commands []string
+	for _, event := range manifest.HookEvents {
+		for _, name := range m.Names() {
+			for _, hook := range m.Packages[name].Hooks[event] {
+				commands = append(commands, hook.Run)
+			}
+		}
+		for _, hook := range m.Hooks[event] {
+			commands = append(commands, hook.Run)
+		}
+	}
+	if len(commands) == 0 {
+		return nil
+	}
+	
+	utils.Warning("%s belongs to %s and has hooks that run these commands:", remote, remote.Owner)
+	for _, command := range commands {
+		utils.Warning("  %s", command)
+	}
+	if utils.Interactive() {
+		run, err := utils.Confirm("Run them")
+		if err != nil || run {
+			return err
+		}
+	}
+	hooks.Disabled = true
+	utils.Info("Not running the hooks; pass --hooks to run them")
+	return nil
+}
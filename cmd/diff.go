@@ -0,0 +1,238 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/diff"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var diffStat bool
+
+// fileDiff is a file reported by diff with --output json.
+type fileDiff struct {
+	Source   string `json:"source"`
+	Target   string `json:"target"`
+	Inserted int    `json:"inserted"`
+	Deleted  int    `json:"deleted"`
+	Binary   bool   `json:"binary,omitempty"`
+	Secret   bool   `json:"secret,omitempty"`
+	Diff     string `json:"diff,omitempty"`
+}
+
+var diffCmd = &cobra.Command{
+	Use:   "diff [paths|packages...]",
+	Short: "Show differences between repository files and live files",
+	Long: `Show unified diffs between the repository copy of a dotfile and the file found in its place
+in your home directory, for every file that is not linked. Templates are compared in their
+rendered form, which shows hand edits and what the next apply will change. Arguments limit the output to
+packages or paths (e.g. dfmgr diff zsh ~/.config/nvim). Use --stat for a summary.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runDiffCommand(args); err != nil {
+			utils.Error("Failed to diff: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(diffCmd)
+	diffCmd.Flags().BoolVar(&diffStat, "stat", false, "Show a summary of changed lines per file")
+}
+
+func runDiffCommand(args []string) error {
+	localPath := config.CurrentConfig.LocalPath
+	home := config.Home()
+
+	packages, err := stow.SelectPackages(false)
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	var selected []string
+	for _, arg := range args {
+		if matched, err := stow.MatchPackages(packages, []string{arg}); err == nil {
+			selected = append(selected, matched...)
+			continue
+		}
+		rel, err := homeRelative(arg)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, filepath.Join(home, rel))
+	}
+	if len(selected) > 0 && len(paths) == 0 {
+		packages = selected
+	}
+
+	linker, err := stow.NewLinker()
+	if err != nil {
+		return err
+	}
+
+	files, err := linker.Status(packages)
+	if err != nil {
+		return err
+	}
+
+	diffs := []fileDiff{}
+	changed := 0
+	totalInserted, totalDeleted := 0, 0
+	for _, f := range files {
+		if !diffSelected(f, selected, paths) {
+			continue
+		}
+		switch f.State {
+		case stow.StateConflict, stow.StateElsewhere, stow.StateOutdated, stow.StateEdited:
+		default:
+			continue
+		}
+
+		repoName := filepath.Join("repo", mustRel(localPath, f.Source))
+		var repoData []byte
+		if f.Secret {
+			// Never print decrypted content, only whether it differs.
+			if repoData, err = linker.Render(f.Source); err == nil {
+				liveData, err := os.ReadFile(f.Target)
+				if err == nil && string(repoData) != string(liveData) {
+					changed++
+					if utils.JSONOutput() {
+						diffs = append(diffs, fileDiff{Source: f.Source, Target: f.Target, Secret: true})
+						continue
+					}
+					fmt.Printf("Secret %s differs from %s\n", repoName, displayPath(f.Target))
+				}
+				continue
+			}
+		} else if f.Template {
+			repoName += " (rendered)"
+			repoData, err = linker.Render(f.Source)
+		} else {
+			repoData, err = os.ReadFile(f.Source)
+		}
+		if err != nil {
+			utils.Warning("Failed to read %s: %s", f.Source, err)
+			continue
+		}
+		liveData, err := os.ReadFile(f.Target)
+		if err != nil {
+			utils.Warning("Failed to read %s: %s", f.Target, err)
+			continue
+		}
+
+		liveName := displayPath(f.Target)
+
+		if diff.IsBinary(repoData) || diff.IsBinary(liveData) {
+			if string(repoData) != string(liveData) {
+				changed++
+				if utils.JSONOutput() {
+					diffs = append(diffs, fileDiff{Source: f.Source, Target: f.Target, Binary: true})
+					continue
+				}
+				fmt.Printf("Binary files %s and %s differ\n", repoName, liveName)
+			}
+			continue
+		}
+
+		a, b := diff.Lines(string(repoData)), diff.Lines(string(liveData))
+		if utils.JSONOutput() {
+			inserted, deleted := diff.Stat(a, b)
+			if inserted+deleted == 0 {
+				continue
+			}
+			changed++
+			entry := fileDiff{Source: f.Source, Target: f.Target, Inserted: inserted, Deleted: deleted}
+			if !diffStat {
+				entry.Diff = diff.Unified(repoName, liveName, a, b)
+			}
+			diffs = append(diffs, entry)
+			continue
+		}
+		if diffStat {
+			inserted, deleted := diff.Stat(a, b)
+			if inserted+deleted == 0 {
+				continue
+			}
+			changed++
+			totalInserted += inserted
+			totalDeleted += deleted
+			fmt.Printf(" %s | %d %s%s\n", liveName, inserted+deleted,
+				color.GreenString(strings.Repeat("+", scaled(inserted))), color.RedString(strings.Repeat("-", scaled(deleted))))
+			continue
+		}
+
+		if out := diff.Unified(repoName, liveName, a, b); out != "" {
+			changed++
+			printColoredDiff(out)
+		}
+	}
+
+	if utils.JSONOutput() {
+		return printJSON(diffs)
+	}
+	if diffStat && changed > 0 {
+		fmt.Printf(" %d files changed, %d insertions(+), %d deletions(-)\n", changed, totalInserted, totalDeleted)
+	}
+	if changed == 0 {
+		utils.Info("No differences between the repository and the home directory")
+	}
+	return nil
+}
+
+func diffSelected(f stow.FileStatus, packages, paths []string) bool {
+	if len(packages) == 0 && len(paths) == 0 {
+		return true
+	}
+	for _, pkg := range packages {
+		if f.Package == pkg {
+			return true
+		}
+	}
+	for _, p := range paths {
+		if stow.IsWithin(p, f.Target) {
+			return true
+		}
+	}
+	return false
+}
+
+func printColoredDiff(out string) {
+	for i, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		switch {
+		case i < 2:
+			fmt.Println(color.New(color.Bold).Sprint(line))
+		case strings.HasPrefix(line, "@@"):
+			fmt.Println(color.CyanString("%s", line))
+		case strings.HasPrefix(line, "+"):
+			fmt.Println(color.GreenString("%s", line))
+		case strings.HasPrefix(line, "-"):
+			fmt.Println(color.RedString("%s", line))
+		default:
+			fmt.Println(line)
+		}
+	}
+}
+
+// scaled caps the +/- bar of --stat at 40 characters.
+func scaled(n int) int {
+	if n > 40 {
+		return 40
+	}
+	return n
+}
+
+func mustRel(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var factsJSON bool
+
+var factsCmd = &cobra.Command{
+	Use:   "facts",
+	Short: "Show what dfmgr detected about this machine",
+	Long: `Show the operating system, architecture, Linux distribution, WSL and container details
+dfmgr detected, and the names they give this machine. Those names can be used as keys of
+os_separation in ~/.dfmgr and in the os list of manifest packages, e.g. "linux/arch" or "linux-wsl".`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runFactsCommand(); err != nil {
+			utils.Error("Failed to show facts: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(factsCmd)
+	factsCmd.Flags().BoolVar(&factsJSON, "json", false, "Print the facts as JSON")
+}
+
+func runFactsCommand() error {
+	facts := config.CurrentFacts()
+
+	if factsJSON || utils.JSONOutput() {
+		return printJSON(struct {
+			config.Facts
+			Names     []string `json:"names"`
+			OSFolders []string `json:"os_folders,omitempty"`
+		}{facts, facts.Names(), config.OSFolders()})
+	}
+
+	fmt.Printf("%-15s %s\n", "OS:", facts.OS)
+	fmt.Printf("%-15s %s\n", "Architecture:", facts.Arch)
+	if facts.Distro != "" {
+		distro := facts.Distro
+		if facts.DistroVersion != "" {
+			distro += " " + facts.DistroVersion
+		}
+		if len(facts.DistroLike) > 0 {
+			distro += fmt.Sprintf(" (like %s)", strings.Join(facts.DistroLike, ", "))
+		}
+		fmt.Printf("%-15s %s\n", "Distribution:", distro)
+	}
+	fmt.Printf("%-15s %t\n", "WSL:", facts.WSL)
+	fmt.Printf("%-15s %s\n", "Container:", valueOr(facts.Container, "none"))
+
+	fmt.Printf("\n%s\n", color.CyanString("Names, most specific first"))
+	for _, name := range facts.Names() {
+		fmt.Printf("  %s\n", name)
+	}
+
+	if config.CurrentConfig.MultiOS {
+		fmt.Printf("\n%s\n", color.CyanString("Layers, lowest precedence first"))
+		for _, layer := range config.Layers() {
+			fmt.Printf("  %s\n", layer)
+		}
+	}
+	return nil
+}
@@ -2,74 +2,76 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 
 	"github.com/cetincetindag/dfmgr/pkg/config"
 	"github.com/cetincetindag/dfmgr/pkg/git"
-	"github.com/cetincetindag/dfmgr/pkg/stow"
 	"github.com/cetincetindag/dfmgr/pkg/utils"
 	"github.com/spf13/cobra"
 )
 
 var forkCmd = &cobra.Command{
-	Use:   "fork [username]",
+	Use:   "fork <username|repository>",
 	Short: "Fork a dotfiles repository",
-	Long:  `Fork someone else's dotfiles repository and make it your own.`,
-	Args:  cobra.ExactArgs(1),
+	Long: `Fork someone else's dotfiles repository and make it your own. The repository is given
+the same way as to clone.`,
+	Args: cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
-		username := args[0]
-		if err := runForkCommand(username); err != nil {
+		if err := runForkCommand(args[0]); err != nil {
 			utils.Error("Failed to fork: %s", err)
-			os.Exit(1)
+			utils.Exit(1)
 		}
 	},
 }
 
 func init() {
 	rootCmd.AddCommand(forkCmd)
+	forkCmd.Flags().BoolVar(&hooksFlag, "hooks", false, "Run the hooks of the forked repository")
 }
 
-func runForkCommand(username string) error {
-	if !utils.IsValidGitHubUsername(username) {
-		return fmt.Errorf("invalid GitHub username format")
+func runForkCommand(repository string) error {
+	upstream, err := git.ParseRemote(repository, "dotfiles")
+	if err != nil {
+		return err
 	}
 
-	repo := "dotfiles"
 	destPath := config.CurrentConfig.LocalPath
 
 	if utils.IsGitRepo(destPath) {
 		return fmt.Errorf("destination directory already contains a Git repository")
 	}
 
-	utils.Info("Forking %s's dotfiles repository", username)
+	utils.Info("Forking dotfiles repository %s", upstream)
 
-	if err := git.ForkRepo(username, repo); err != nil {
+	remote, err := git.ForkRepo(upstream)
+	if err != nil {
 		return fmt.Errorf("failed to fork repository: %w", err)
 	}
 
-	utils.Success("Successfully forked repository from %s/%s", username, repo)
+	utils.Success("Successfully forked repository from %s to %s", upstream, remote)
 
-	myUsername := config.CurrentConfig.GithubUsername
-	if myUsername == "" {
-		utils.Warning("GitHub username not set in configuration. Please run 'dfmgr init' first.")
-		return nil
-	}
-
-	if err := git.CloneRepo(myUsername, repo, destPath); err != nil {
+	if err := git.CloneRepo(remote, destPath); err != nil {
 		return fmt.Errorf("failed to clone forked repository: %w", err)
 	}
 
-	config.CurrentConfig.DotfilesRepo = repo
+	config.CurrentConfig.DotfilesRepo = remote.Repo
+	config.CurrentConfig.RemoteURL = remote.URL
 	config.CurrentConfig.LocalPath = destPath
 	if err := config.SaveConfig(); err != nil {
 		utils.Warning("Failed to save configuration: %s", err)
 	}
+	
+	if err := checkManifest(destPath); err != nil {
+		return err
+	}
+	if err := skipForeignHooks(upstream, config.CurrentConfig.GithubUsername, destPath); err != nil {
+		return err
+	}
 
-	if err := stow.ApplyDotfiles(selectiveFlag); err != nil {
+	if err := applyDotfiles(selectiveFlag, false); err != nil {
 		return fmt.Errorf("failed to apply dotfiles: %w", err)
 	}
 
-	utils.Success("Successfully forked and applied dotfiles from %s/%s", username, repo)
+	utils.Success("Successfully forked and applied dotfiles from %s", upstream)
 	utils.Info("You can now customize the dotfiles and push your changes.")
 	return nil
 } 
\ No newline at end of file
@@ -1,29 +1,30 @@
 package cmd
 
 import (
-	"fmt"
-	"os"
-	"time"
-
-	"github.com/cetincetindag/dfmgr/pkg/config"
-	"github.com/cetincetindag/dfmgr/pkg/git"
+	"github.com/cetincetindag/dfmgr/pkg/dfmgr"
 	"github.com/cetincetindag/dfmgr/pkg/utils"
-	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
 )
 
 var (
-	commitMessage string
+	commitMessage  string
+	allowFindings  bool
+	allowSensitive bool
 )
 
 var pushCmd = &cobra.Command{
 	Use:   "push",
 	Short: "Push changes to the remote repository",
-	Long:  `Add, commit, and push all changes in your dotfiles to the remote GitHub repository.`,
+	Long: `Add, commit, and push all changes in your dotfiles to the remote GitHub repository.
+Before committing, the changes are scanned for private keys, API tokens and sensitive files
+such as SSH private keys or GnuPG keyrings, and the push is refused if any are found. Mark a
+line that is known to be safe with a dfmgr:allow comment, or use --allow to push anyway.
+Files that reveal host names or habits, such as ~/.ssh/config or shell history, are only
+refused when the remote repository is public; use --allow-sensitive to push them anyway.`,
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := runPushCommand(); err != nil {
 			utils.Error("Failed to push: %s", err)
-			os.Exit(1)
+			utils.Exit(1)
 		}
 	},
 }
@@ -35,7 +36,7 @@ var fetchCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		if err := runFetchCommand(); err != nil {
 			utils.Error("Failed to fetch: %s", err)
-			os.Exit(1)
+			utils.Exit(1)
 		}
 	},
 }
@@ -45,55 +46,26 @@ func init() {
 	rootCmd.AddCommand(fetchCmd)
 	
 	pushCmd.Flags().StringVarP(&commitMessage, "message", "m", "", "Commit message")
+	pushCmd.Flags().BoolVar(&allowFindings, "allow", false, "Push even if the secret scanner finds something")
+	pushCmd.Flags().BoolVar(&allowSensitive, "allow-sensitive", false, "Push sensitive files even if the repository is public")
 }
 
 func runPushCommand() error {
-	localPath := config.CurrentConfig.LocalPath
-	
-	if !utils.IsGitRepo(localPath) {
-		return fmt.Errorf("no Git repository found at %s", localPath)
-	}
-	
-	if err := git.AddFiles(localPath); err != nil {
-		return fmt.Errorf("failed to add files: %w", err)
-	}
-	
-	if commitMessage == "" {
-		promptCommitMessage := promptui.Prompt{
-			Label:   "Commit Message",
-			Default: fmt.Sprintf("Update dotfiles - %s", time.Now().Format("2006-01-02")),
-		}
-		
-		var err error
-		commitMessage, err = promptCommitMessage.Run()
-		if err != nil {
-			return fmt.Errorf("failed to get commit message: %w", err)
-		}
+	manager, err := newManager()
+	if err != nil {
+		return err
 	}
 	
-	if err := git.Commit(localPath, commitMessage); err != nil {
-		return fmt.Errorf("failed to commit changes: %w", err)
-	}
-	
-	if err := git.Push(localPath); err != nil {
-		return fmt.Errorf("failed to push changes: %w", err)
-	}
-	
-	utils.Success("Successfully pushed changes to remote repository")
-	return nil
+	_, err = manager.Push(dfmgr.PushOptions{Message: commitMessage, Allow: allowFindings, AllowSensitive: allowSensitive})
+	return err
 }
 
 func runFetchCommand() error {
-	localPath := config.CurrentConfig.LocalPath
-	
-	if !utils.IsGitRepo(localPath) {
-		return fmt.Errorf("no Git repository found at %s", localPath)
-	}
-	
-	if err := git.Pull(localPath); err != nil {
-		return fmt.Errorf("failed to pull changes: %w", err)
+	manager, err := newManager()
+	if err != nil {
+		return err
 	}
 	
-	utils.Success("Successfully fetched latest changes from remote repository")
-	return nil
-} 
\ No newline at end of file
+	_, err = manager.Pull()
+	return err
+}
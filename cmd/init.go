@@ -2,88 +2,143 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/cetincetindag/dfmgr/pkg/config"
 	"github.com/cetincetindag/dfmgr/pkg/git"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
 	"github.com/cetincetindag/dfmgr/pkg/utils"
-	"github.com/manifoldco/promptui"
 	"github.com/spf13/cobra"
 )
 
 var initCmd = &cobra.Command{
 	Use:   "init",
 	Short: "Initialize dfmgr",
-	Long:  `Set up dfmgr with your GitHub account and create your dotfiles repository.`,
+	Long: `Set up dfmgr with your GitHub account and create your dotfiles repository.
+To use another host, pass --git-host (and --git-protocol https where SSH is not available), or
+pass --remote with the URL of an existing, empty repository on any git server.
+Every question can be answered with a flag, for scripts that run with --yes or without a terminal.`,
 	Run: func(cmd *cobra.Command, args []string) {
-		if err := runInitCommand(); err != nil {
+		if err := runInitCommand(cmd); err != nil {
 			utils.Error("Failed to initialize: %s", err)
-			os.Exit(1)
+			utils.Exit(1)
 		}
 	},
 }
 
+var (
+	initRemote      string
+	initGitHost     string
+	initGitProtocol string
+	initVisibility  string
+	initUsername    string
+	initMultiOS     bool
+	initLocalPath   string
+)
+
 func init() {
 	rootCmd.AddCommand(initCmd)
+	initCmd.Flags().StringVar(&initRemote, "remote", "", "Push to this existing repository instead of creating one on GitHub")
+	initCmd.Flags().StringVar(&initGitHost, "git-host", "", "Host of repositories given by owner name (default github.com)")
+	initCmd.Flags().StringVar(&initGitProtocol, "git-protocol", "", "Protocol used to reach repositories, ssh or https (default ssh)")
+	initCmd.Flags().StringVar(&initVisibility, "visibility", "", "Visibility of the created repository, private or public")
+	initCmd.Flags().StringVar(&initUsername, "username", "", "Your username on the git host")
+	initCmd.Flags().BoolVar(&initMultiOS, "multi-os", false, "Use separate folders for different operating systems")
+	initCmd.Flags().StringVar(&initLocalPath, "local-path", "", "Where to keep the dotfiles repository (default ~/dotfiles)")
 }
 
-func runInitCommand() error {
+func runInitCommand(cmd *cobra.Command) error {
 	utils.Info("Starting dfmgr setup process...")
 
 	if !utils.IsCommandAvailable("git") {
 		return fmt.Errorf("git is not installed")
 	}
 
-	if !utils.IsCommandAvailable("gh") {
-		utils.Warning("GitHub CLI (gh) is not installed. Some features may not work properly.")
-		prompt := promptui.Prompt{
-			Label:     "Continue without GitHub CLI",
-			IsConfirm: true,
+	if initGitProtocol != "" && initGitProtocol != config.ProtocolSSH && initGitProtocol != config.ProtocolHTTPS {
+		return fmt.Errorf("unknown git protocol %q, use %s or %s", initGitProtocol, config.ProtocolSSH, config.ProtocolHTTPS)
+	}
+	if initGitProtocol != "" {
+		config.CurrentConfig.GitProtocol = initGitProtocol
+	}
+	if initGitHost != "" {
+		config.CurrentConfig.GitHost = initGitHost
+	}
+
+	var visibility git.Visibility
+	if initVisibility != "" {
+		var err error
+		if visibility, err = parseVisibility(initVisibility); err != nil {
+			return err
+		}
+	}
+
+	var remote *git.Remote
+	if initRemote != "" {
+		var err error
+		if remote, err = git.ParseRemote(initRemote, ""); err != nil {
+			return err
 		}
-		if _, err := prompt.Run(); err != nil {
+	}
+
+	if _, err := git.NewForge(config.GitHost()); remote == nil && err != nil {
+		utils.Warning("Cannot create repositories: %s", err)
+		if ok, err := utils.Confirm("Continue anyway"); err != nil {
+			return err
+		} else if !ok {
 			return fmt.Errorf("aborted setup")
 		}
 	}
 
-	if !utils.IsCommandAvailable("stow") {
+	if config.CurrentConfig.LinkBackend == stow.BackendStow && !utils.IsCommandAvailable("stow") {
 		utils.Warning("GNU stow is not installed. Required for symlinking dotfiles.")
-		prompt := promptui.Prompt{
-			Label:     "Continue without GNU stow",
-			IsConfirm: true,
-		}
-		if _, err := prompt.Run(); err != nil {
+		if ok, err := utils.Confirm("Continue without GNU stow"); err != nil {
+			return err
+		} else if !ok {
 			return fmt.Errorf("aborted setup")
 		}
 	}
 
-	promptGithubUsername := promptui.Prompt{
-		Label: "GitHub Username",
-		Validate: func(input string) error {
-			if input == "" {
-				return fmt.Errorf("username cannot be empty")
-			}
-			if !utils.IsValidGitHubUsername(input) {
-				return fmt.Errorf("invalid GitHub username format (only letters, numbers, hyphens, underscores allowed)")
-			}
-			return nil
-		},
+	github := config.GitHost() == config.DefaultGitHost
+	usernameLabel := "GitHub Username"
+	if !github {
+		usernameLabel = fmt.Sprintf("Username on %s", config.GitHost())
+	}
+	validateUsername := func(input string) error {
+		if input == "" {
+			return fmt.Errorf("username cannot be empty")
+		}
+		if github && !utils.IsValidGitHubUsername(input) {
+			return fmt.Errorf("invalid GitHub username format (only letters, numbers, hyphens, underscores allowed)")
+		}
+		if strings.ContainsAny(input, " \t/") {
+			return fmt.Errorf("invalid username format")
+		}
+		return nil
+	}
+	defaultUsername := ""
+	if remote != nil {
+		defaultUsername = remote.Owner
 	}
 
-	githubUsername, err := promptGithubUsername.Run()
+	githubUsername := initUsername
+	var err error
+	if githubUsername != "" {
+		err = validateUsername(githubUsername)
+	} else {
+		githubUsername, err = utils.Prompt(usernameLabel, defaultUsername, "--username", validateUsername)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to get GitHub username: %w", err)
 	}
 
 	config.CurrentConfig.GithubUsername = githubUsername
 
-	promptMultiOS := promptui.Prompt{
-		Label:     "Use separate folders for different operating systems",
-		IsConfirm: true,
+	multiOS := initMultiOS
+	if !cmd.Flags().Changed("multi-os") && utils.Interactive() {
+		multiOS, _ = utils.Confirm("Use separate folders for different operating systems")
 	}
-
-	multiOS, err := promptMultiOS.Run()
-	if err == nil && multiOS == "y" {
+	if multiOS {
 		config.CurrentConfig.MultiOS = true
 		utils.Info("Using separate folders for different operating systems")
 	} else {
@@ -91,17 +146,34 @@ func runInitCommand() error {
 		utils.Info("Using single directory structure")
 	}
 
-	// Always use "dotfiles" as the repository name
-	config.CurrentConfig.DotfilesRepo = "dotfiles"
-
-	promptLocalPath := promptui.Prompt{
-		Label:   "Local Path",
-		Default: filepath.Join(os.Getenv("HOME"), "dotfiles"),
+	// Always use "dotfiles" as the repository name, unless an existing
+	// repository was given
+	if remote == nil {
+		remote = git.NewRemote(config.GitHost(), githubUsername, "dotfiles")
+	}
+	config.CurrentConfig.DotfilesRepo = remote.Repo
+	config.CurrentConfig.RemoteURL = remote.URL
+
+	if initRemote == "" && visibility == "" {
+		index, err := utils.Select("Repository visibility", []string{
+			"private - only you can see your dotfiles",
+			"public - anyone can see and clone your dotfiles",
+		}, 0, "--visibility")
+		if err != nil {
+			return fmt.Errorf("failed to get repository visibility: %w", err)
+		}
+		visibility = git.VisibilityPrivate
+		if index == 1 {
+			visibility = git.VisibilityPublic
+		}
 	}
 
-	localPath, err := promptLocalPath.Run()
-	if err != nil {
-		return fmt.Errorf("failed to get local path: %w", err)
+	localPath := initLocalPath
+	if localPath == "" {
+		localPath, err = utils.Prompt("Local Path", filepath.Join(config.Home(), "dotfiles"), "--local-path", nil)
+		if err != nil {
+			return fmt.Errorf("failed to get local path: %w", err)
+		}
 	}
 
 	config.CurrentConfig.LocalPath = localPath
@@ -117,6 +189,12 @@ func runInitCommand() error {
 			return fmt.Errorf("failed to create OS-specific directory: %w", err)
 		}
 		utils.Success("Created OS-specific directory: %s", osFolderPath)
+
+		commonPath := filepath.Join(localPath, config.CommonLayer)
+		if err := utils.EnsureDirExists(commonPath); err != nil {
+			return fmt.Errorf("failed to create common directory: %w", err)
+		}
+		utils.Success("Created directory shared by every OS: %s", commonPath)
 	}
 
 	if err := config.SaveConfig(); err != nil {
@@ -125,14 +203,12 @@ func runInitCommand() error {
 
 	utils.Success("Configuration saved to %s", config.ConfigFile())
 
-	if err := git.SetupDefaultRepo(); err != nil {
-		return fmt.Errorf("failed to set up GitHub repository: %w", err)
+	if err := git.SetupDefaultRepo(initRemote == "", visibility); err != nil {
+		return fmt.Errorf("failed to set up dotfiles repository: %w", err)
 	}
 
 	utils.Success("dfmgr initialized successfully!")
-	utils.Info("Your dotfiles repository: https://github.com/%s/%s", 
-		config.CurrentConfig.GithubUsername, 
-		config.CurrentConfig.DotfilesRepo)
+	utils.Info("Your dotfiles repository: %s", remote)
 	utils.Info("Local path: %s", config.CurrentConfig.LocalPath)
 
 	return nil
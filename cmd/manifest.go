@@ -0,0 +1,173 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var manifestForce bool
+
+var manifestCmd = &cobra.Command{
+	Use:   "manifest",
+	Short: "Manage the repository manifest",
+	Long: `The manifest, .dfmgr.json at the root of the dotfiles repository, declares the packages,
+their targets, the operating systems and hosts they apply to, ignore patterns, hooks and
+required tools.`,
+}
+
+var manifestValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the repository manifest for errors",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runManifestValidateCommand(); err != nil {
+			utils.Error("%s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+var manifestInitCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Generate a manifest for an existing repository",
+	Long: `Write a .dfmgr.json that lists the packages currently found in the repository,
+so that an existing repository becomes self-describing.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runManifestInitCommand(); err != nil {
+			utils.Error("Failed to create manifest: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(manifestCmd)
+	manifestCmd.AddCommand(manifestValidateCmd)
+	manifestCmd.AddCommand(manifestInitCmd)
+	manifestInitCmd.Flags().BoolVarP(&manifestForce, "force", "f", false, "Overwrite an existing manifest")
+}
+
+func runManifestValidateCommand() error {
+	localPath := config.CurrentConfig.LocalPath
+
+	m, err := manifest.Load(localPath)
+	if err != nil {
+		return err
+	}
+	if m == nil {
+		return fmt.Errorf("no %s found in %s, run 'dfmgr manifest init' to create one", manifest.FileName, localPath)
+	}
+
+	errs := m.Validate(localPath)
+	for _, err := range errs {
+		utils.Error("%s", err)
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s has %d problem(s)", manifest.FileName, len(errs))
+	}
+
+	utils.Success("%s is valid", manifest.FileName)
+	return nil
+}
+
+func runManifestInitCommand() error {
+	localPath := config.CurrentConfig.LocalPath
+
+	if !utils.IsGitRepo(localPath) {
+		return fmt.Errorf("no dotfiles repository found at %s", localPath)
+	}
+
+	existing, err := manifest.Load(localPath)
+	if err != nil && !manifestForce {
+		return err
+	}
+	if existing != nil && !manifestForce {
+		return fmt.Errorf("%s already exists, use --force to overwrite it", manifest.FileName)
+	}
+
+	m := manifest.New()
+	m.Packages, err = existingPackages(localPath)
+	if err != nil {
+		return err
+	}
+
+	if err := m.Save(localPath); err != nil {
+		return err
+	}
+
+	utils.Success("Created %s with %d package(s)", filepath.Join(localPath, manifest.FileName), len(m.Packages))
+	return nil
+}
+
+// existingPackages describes the packages of a repository that follows the
+// directory conventions. With multi-OS support, the packages inside an OS
+// folder are declared for that OS only, those inside hosts/<hostname> for
+// that host only, and those inside common/ for every machine.
+func existingPackages(localPath string) (map[string]manifest.Package, error) {
+	osFolders := map[string]bool{"linux": true, "darwin": true, "windows": true}
+	for _, folder := range config.CurrentConfig.OSSeparation {
+		osFolders[folder] = true
+	}
+
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		return nil, err
+	}
+
+	packages := make(map[string]manifest.Package)
+	for _, entry := range entries {
+		if !entry.IsDir() || entry.Name() == ".git" {
+			continue
+		}
+
+		if config.CurrentConfig.MultiOS && entry.Name() == config.HostsLayer {
+			hosts, err := os.ReadDir(filepath.Join(localPath, entry.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, host := range hosts {
+				if !host.IsDir() {
+					continue
+				}
+				hostEntries, err := os.ReadDir(filepath.Join(localPath, entry.Name(), host.Name()))
+				if err != nil {
+					return nil, err
+				}
+				for _, hostEntry := range hostEntries {
+					if hostEntry.IsDir() {
+						packages[filepath.Join(entry.Name(), host.Name(), hostEntry.Name())] = manifest.Package{Hosts: []string{host.Name()}}
+					}
+				}
+			}
+			continue
+		}
+
+		if !config.CurrentConfig.MultiOS || !osFolders[entry.Name()] && entry.Name() != config.CommonLayer {
+			packages[entry.Name()] = manifest.Package{}
+			continue
+		}
+
+		osEntries, err := os.ReadDir(filepath.Join(localPath, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, osEntry := range osEntries {
+			if !osEntry.IsDir() {
+				continue
+			}
+			pkg := manifest.Package{OS: []string{entry.Name()}}
+			if entry.Name() == config.CommonLayer {
+				pkg.OS = nil
+			}
+			packages[filepath.Join(entry.Name(), osEntry.Name())] = pkg
+		}
+	}
+	return packages, nil
+}
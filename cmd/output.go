@@ -0,0 +1,20 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// printJSON prints v as indented JSON, or attaches it to the result
+// document with --output json.
+func printJSON(v interface{}) error {
+	if utils.JSONOutput() {
+		utils.SetData(v)
+		return nil
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(v)
+}
@@ -0,0 +1,167 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Show the profiles and host rules of this machine",
+	Long: `Profiles are named sets of packages, such as work or server, and host rules select packages
+by hostname. Both can be defined in ~/.dfmgr or in the repository manifest. Without a profile
+or matching host rule, every package is applied.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runProfileCommand(); err != nil {
+			utils.Error("Failed to show profiles: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+var profileUseCmd = &cobra.Command{
+	Use:   "use <profile>",
+	Short: "Select the profile this machine applies",
+	Long:  `Select the profile this machine applies, or "all" to apply every package regardless of host rules.`,
+	Args:  cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := useProfile(args[0], true); err != nil {
+			utils.Error("Failed to select profile: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+var profileClearCmd = &cobra.Command{
+	Use:   "clear",
+	Short: "Go back to selecting packages by host rules only",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		config.CurrentConfig.Profile = ""
+		if err := config.SaveConfig(); err != nil {
+			utils.Error("Failed to save configuration: %s", err)
+			utils.Exit(1)
+		}
+		utils.Success("Cleared the active profile")
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(profileCmd)
+	profileCmd.AddCommand(profileUseCmd)
+	profileCmd.AddCommand(profileClearCmd)
+}
+
+func runProfileCommand() error {
+	m, err := manifest.Load(config.CurrentConfig.LocalPath)
+	if err != nil {
+		return err
+	}
+
+	profiles, rules := stow.Profiles(m)
+	selection, err := stow.PackageSelection(m)
+	if err != nil {
+		return err
+	}
+
+	if utils.JSONOutput() {
+		return printJSON(struct {
+			Profiles map[string]config.Profile `json:"profiles"`
+			Rules    []config.HostRule         `json:"matching_rules"`
+			Active   string                    `json:"active_profile,omitempty"`
+		}{profiles, selection.Rules, selection.Profile})
+	}
+
+	var names []string
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Println(color.CyanString("Profiles"))
+	if len(names) == 0 {
+		fmt.Println("  none defined")
+	}
+	for _, name := range names {
+		marker := " "
+		if name == selection.Profile {
+			marker = color.GreenString("*")
+		}
+		line := fmt.Sprintf("%s %s: %s", marker, name, patternList(profiles[name].Packages))
+		if exclude := profiles[name].Exclude; len(exclude) > 0 {
+			line += fmt.Sprintf(" (except %s)", strings.Join(exclude, ", "))
+		}
+		fmt.Println(line)
+	}
+
+	hostname, _ := os.Hostname()
+	fmt.Printf("\n%s %s\n", color.CyanString("Host rules matching"), hostname)
+	if len(selection.Rules) == 0 {
+		fmt.Println("  none")
+	}
+	for _, rule := range selection.Rules {
+		fmt.Printf("  %s: profile %s, packages %s\n", rule.Match, valueOr(rule.Profile, "-"), patternList(rule.Packages))
+	}
+	if len(rules) > len(selection.Rules) {
+		fmt.Printf("  (%d other rule(s) do not match)\n", len(rules)-len(selection.Rules))
+	}
+
+	switch {
+	case config.CurrentConfig.Profile != "":
+		fmt.Printf("\nActive profile: %s (set with 'dfmgr profile use')\n", config.CurrentConfig.Profile)
+	case selection.Profile != "":
+		fmt.Printf("\nActive profile: %s (from host rules)\n", selection.Profile)
+	default:
+		fmt.Println("\nNo active profile")
+	}
+	return nil
+}
+
+// useProfile makes name the active profile, remembering it for this
+// machine when persist is set.
+func useProfile(name string, persist bool) error {
+	if name != config.AllProfile {
+		m, err := manifest.Load(config.CurrentConfig.LocalPath)
+		if err != nil {
+			return err
+		}
+		profiles, _ := stow.Profiles(m)
+		if _, ok := profiles[name]; !ok {
+			return fmt.Errorf("unknown profile %q (available: %s)", name, config.ProfileNames(profiles))
+		}
+	}
+
+	config.CurrentConfig.Profile = name
+	if !persist {
+		return nil
+	}
+	if err := config.SaveConfig(); err != nil {
+		return fmt.Errorf("failed to save configuration: %w", err)
+	}
+	utils.Info("Using profile %s on this machine", name)
+	return nil
+}
+
+func patternList(patterns []string) string {
+	if len(patterns) == 0 {
+		return "all packages"
+	}
+	return strings.Join(patterns, ", ")
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,41 @@
+package cmd
+
+import (
+	"github.com/cetincetindag/dfmgr/pkg/dfmgr"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var recoverDiscard bool
+
+var recoverCmd = &cobra.Command{
+	Use:   "recover",
+	Short: "Undo an apply that was interrupted",
+	Long: `Apply journals every change it makes to the home directory in ~/.dfmgr_journal.json and
+rolls them back when it fails or is interrupted. If dfmgr was killed or the machine went down
+before that could happen, recover undoes the journaled changes: links and directories apply
+created are removed and the files it replaced come back from their backup snapshot.
+Run apply again afterwards. --discard deletes the journal instead, for changes that cannot be
+undone, and leaves the home directory as it is.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRecoverCommand(); err != nil {
+			utils.Error("Failed to recover: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(recoverCmd)
+	recoverCmd.Flags().BoolVar(&recoverDiscard, "discard", false, "Delete the journal without undoing anything")
+}
+
+func runRecoverCommand() error {
+	manager, err := newManager()
+	if err != nil {
+		return err
+	}
+
+	_, err = manager.Recover(dfmgr.RecoverOptions{Discard: recoverDiscard})
+	return err
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/git"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/scan"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var repoAllowSensitive bool
+
+var repoCmd = &cobra.Command{
+	Use:   "repo",
+	Short: "Manage the remote dotfiles repository",
+}
+
+var repoVisibilityCmd = &cobra.Command{
+	Use:   "visibility [private|public]",
+	Short: "Show or change who can see the remote repository",
+	Long: `Show whether the remote dotfiles repository is public or private, or change it. Making it
+public is refused while the repository tracks files flagged sensitive, such as ~/.ssh/config or
+shell history, unless --allow is given.`,
+	Args:      cobra.MaximumNArgs(1),
+	ValidArgs: []string{string(git.VisibilityPrivate), string(git.VisibilityPublic)},
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRepoVisibilityCommand(args); err != nil {
+			utils.Error("Failed to change visibility: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(repoCmd)
+	repoCmd.AddCommand(repoVisibilityCmd)
+	repoVisibilityCmd.Flags().BoolVar(&repoAllowSensitive, "allow", false, "Make the repository public even if it tracks sensitive files")
+}
+
+func runRepoVisibilityCommand(args []string) error {
+	localPath := config.CurrentConfig.LocalPath
+
+	if !utils.IsGitRepo(localPath) {
+		return fmt.Errorf("no Git repository found at %s", localPath)
+	}
+
+	remote, err := git.Origin(localPath)
+	if err != nil {
+		return err
+	}
+	if remote.Host == "" || remote.Owner == "" {
+		return fmt.Errorf("%s is not hosted on a forge", remote.URL)
+	}
+	forge, err := git.NewForge(remote.Host)
+	if err != nil {
+		return err
+	}
+
+	current, err := forge.Visibility(remote.Owner, remote.Repo)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", remote, err)
+	}
+	if len(args) == 0 {
+		if utils.JSONOutput() {
+			return printJSON(map[string]string{"remote": remote.String(), "visibility": string(current)})
+		}
+		fmt.Printf("%s is %s\n", remote, current)
+		return nil
+	}
+
+	visibility, err := parseVisibility(args[0])
+	if err != nil {
+		return err
+	}
+	if visibility == current {
+		utils.Info("%s is already %s", remote, current)
+		return nil
+	}
+
+	if visibility == git.VisibilityPublic {
+		files, err := git.TrackedFiles(localPath)
+		if err != nil {
+			return err
+		}
+		findings := scan.Sensitive(files, manifest.SensitivePatterns(localPath))
+		for _, finding := range findings {
+			utils.Warning("%s", finding)
+		}
+		if len(findings) > 0 && !repoAllowSensitive {
+			return fmt.Errorf("the repository tracks %d sensitive file(s), remove them from its history or use --allow", len(findings))
+		}
+
+		if ok, err := utils.Confirm(fmt.Sprintf("Make %s visible to everyone", remote)); err != nil {
+			return err
+		} else if !ok {
+			return fmt.Errorf("aborted")
+		}
+	}
+
+	if err := forge.SetVisibility(remote.Owner, remote.Repo, visibility); err != nil {
+		return err
+	}
+	utils.Success("%s is now %s", remote, visibility)
+	return nil
+}
+
+func parseVisibility(value string) (git.Visibility, error) {
+	switch git.Visibility(value) {
+	case git.VisibilityPrivate, git.VisibilityPublic:
+		return git.Visibility(value), nil
+	}
+	return "", fmt.Errorf("unknown visibility %q, use %s or %s", value, git.VisibilityPrivate, git.VisibilityPublic)
+}
@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/cetincetindag/dfmgr/pkg/backup"
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var restoreCmd = &cobra.Command{
+	Use:   "restore [snapshot] [paths...]",
+	Short: "Restore files from a backup snapshot",
+	Long: `Every apply that replaces existing files saves them to a timestamped snapshot in ~/.dfmgr_backup.
+Without arguments, restore lists the available snapshots. Given a snapshot ID (or "latest"), it puts
+the backed up files back, removing the dfmgr symlinks in their place. Paths limit the restore to
+specific files or directories, e.g. dfmgr restore latest ~/.config/nvim`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runRestoreCommand(args); err != nil {
+			utils.Error("Failed to restore: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(restoreCmd)
+}
+
+func runRestoreCommand(args []string) error {
+	backupDir := backup.DefaultDir()
+
+	if len(args) == 0 {
+		return listSnapshots(backupDir)
+	}
+
+	snapshot, err := backup.Open(backupDir, args[0])
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, p := range args[1:] {
+		rel, err := homeRelative(p)
+		if err != nil {
+			return err
+		}
+		paths = append(paths, rel)
+	}
+
+	entries := snapshot.Match(paths)
+	if len(entries) == 0 {
+		return fmt.Errorf("no matching files in snapshot %s", snapshot.Manifest.ID)
+	}
+
+	restored := 0
+	for _, entry := range entries {
+		if err := snapshot.Restore(entry, stow.IsManagedLink); err != nil {
+			utils.Warning("Failed to restore %s: %s", entry.Path, err)
+			continue
+		}
+		utils.Success("Restored: %s", filepath.Join("~", entry.Path))
+		utils.Record("restore", entry.Path, snapshot.Manifest.ID)
+		restored++
+	}
+
+	if restored < len(entries) {
+		return fmt.Errorf("restored %d of %d files from snapshot %s", restored, len(entries), snapshot.Manifest.ID)
+	}
+
+	utils.Success("Restored %d files from snapshot %s", restored, snapshot.Manifest.ID)
+	return nil
+}
+
+func listSnapshots(backupDir string) error {
+	snapshots, err := backup.List(backupDir)
+	if err != nil {
+		return err
+	}
+
+	if utils.JSONOutput() {
+		manifests := []backup.Manifest{}
+		for _, s := range snapshots {
+			manifests = append(manifests, s.Manifest)
+		}
+		return printJSON(manifests)
+	}
+	
+	if len(snapshots) == 0 {
+		utils.Info("No backup snapshots found in %s", backupDir)
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SNAPSHOT\tCREATED\tFILES")
+	for _, s := range snapshots {
+		fmt.Fprintf(w, "%s\t%s\t%d\n", s.Manifest.ID, s.Manifest.Created.Format("2006-01-02 15:04:05"), len(s.Manifest.Entries))
+	}
+	return w.Flush()
+}
+
+// homeRelative turns ~/x, /home/user/x or x into a path relative to $HOME.
+func homeRelative(path string) (string, error) {
+	home := config.Home()
+
+	if path == "~" {
+		return ".", nil
+	}
+	if strings.HasPrefix(path, "~/") {
+		return filepath.Clean(path[2:]), nil
+	}
+	if !filepath.IsAbs(path) {
+		return filepath.Clean(path), nil
+	}
+
+	rel, err := filepath.Rel(home, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of the home directory", path)
+	}
+	return rel, nil
+}
@@ -0,0 +1,37 @@
+package cmd
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+)
+
+func TestHomeRelative(t *testing.T) {
+	home := filepath.Join(t.TempDir(), "user")
+	saved := config.HomeDir
+	t.Cleanup(func() { config.HomeDir = saved })
+	config.HomeDir = home
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"~", "."},
+		{"~/.bashrc", ".bashrc"},
+		{".config/nvim", filepath.Join(".config", "nvim")},
+		{filepath.Join(home, ".config", "nvim"), filepath.Join(".config", "nvim")},
+		{filepath.Join(home, "..foo"), "..foo"},
+	}
+	for _, tt := range tests {
+		if got, err := homeRelative(tt.path); err != nil || got != tt.want {
+			t.Errorf("homeRelative(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+
+	for _, path := range []string{filepath.Dir(home), filepath.Join(filepath.Dir(home), "other", ".bashrc")} {
+		if got, err := homeRelative(path); err == nil {
+			t.Errorf("homeRelative(%q) = %q, want an error", path, got)
+		}
+	}
+}
@@ -5,17 +5,23 @@ import (
 	"os"
 
 	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/dfmgr"
+	"github.com/cetincetindag/dfmgr/pkg/hooks"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
 var (
 	cfgFile string
+	quiet   bool
+	verbose int
+	output  string
 	rootCmd = &cobra.Command{
 		Use:   "dfmgr",
 		Short: "dfmgr - A dotfiles manager",
 		Long: `dfmgr is a powerful dotfiles manager that helps you manage, share, and synchronize your configuration files across multiple machines.
-It uses GNU stow for symlinking and integrates with GitHub for easy sharing and collaboration.`,
+It mirrors your packages into your home directory with symlinks, stow style, and integrates with GitHub for easy sharing and collaboration.`,
 		Version: "0.2.0",
 	}
 )
@@ -23,20 +29,65 @@ It uses GNU stow for symlinking and integrates with GitHub for easy sharing and
 func init() {
 	cobra.OnInitialize(initConfig)
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.dfmgr)")
+	rootCmd.PersistentFlags().BoolVarP(&utils.AssumeYes, "yes", "y", false, "Never prompt and answer yes to every confirmation")
+	rootCmd.PersistentFlags().BoolVar(&utils.NonInteractive, "non-interactive", false, "Never prompt, fail when a value is missing (default when stdin is not a terminal)")
+	rootCmd.PersistentFlags().BoolVar(&hooks.Disabled, "no-hooks", false, "Do not run the hooks of the repository manifest")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Only print warnings and errors")
+	rootCmd.PersistentFlags().CountVarP(&verbose, "verbose", "v", "Print every change made (-v) and the output of the commands run (-vv)")
+	rootCmd.PersistentFlags().StringVar(&output, "output", "text", "Output format, text or json (a single result document on stdout)")
 	rootCmd.PersistentPreRun = func(cmd *cobra.Command, args []string) {
+		if err := setupLogger(cmd); err != nil {
+			utils.Error("%s", err)
+			utils.Exit(1)
+		}
 		// Only print logo for main commands, not for help or completion
-		if cmd.Name() != "completion" && cmd.Name() != "help" {
+		if cmd.Name() != "completion" && cmd.Name() != "help" && !quiet && !utils.JSONOutput() {
 			printLogo()
 		}
 	}
 }
 
+// setupLogger applies --quiet, --verbose and --output to the logger.
+func setupLogger(cmd *cobra.Command) error {
+	switch output {
+	case "text":
+	case "json":
+		utils.Log.JSON = true
+	default:
+		return fmt.Errorf("unknown output format %q, use text or json", output)
+	}
+	utils.Log.Begin(cmd.CommandPath())
+
+	switch {
+	case quiet && verbose > 0:
+		return fmt.Errorf("--quiet and --verbose cannot be combined")
+	case quiet:
+		utils.Log.Level = utils.LevelWarning
+	case verbose == 1:
+		utils.Log.Level = utils.LevelDebug
+	case verbose > 1:
+		utils.Log.Level = utils.LevelTrace
+	}
+	return nil
+}
+
 func initConfig() {
 	config.LoadConfig(cfgFile)
 }
 
+// newManager returns a Manager for the loaded configuration that logs and
+// prompts like the rest of the command line, rolling apply back on Ctrl-C.
+func newManager() (*dfmgr.Manager, error) {
+	return dfmgr.New(config.CurrentConfig, dfmgr.WithLogger(utils.Log), dfmgr.WithPrompts(), dfmgr.WithSignals())
+}
+
 func Execute() error {
-	return rootCmd.Execute()
+	err := rootCmd.Execute()
+	if err != nil && utils.JSONOutput() {
+		utils.Error("%s", err)
+	}
+	utils.Log.Flush(err == nil)
+	return err
 }
 
 func printLogo() {
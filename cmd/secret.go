@@ -0,0 +1,216 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/secret"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	secretPackage    string
+	secretPassphrase bool
+)
+
+var secretCmd = &cobra.Command{
+	Use:   "secret",
+	Short: "Manage encrypted files in the dotfiles repository",
+	Long: `Secrets are stored encrypted in the repository, with a .secret suffix, and decrypted by apply
+to real files readable only by you. They are never symlinked, so decrypted content never ends up
+in the repository. Secrets are encrypted to the key in ~/.dfmgr_key (see secret_key in ~/.dfmgr),
+or to a passphrase with --passphrase.`,
+}
+
+var secretKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "Create the key secrets are encrypted to",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runSecretKeygenCommand(); err != nil {
+			utils.Error("Failed to create key: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+var secretAddCmd = &cobra.Command{
+	Use:   "add <paths...>",
+	Short: "Encrypt files into the dotfiles repository",
+	Long: `Encrypt files from your home directory into the dotfiles repository. The files stay in place,
+their permissions are restricted to 0600, and apply recreates them from the encrypted copy on
+other machines. Adding a file again updates its encrypted copy.`,
+	Args: cobra.MinimumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runSecretAddCommand(args); err != nil {
+			utils.Error("Failed to add secret: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(secretCmd)
+	secretCmd.AddCommand(secretKeygenCmd)
+	secretCmd.AddCommand(secretAddCmd)
+	secretAddCmd.Flags().StringVarP(&secretPackage, "package", "p", "", "Package to add the files to")
+	secretAddCmd.Flags().BoolVar(&secretPassphrase, "passphrase", false, "Encrypt with a passphrase instead of the secret key")
+}
+
+func runSecretKeygenCommand() error {
+	path := secret.KeyFile()
+	if stow.IsWithin(config.CurrentConfig.LocalPath, path) {
+		return fmt.Errorf("%s is inside the dotfiles repository", path)
+	}
+	if _, err := os.Stat(path); err == nil {
+		return fmt.Errorf("%s already exists", path)
+	}
+
+	identity, err := secret.GenerateIdentity()
+	if err != nil {
+		return err
+	}
+	if err := identity.Save(path); err != nil {
+		return err
+	}
+
+	utils.Success("Created secret key %s", path)
+	utils.Info("Public key: %s", identity.Recipient())
+	utils.Warning("Back this key up and copy it to your other machines, secrets cannot be decrypted without it")
+	return nil
+}
+
+func runSecretAddCommand(paths []string) error {
+	localPath := config.CurrentConfig.LocalPath
+	home := config.Home()
+
+	if !utils.IsGitRepo(localPath) {
+		return fmt.Errorf("no dotfiles repository found at %s", localPath)
+	}
+
+	var identity *secret.Identity
+	var passphrase string
+	if secretPassphrase {
+		p, err := secret.AskPassphrase()
+		if err != nil {
+			return err
+		}
+		if p == "" {
+			return fmt.Errorf("the passphrase cannot be empty")
+		}
+		passphrase = p
+	} else {
+		keyring, err := secret.LoadKeyring()
+		if err != nil {
+			return err
+		}
+		if keyring.Identity == nil {
+			return fmt.Errorf("no secret key found at %s, run 'dfmgr secret keygen' first or use --passphrase", secret.KeyFile())
+		}
+		identity = keyring.Identity
+	}
+
+	repoManifest, err := manifest.Load(localPath)
+	if err != nil {
+		return err
+	}
+
+	linker, err := stow.NewLinker()
+	if err != nil {
+		return err
+	}
+
+	added := 0
+	for _, arg := range paths {
+		path, err := filepath.Abs(arg)
+		if err != nil {
+			return err
+		}
+		if err := addSecret(linker, repoManifest, home, path, identity, passphrase); err != nil {
+			utils.Warning("Skipping %s: %s", arg, err)
+			continue
+		}
+		added++
+	}
+
+	if err := linker.Rendered.Save(); err != nil {
+		utils.Warning("Failed to record decrypted files: %s", err)
+	}
+	if added == 0 {
+		return fmt.Errorf("no secrets were added")
+	}
+
+	utils.Success("Encrypted %d file(s), push them with 'dfmgr push'", added)
+	return nil
+}
+
+func addSecret(linker *stow.Linker, repoManifest *manifest.Manifest, home, path string, identity *secret.Identity, passphrase string) error {
+	localPath := config.CurrentConfig.LocalPath
+
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if !info.Mode().IsRegular() {
+		return fmt.Errorf("only regular files can be stored as secrets")
+	}
+	if resolved, err := filepath.EvalSymlinks(path); err != nil || stow.IsWithin(localPath, resolved) {
+		return fmt.Errorf("it is inside the dotfiles repository and would be committed in plaintext")
+	}
+
+	relPath, err := filepath.Rel(home, path)
+	if err != nil || strings.HasPrefix(relPath, "..") {
+		return fmt.Errorf("not in your home directory")
+	}
+
+	pkg := secretPackage
+	if pkg == "" {
+		pkg = stow.PackageName(relPath)
+	}
+	pkgRelPath, err := filepath.Rel(linker.TargetFor(pkg), path)
+	if err != nil || strings.HasPrefix(pkgRelPath, "..") {
+		return fmt.Errorf("outside of the target of package %s", pkg)
+	}
+
+	if _, err := os.Lstat(filepath.Join(localPath, pkg, pkgRelPath)); err == nil {
+		return fmt.Errorf("a plaintext copy is tracked at %s, remove it from the repository first", filepath.Join(pkg, pkgRelPath))
+	}
+
+	plaintext, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	encrypted, err := secret.Encrypt(plaintext, identity, passphrase)
+	if err != nil {
+		return err
+	}
+
+	if err := stow.CreateParents(filepath.Join(localPath, pkg), linker.TargetFor(pkg), pkgRelPath, nil); err != nil {
+		return err
+	}
+	repoPath := filepath.Join(localPath, pkg, pkgRelPath+secret.Suffix)
+	if err := os.WriteFile(repoPath, encrypted, 0644); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(path, 0600); err != nil {
+		return err
+	}
+	linker.Rendered.Record(path, plaintext)
+
+	if added, err := repoManifest.Declare(localPath, pkg); err != nil {
+		utils.Warning("Failed to add package %s to %s: %s", pkg, manifest.FileName, err)
+	} else if added {
+		utils.Info("Added package %s to %s", pkg, manifest.FileName)
+	}
+
+	utils.Success("Encrypted %s to %s", relPath, filepath.Join(pkg, pkgRelPath+secret.Suffix))
+	utils.Record("encrypt", repoPath, path)
+	return nil
+}
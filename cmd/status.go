@@ -0,0 +1,114 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/cetincetindag/dfmgr/pkg/dfmgr"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var (
+	statusJSON  bool
+	statusPlain bool
+)
+
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show drift between the repository and the home directory",
+	Long: `Report, for every file of every package apply would select, whether it is linked,
+missing, blocked by a real file (conflict), linked somewhere else, or a broken link.
+Rendered templates are reported as linked when up to date, outdated when the template
+changed since the last apply, or edited when the rendered file was changed by hand.
+Also reports uncommitted and unpushed changes in the dotfiles repository.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runStatusCommand(); err != nil {
+			utils.Error("Failed to get status: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().BoolVar(&statusJSON, "json", false, "Print the status as JSON")
+	statusCmd.Flags().BoolVar(&statusPlain, "plain", false, "Print the status without colors")
+}
+
+func runStatusCommand() error {
+	manager, err := newManager()
+	if err != nil {
+		return err
+	}
+
+	report, err := manager.Status()
+	if err != nil {
+		return err
+	}
+
+	if statusJSON || utils.JSONOutput() {
+		return printJSON(report)
+	}
+
+	if statusPlain {
+		color.NoColor = true
+	}
+	printStatus(report)
+	return nil
+}
+
+var stateColors = map[stow.FileState]func(format string, a ...interface{}) string{
+	stow.StateLinked:    color.GreenString,
+	stow.StateMissing:   color.YellowString,
+	stow.StateConflict:  color.RedString,
+	stow.StateElsewhere: color.MagentaString,
+	stow.StateBroken:    color.RedString,
+	stow.StateOutdated:  color.YellowString,
+	stow.StateEdited:    color.MagentaString,
+}
+
+func printStatus(report *dfmgr.StatusResult) {
+	pkg := ""
+	for _, f := range report.Files {
+		if f.Package != pkg {
+			pkg = f.Package
+			fmt.Println(color.CyanString(pkg))
+		}
+		line := fmt.Sprintf("  %-10s %s", stateColors[f.State]("%s", f.State), displayPath(f.Target))
+		if f.Detail != "" {
+			line += fmt.Sprintf(" (%s)", f.Detail)
+		}
+		fmt.Println(line)
+	}
+
+	fmt.Printf("\n%d linked, %d missing, %d conflicting, %d elsewhere, %d broken",
+		report.Summary[stow.StateLinked], report.Summary[stow.StateMissing], report.Summary[stow.StateConflict],
+		report.Summary[stow.StateElsewhere], report.Summary[stow.StateBroken])
+	if outdated, edited := report.Summary[stow.StateOutdated], report.Summary[stow.StateEdited]; outdated+edited > 0 {
+		fmt.Printf(", %d outdated, %d edited", outdated, edited)
+	}
+	fmt.Println()
+
+	repo := report.Repository
+	if repo == nil {
+		return
+	}
+
+	fmt.Printf("\n%s %s\n", color.CyanString("Repository"), displayPath(report.LocalPath))
+	branch := repo.Branch
+	if repo.Upstream != "" {
+		branch += fmt.Sprintf(" -> %s, %d ahead, %d behind", repo.Upstream, repo.Ahead, repo.Behind)
+	} else {
+		branch += " (no upstream)"
+	}
+	fmt.Printf("  branch %s\n", branch)
+
+	for _, change := range repo.Changes {
+		fmt.Printf("  %s\n", color.YellowString(change))
+	}
+	if repo.Clean() {
+		fmt.Printf("  %s\n", color.GreenString("nothing to commit or push"))
+	}
+}
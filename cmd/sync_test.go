@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/secret"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// syncHome points dfmgr at a temporary home directory holding files, with
+// an empty dotfiles repository at ~/dotfiles, and returns the home.
+func syncHome(t *testing.T, files map[string]string) string {
+	t.Helper()
+	home := t.TempDir()
+	for name, content := range files {
+		path := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	repo := filepath.Join(home, "dotfiles")
+	if err := os.MkdirAll(filepath.Join(repo, ".git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, savedHome := config.CurrentConfig, config.HomeDir
+	savedAdopt, savedPackage, savedOnConflict := adoptFiles, syncPackage, syncOnConflict
+	savedWriter := utils.Log.Writer
+	t.Cleanup(func() {
+		config.CurrentConfig, config.HomeDir = saved, savedHome
+		adoptFiles, syncPackage, syncOnConflict = savedAdopt, savedPackage, savedOnConflict
+		utils.Log.Writer = savedWriter
+	})
+	utils.Log.Writer = io.Discard
+	config.HomeDir = home
+	config.CurrentConfig.LocalPath = repo
+	config.CurrentConfig.MultiOS = false
+	adoptFiles, syncPackage = true, ""
+	return home
+}
+
+func TestSyncAdoptsFiles(t *testing.T) {
+	home := syncHome(t, map[string]string{
+		".bashrc":                "export EDITOR=nvim\n",
+		".config/nvim/init.lua":  "-- init\n",
+		".config/nvim/lua/a.lua": "return {}\n",
+	})
+
+	if err := runSyncCommand([]string{".bashrc", ".config/nvim"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for live, stored := range map[string]string{
+		".bashrc":      "dotfiles/bashrc/.bashrc",
+		".config/nvim": "dotfiles/nvim/.config/nvim",
+	} {
+		resolved, err := filepath.EvalSymlinks(filepath.Join(home, live))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := filepath.EvalSymlinks(filepath.Join(home, stored))
+		if resolved != want {
+			t.Errorf("~/%s resolves to %s, want %s", live, resolved, want)
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(home, "dotfiles/nvim/.config/nvim/lua/a.lua")); err != nil || string(data) != "return {}\n" {
+		t.Errorf("a.lua = %q, %v", data, err)
+	}
+
+	// Synced files are tracked already and left alone the second time.
+	if err := runSyncCommand([]string{".bashrc"}); err == nil {
+		t.Error("syncing a tracked file again reported success")
+	}
+}
+
+func TestSyncCopiesWithoutAdopt(t *testing.T) {
+	home := syncHome(t, map[string]string{".gitconfig": "[user]\n"})
+	adoptFiles = false
+	syncPackage = "git"
+
+	if err := runSyncCommand([]string{".gitconfig"}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Lstat(filepath.Join(home, ".gitconfig"))
+	if err != nil || !info.Mode().IsRegular() {
+		t.Errorf("~/.gitconfig was replaced: %v, %v", info, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(home, "dotfiles/git/.gitconfig")); err != nil || string(data) != "[user]\n" {
+		t.Errorf("repository copy = %q, %v", data, err)
+	}
+}
+
+func TestSyncSkipsFilesOutsideHome(t *testing.T) {
+	syncHome(t, nil)
+	outside := filepath.Join(t.TempDir(), "file")
+	os.WriteFile(outside, nil, 0644)
+
+	if err := runSyncCommand([]string{outside}); err == nil {
+		t.Error("syncing a file outside of the home directory succeeded")
+	}
+}
+
+func TestSyncLeavesOutDecryptedSecrets(t *testing.T) {
+	home := syncHome(t, map[string]string{
+		".config/foo/settings.json":                        "{}\n",
+		".config/foo/token":                                "plaintext\n",
+		".config/foo/api.key":                              "plaintext\n",
+		"dotfiles/foo/.config/foo/api.key" + secret.Suffix: "ciphertext\n",
+	})
+	// apply decrypted the token into the directory.
+	state, err := stow.LoadRenderState(stow.RenderStateFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.Record(filepath.Join(home, ".config/foo/token"), []byte("plaintext\n"))
+	if err := state.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	syncOnConflict = "merge"
+	if err := runSyncCommand([]string{".config/foo"}); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := filepath.Join(home, "dotfiles/foo/.config/foo")
+	for _, name := range []string{"token", "api.key"} {
+		if _, err := os.Lstat(filepath.Join(repo, name)); !os.IsNotExist(err) {
+			t.Errorf("decrypted %s was copied into the repository: %v", name, err)
+		}
+		info, err := os.Lstat(filepath.Join(home, ".config/foo", name))
+		if err != nil || !info.Mode().IsRegular() {
+			t.Errorf("~/.config/foo/%s was not left in place: %v", name, err)
+		}
+	}
+
+	if info, err := os.Lstat(filepath.Join(home, ".config/foo")); err != nil || !info.IsDir() {
+		t.Fatalf("~/.config/foo was replaced by a link: %v", err)
+	}
+	if info, err := os.Lstat(filepath.Join(home, ".config/foo/settings.json")); err != nil || info.Mode()&os.ModeSymlink == 0 {
+		t.Errorf("settings.json was not adopted: %v", err)
+	}
+}
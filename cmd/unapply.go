@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"github.com/cetincetindag/dfmgr/pkg/dfmgr"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/spf13/cobra"
+)
+
+var (
+	unapplyAll            bool
+	unapplyRestoreBackups bool
+)
+
+var unapplyCmd = &cobra.Command{
+	Use:   "unapply [packages...]",
+	Short: "Remove dotfile symlinks from the home directory",
+	Long: `Remove the symlinks dfmgr created for the given packages, along with any directories
+that only existed to hold them. Files that are not dfmgr links are never touched.
+Use --restore-backups to put back the most recent backed up originals.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := runUnapplyCommand(args); err != nil {
+			utils.Error("Failed to unapply dotfiles: %s", err)
+			utils.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(unapplyCmd)
+	unapplyCmd.Flags().BoolVarP(&unapplyAll, "all", "a", false, "Unapply every package")
+	unapplyCmd.Flags().BoolVarP(&unapplyRestoreBackups, "restore-backups", "r", false, "Restore the backed up originals of removed links")
+}
+
+func runUnapplyCommand(args []string) error {
+	manager, err := newManager()
+	if err != nil {
+		return err
+	}
+
+	result, err := manager.Unapply(dfmgr.UnapplyOptions{
+		Packages:       args,
+		All:            unapplyAll,
+		RestoreBackups: unapplyRestoreBackups,
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(result.Packages) > 0 {
+		utils.Success("Successfully unapplied dotfiles")
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+// Package testutil holds helpers shared by the tests of several packages.
+// Only tests may import it.
+package testutil
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// IsolateGit keeps git away from the configuration of whoever runs the
+// tests, giving it a temporary home and a fixed identity, and skips the
+// test when git is not installed.
+func IsolateGit(t *testing.T) {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_AUTHOR_NAME", "dfmgr")
+	t.Setenv("GIT_AUTHOR_EMAIL", "dfmgr@example.com")
+	t.Setenv("GIT_COMMITTER_NAME", "dfmgr")
+	t.Setenv("GIT_COMMITTER_EMAIL", "dfmgr@example.com")
+}
+
+// Git runs git with args in dir and fails the test when it fails.
+func Git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	if output, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %s: %s\n%s", strings.Join(args, " "), err, output)
+	}
+}
@@ -0,0 +1,401 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+const (
+	ManifestVersion = 1
+	manifestName    = "manifest.json"
+	filesDir        = "files"
+	timeFormat      = "20060102-150405"
+)
+
+// Entry records one backed up path, relative to the snapshot's root, along
+// with the metadata needed to put it back exactly as it was.
+type Entry struct {
+	Path    string      `json:"path"`
+	Package string      `json:"package,omitempty"`
+	Mode    os.FileMode `json:"mode"`
+	UID     int         `json:"uid"`
+	GID     int         `json:"gid"`
+	Link    string      `json:"link,omitempty"`
+	ModTime time.Time   `json:"mod_time"`
+}
+
+type Manifest struct {
+	Version int       `json:"version"`
+	ID      string    `json:"id"`
+	Created time.Time `json:"created"`
+	Root    string    `json:"root"`
+	Entries []Entry   `json:"entries"`
+}
+
+// Snapshot is a directory holding the files replaced by a single apply.
+// Files are stored below files/ with their full root-relative path, so
+// ~/.config/a/config and ~/.config/b/config never overwrite each other.
+type Snapshot struct {
+	Dir      string
+	Manifest Manifest
+
+	fs vfs.FS
+}
+
+// DefaultDir returns the directory all snapshots are kept in.
+func DefaultDir() string {
+	return filepath.Join(config.Home(), ".dfmgr_backup")
+}
+
+// New reserves a fresh, timestamped snapshot directory below backupDir for
+// files taken from root.
+func New(backupDir, root string) (*Snapshot, error) {
+	return NewFS(vfs.OS{}, backupDir, root)
+}
+
+// NewFS is New on the filesystem fsys, where both the snapshot and the
+// files it backs up live.
+func NewFS(fsys vfs.FS, backupDir, root string) (*Snapshot, error) {
+	now := time.Now()
+	id := now.Format(timeFormat)
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+	if err := fsys.MkdirAll(backupDir, 0700); err != nil {
+		return nil, err
+	}
+
+	dir := filepath.Join(backupDir, id)
+	for i := 2; ; i++ {
+		err := fsys.Mkdir(dir, 0700)
+		if err == nil {
+			break
+		}
+		if !os.IsExist(err) {
+			return nil, err
+		}
+		id = fmt.Sprintf("%s-%d", now.Format(timeFormat), i)
+		dir = filepath.Join(backupDir, id)
+	}
+
+	s := &Snapshot{
+		Dir: dir,
+		fs:  fsys,
+		Manifest: Manifest{
+			Version: ManifestVersion,
+			ID:      id,
+			Created: now,
+			Root:    root,
+			Entries: []Entry{},
+		},
+	}
+	return s, s.save()
+}
+
+// List returns every snapshot in backupDir, newest first.
+func List(backupDir string) ([]*Snapshot, error) {
+	entries, err := os.ReadDir(backupDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*Snapshot
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		s, err := load(vfs.OS{}, filepath.Join(backupDir, e.Name()))
+		if err != nil {
+			// Pre-snapshot backups have no manifest and are skipped.
+			continue
+		}
+		snapshots = append(snapshots, s)
+	}
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].newer(snapshots[j])
+	})
+	return snapshots, nil
+}
+
+// newer reports whether s was created after other. Snapshots created within
+// the same second are told apart by the number their ID ends with.
+func (s *Snapshot) newer(other *Snapshot) bool {
+	a, b := s.Manifest, other.Manifest
+	if !a.Created.Equal(b.Created) {
+		return a.Created.After(b.Created)
+	}
+	return sequence(a.ID) > sequence(b.ID)
+}
+
+// sequence returns the number New appended to id to make it unique, 1 for
+// the first snapshot of a second.
+func sequence(id string) int {
+	if len(id) <= len(timeFormat)+1 {
+		return 1
+	}
+	n, err := strconv.Atoi(id[len(timeFormat)+1:])
+	if err != nil {
+		return 1
+	}
+	return n
+}
+
+// Open loads the snapshot with the given ID. "latest" selects the newest.
+func Open(backupDir, id string) (*Snapshot, error) {
+	if id == "latest" {
+		snapshots, err := List(backupDir)
+		if err != nil {
+			return nil, err
+		}
+		if len(snapshots) == 0 {
+			return nil, fmt.Errorf("no backup snapshots found in %s", backupDir)
+		}
+		return snapshots[0], nil
+	}
+
+	return OpenDir(vfs.OS{}, filepath.Join(backupDir, id))
+}
+
+// OpenDir loads the snapshot in dir on the filesystem fsys.
+func OpenDir(fsys vfs.FS, dir string) (*Snapshot, error) {
+	s, err := load(fsys, dir)
+	if err != nil {
+		return nil, fmt.Errorf("backup snapshot %s not found: %w", filepath.Base(dir), err)
+	}
+	return s, nil
+}
+
+func load(fsys vfs.FS, dir string) (*Snapshot, error) {
+	data, err := fsys.ReadFile(filepath.Join(dir, manifestName))
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Snapshot{Dir: dir, fs: fsys}
+	if err := json.Unmarshal(data, &s.Manifest); err != nil {
+		return nil, fmt.Errorf("invalid manifest in %s: %w", dir, err)
+	}
+	return s, nil
+}
+
+func (s *Snapshot) save() error {
+	data, err := json.MarshalIndent(s.Manifest, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := filepath.Join(s.Dir, manifestName+".tmp")
+	if err := s.files().WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return s.files().Rename(tmp, filepath.Join(s.Dir, manifestName))
+}
+
+// Add copies path, which must be inside the snapshot root, into the
+// snapshot and records it in the manifest. Symlinks are stored as links.
+func (s *Snapshot) Add(pkg, path string) (string, error) {
+	rel, err := filepath.Rel(s.Manifest.Root, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is outside of %s", path, s.Manifest.Root)
+	}
+
+	info, err := s.files().Lstat(path)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return "", fmt.Errorf("%s is a directory", path)
+	}
+
+	entry := Entry{
+		Path:    rel,
+		Package: pkg,
+		Mode:    info.Mode(),
+		ModTime: info.ModTime(),
+	}
+	entry.UID, entry.GID = owner(info)
+
+	dest := s.filePath(rel)
+	if err := s.files().MkdirAll(filepath.Dir(dest), 0700); err != nil {
+		return "", err
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		link, err := s.files().Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		entry.Link = link
+	} else if err := copyFile(s.files(), path, dest, info.Mode().Perm()); err != nil {
+		return "", err
+	}
+
+	s.Manifest.Entries = append(s.Manifest.Entries, entry)
+	if err := s.save(); err != nil {
+		return "", err
+	}
+	return dest, nil
+}
+
+// Match returns the entries whose path equals, or lies below, one of paths.
+// No paths selects every entry.
+func (s *Snapshot) Match(paths []string) []Entry {
+	if len(paths) == 0 {
+		return s.Manifest.Entries
+	}
+
+	var result []Entry
+	for _, entry := range s.Manifest.Entries {
+		for _, p := range paths {
+			p = filepath.Clean(p)
+			if p == "." || entry.Path == p || strings.HasPrefix(entry.Path, p+string(filepath.Separator)) {
+				result = append(result, entry)
+				break
+			}
+		}
+	}
+	return result
+}
+
+// Restore puts entry back in place. Symlinks standing in the way, either at
+// the path itself or as a folded parent directory, are removed first when
+// removable reports them as ours; anything else is left alone and reported.
+func (s *Snapshot) Restore(entry Entry, removable func(link string) bool) error {
+	target := filepath.Join(s.Manifest.Root, entry.Path)
+
+	if err := s.clearParents(target, removable); err != nil {
+		return err
+	}
+
+	if info, err := s.files().Lstat(target); err == nil {
+		if info.Mode()&os.ModeSymlink == 0 || !removable(target) {
+			return fmt.Errorf("%s already exists and is not a dfmgr link", target)
+		}
+		if err := s.files().Remove(target); err != nil {
+			return err
+		}
+	}
+
+	if entry.Link != "" {
+		if err := s.files().Symlink(entry.Link, target); err != nil {
+			return err
+		}
+	} else if err := copyFile(s.files(), s.filePath(entry.Path), target, entry.Mode.Perm()); err != nil {
+		return err
+	}
+
+	chown(s.files(), target, entry.UID, entry.GID)
+	if entry.Link == "" {
+		s.files().Chtimes(target, entry.ModTime, entry.ModTime)
+	}
+	return nil
+}
+
+func (s *Snapshot) clearParents(target string, removable func(link string) bool) error {
+	rel, err := filepath.Rel(s.Manifest.Root, filepath.Dir(target))
+	if err != nil || rel == "." {
+		return nil
+	}
+
+	dir := s.Manifest.Root
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+
+		info, err := s.files().Lstat(dir)
+		if os.IsNotExist(err) {
+			if err := s.files().Mkdir(dir, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if info.Mode()&os.ModeSymlink != 0 {
+			if !removable(dir) {
+				return fmt.Errorf("%s is a symlink not managed by dfmgr", dir)
+			}
+			if err := s.unfold(dir); err != nil {
+				return err
+			}
+		} else if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+// unfold replaces the directory symlink dir, which folds a whole directory
+// of a package into one link, with a directory linking each of its entries,
+// so that a file can be restored into it without losing the others.
+func (s *Snapshot) unfold(dir string) error {
+	fsys := s.files()
+	link, err := fsys.Readlink(dir)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(link) {
+		link = filepath.Join(filepath.Dir(dir), link)
+	}
+	entries, err := fsys.ReadDir(link)
+	if err != nil {
+		return err
+	}
+
+	if err := fsys.Remove(dir); err != nil {
+		return err
+	}
+	if err := fsys.Mkdir(dir, 0755); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		source := filepath.Join(link, e.Name())
+		rel, err := filepath.Rel(dir, source)
+		if err != nil {
+			rel = source
+		}
+		if err := fsys.Symlink(rel, filepath.Join(dir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// files returns the filesystem of the snapshot, the real one for
+// snapshots that were loaded rather than created.
+func (s *Snapshot) files() vfs.FS {
+	return vfs.Or(s.fs)
+}
+
+func (s *Snapshot) filePath(rel string) string {
+	return filepath.Join(s.Dir, filesDir, rel)
+}
+
+func copyFile(fsys vfs.FS, src, dest string, perm os.FileMode) error {
+	data, err := fsys.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	if err := fsys.WriteFile(dest, data, perm); err != nil {
+		return err
+	}
+
+	// WriteFile only applies perm to new files and is subject to the umask.
+	return fsys.Chmod(dest, perm)
+}
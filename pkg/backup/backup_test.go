@@ -0,0 +1,189 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string, perm os.FileMode) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), perm); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAddAndRestore(t *testing.T) {
+	root, backupDir := t.TempDir(), t.TempDir()
+	config := filepath.Join(root, ".config", "app", "config")
+	writeFile(t, config, "theme=dark\n", 0640)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	os.Chtimes(config, modTime, modTime)
+	link := filepath.Join(root, ".profile")
+	if err := os.Symlink("/etc/profile", link); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := New(backupDir, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{config, link} {
+		if _, err := s.Add("app", path); err != nil {
+			t.Fatal(err)
+		}
+		os.Remove(path)
+	}
+	if _, err := s.Add("app", filepath.Join(t.TempDir(), "outside")); err == nil {
+		t.Error("Add of a path outside of the root succeeded")
+	}
+
+	// The snapshot is found again through its manifest.
+	opened, err := Open(backupDir, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened.Manifest.ID != s.Manifest.ID || len(opened.Manifest.Entries) != 2 {
+		t.Fatalf("Open(latest) = %+v", opened.Manifest)
+	}
+
+	for _, entry := range opened.Match(nil) {
+		if err := opened.Restore(entry, func(string) bool { return false }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	info, err := os.Stat(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 || !info.ModTime().Equal(modTime) {
+		t.Errorf("restored config has mode %v and time %v", info.Mode(), info.ModTime())
+	}
+	if data, _ := os.ReadFile(config); string(data) != "theme=dark\n" {
+		t.Errorf("restored config = %q", data)
+	}
+	if target, err := os.Readlink(link); err != nil || target != "/etc/profile" {
+		t.Errorf("restored link = %q, %v", target, err)
+	}
+}
+
+func TestRestoreReplacesOwnLinks(t *testing.T) {
+	root, backupDir := t.TempDir(), t.TempDir()
+	path := filepath.Join(root, ".bashrc")
+	writeFile(t, path, "original\n", 0644)
+
+	s, err := New(backupDir, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("bash", path); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(path)
+	if err := os.Symlink("dotfiles/bash/.bashrc", path); err != nil {
+		t.Fatal(err)
+	}
+
+	entries := s.Match([]string{".bashrc"})
+	if len(entries) != 1 {
+		t.Fatalf("Match = %+v", entries)
+	}
+	if err := s.Restore(entries[0], func(string) bool { return false }); err == nil {
+		t.Fatal("Restore replaced a link it was not allowed to remove")
+	}
+	if err := s.Restore(entries[0], func(string) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "original\n" {
+		t.Errorf(".bashrc = %q, %v", data, err)
+	}
+}
+
+func TestRestoreUnfoldsLinkedDirectory(t *testing.T) {
+	root, backupDir := t.TempDir(), t.TempDir()
+	path := filepath.Join(root, ".config", "nvim", "init.vim")
+	writeFile(t, path, "original\n", 0644)
+
+	s, err := New(backupDir, root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Add("nvim", path); err != nil {
+		t.Fatal(err)
+	}
+
+	// Apply folded .config/nvim into a link to the package.
+	nvim := filepath.Join(root, ".config", "nvim")
+	writeFile(t, filepath.Join(root, "dotfiles", "nvim", ".config", "nvim", "init.lua"), "vim.o.number = true\n", 0644)
+	os.RemoveAll(nvim)
+	if err := os.Symlink("../dotfiles/nvim/.config/nvim", nvim); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Restore(s.Match([]string{".config/nvim/init.vim"})[0], func(string) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(path); err != nil || string(data) != "original\n" {
+		t.Errorf("init.vim = %q, %v", data, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(nvim, "init.lua")); err != nil || string(data) != "vim.o.number = true\n" {
+		t.Errorf("init.lua = %q, %v, the rest of the folded directory was lost", data, err)
+	}
+}
+
+func TestListSkipsDirectoriesWithoutManifest(t *testing.T) {
+	backupDir := t.TempDir()
+	os.Mkdir(filepath.Join(backupDir, "old-backup"), 0755)
+
+	first, err := New(backupDir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := New(backupDir, t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	snapshots, err := List(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].Manifest.ID != second.Manifest.ID || snapshots[1].Manifest.ID != first.Manifest.ID {
+		t.Errorf("List returned %d snapshots, want %s then %s", len(snapshots), second.Manifest.ID, first.Manifest.ID)
+	}
+}
+
+func TestListOrdersSnapshotsOfTheSameSecond(t *testing.T) {
+	backupDir, root := t.TempDir(), t.TempDir()
+	var ids []string
+	for i := 0; i < 11; i++ {
+		s, err := New(backupDir, root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append([]string{s.Manifest.ID}, ids...)
+	}
+
+	snapshots, err := List(backupDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, s := range snapshots {
+		if s.Manifest.ID != ids[i] {
+			t.Fatalf("snapshot %d is %s, want %s", i, s.Manifest.ID, ids[i])
+		}
+	}
+}
+
+func TestSequence(t *testing.T) {
+	for id, want := range map[string]int{"20240102-150405": 1, "20240102-150405-2": 2, "20240102-150405-10": 10} {
+		if got := sequence(id); got != want {
+			t.Errorf("sequence(%q) = %d, want %d", id, got, want)
+		}
+	}
+}
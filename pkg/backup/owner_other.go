@@ -0,0 +1,15 @@
+//go:build !unix
+
+package backup
+
+import (
+	"os"
+
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+func owner(info os.FileInfo) (int, int) {
+	return -1, -1
+}
+
+func chown(fsys vfs.FS, path string, uid, gid int) {}
@@ -0,0 +1,26 @@
+//go:build unix
+
+package backup
+
+import (
+	"os"
+	"syscall"
+
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+func owner(info os.FileInfo) (int, int) {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return int(stat.Uid), int(stat.Gid)
+	}
+	return -1, -1
+}
+
+// chown restores ownership on a best-effort basis; unprivileged users can
+// only give files to themselves, which is what they already are.
+func chown(fsys vfs.FS, path string, uid, gid int) {
+	if uid < 0 || gid < 0 {
+		return
+	}
+	fsys.Lchown(path, uid, gid)
+}
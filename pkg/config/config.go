@@ -11,11 +11,25 @@ import (
 )
 
 type Config struct {
-	GithubUsername string            `json:"github_username"`
-	MultiOS        bool              `json:"multi_os"`
-	OSSeparation   map[string]string `json:"os_separation"`
-	DotfilesRepo   string            `json:"dotfiles_repo"`
-	LocalPath      string            `json:"local_path"`
+	GithubUsername   string             `json:"github_username"`
+	MultiOS          bool               `json:"multi_os"`
+	OSSeparation     map[string]string  `json:"os_separation"`
+	DotfilesRepo     string             `json:"dotfiles_repo"`
+	LocalPath        string             `json:"local_path"`
+	RemoteURL        string             `json:"remote_url,omitempty"`
+	GitHost          string             `json:"git_host,omitempty"`
+	GitProtocol      string             `json:"git_protocol,omitempty"`
+	Forge            string             `json:"forge,omitempty"`
+	ForgeURL         string             `json:"forge_url,omitempty"`
+	ForgeToken       string             `json:"forge_token,omitempty"`
+	RemoteVisibility string             `json:"remote_visibility,omitempty"`
+	LinkBackend      string             `json:"link_backend,omitempty"`
+	Templates        []string           `json:"templates,omitempty"`
+	Variables        map[string]string  `json:"variables,omitempty"`
+	SecretKey        string             `json:"secret_key,omitempty"`
+	Profile          string             `json:"profile,omitempty"`
+	Profiles         map[string]Profile `json:"profiles,omitempty"`
+	HostRules        []HostRule         `json:"host_rules,omitempty"`
 }
 
 var (
@@ -24,25 +38,82 @@ var (
 		MultiOS:        false,
 		OSSeparation:   make(map[string]string),
 		DotfilesRepo:   "dotfiles",
-		LocalPath:      filepath.Join(os.Getenv("HOME"), "dotfiles"),
+		LocalPath:      filepath.Join(Home(), "dotfiles"),
 	}
 
 	CurrentConfig = DefaultConfig
+
+	// HomeDir is the home directory dfmgr links into and keeps its state
+	// in, $HOME when empty. Tests point it at a temporary directory.
+	HomeDir string
 )
 
+// Home returns the home directory dfmgr works in.
+func Home() string {
+	if HomeDir != "" {
+		return HomeDir
+	}
+	return os.Getenv("HOME")
+}
+
 func init() {
-	CurrentConfig.OSSeparation = map[string]string{
+	CurrentConfig.OSSeparation = Defaults().OSSeparation
+}
+
+// Defaults returns the configuration used until a configuration file is
+// loaded.
+func Defaults() Config {
+	cfg := DefaultConfig
+	cfg.LocalPath = filepath.Join(Home(), "dotfiles")
+	cfg.OSSeparation = map[string]string{
 		"darwin":  "macos",
 		"linux":   "linux",
 		"windows": "windows",
 	}
+	return cfg
+}
+
+// Copy returns a copy of c that shares no map or slice with it.
+func (c Config) Copy() Config {
+	c.OSSeparation = copyMap(c.OSSeparation)
+	c.Variables = copyMap(c.Variables)
+	c.Templates = append([]string(nil), c.Templates...)
+	
+	if c.Profiles != nil {
+		profiles := make(map[string]Profile, len(c.Profiles))
+		for name, profile := range c.Profiles {
+			profile.Packages = append([]string(nil), profile.Packages...)
+			profile.Exclude = append([]string(nil), profile.Exclude...)
+			profiles[name] = profile
+		}
+		c.Profiles = profiles
+	}
+	
+	rules := c.HostRules
+	c.HostRules = nil
+	for _, rule := range rules {
+		rule.Packages = append([]string(nil), rule.Packages...)
+		c.HostRules = append(c.HostRules, rule)
+	}
+	return c
+}
+
+func copyMap(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	copied := make(map[string]string, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
 }
 
 func ConfigFile() string {
 	if os.Getenv("DFMGR_CONFIG") != "" {
 		return os.Getenv("DFMGR_CONFIG")
 	}
-	return filepath.Join(os.Getenv("HOME"), ".dfmgr")
+	return filepath.Join(Home(), ".dfmgr")
 }
 
 func LoadConfig(cfgFile string) {
@@ -50,24 +121,39 @@ func LoadConfig(cfgFile string) {
 		cfgFile = ConfigFile()
 	}
 
-	_, err := os.Stat(cfgFile)
-	if os.IsNotExist(err) {
+	cfg, err := Read(cfgFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s Unable to load config file: %s\n", color.RedString("[ERROR]"), err)
 		return
 	}
+	CurrentConfig = cfg
+
+	if info, err := os.Stat(cfgFile); err == nil && cfg.ForgeToken != "" && info.Mode().Perm()&0077 != 0 {
+		fmt.Fprintf(os.Stderr, "%s %s holds forge_token but other users can read it, run 'chmod 600 %s'\n", color.YellowString("[WARNING]"), cfgFile, cfgFile)
+	}
+}
+
+// Read returns the configuration stored in cfgFile on top of the defaults,
+// or the defaults when there is no such file.
+func Read(cfgFile string) (Config, error) {
+	cfg := Defaults()
 
 	data, err := os.ReadFile(cfgFile)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s Unable to read config file: %s\n", color.RedString("[ERROR]"), err)
-		return
+		return cfg, fmt.Errorf("failed to read %s: %w", cfgFile, err)
 	}
 
-	err = json.Unmarshal(data, &CurrentConfig)
-	if err != nil {
-		fmt.Fprintf(os.Stderr, "%s Unable to parse config file: %s\n", color.RedString("[ERROR]"), err)
-		return
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return cfg, fmt.Errorf("failed to parse %s: %w", cfgFile, err)
 	}
+	return cfg, nil
 }
 
+// SaveConfig writes CurrentConfig to the configuration file, readable by the
+// user only since it may hold forge_token.
 func SaveConfig() error {
 	data, err := json.MarshalIndent(CurrentConfig, "", "  ")
 	if err != nil {
@@ -75,14 +161,41 @@ func SaveConfig() error {
 	}
 
 	cfgFile := ConfigFile()
-	err = os.WriteFile(cfgFile, data, 0644)
+	err = os.WriteFile(cfgFile, data, 0600)
 	if err != nil {
 		return fmt.Errorf("failed to write config file: %w", err)
 	}
+	// WriteFile keeps the mode of an existing file.
+	if err := os.Chmod(cfgFile, 0600); err != nil {
+		return fmt.Errorf("failed to write config file: %w", err)
+	}
 
 	return nil
 }
 
+const (
+	DefaultGitHost = "github.com"
+	ProtocolSSH    = "ssh"
+	ProtocolHTTPS  = "https"
+)
+
+// GitHost returns the host repositories given by owner name live on.
+func GitHost() string {
+	if CurrentConfig.GitHost != "" {
+		return CurrentConfig.GitHost
+	}
+	return DefaultGitHost
+}
+
+// GitProtocol returns the protocol, ssh or https, used to reach
+// repositories given by owner name or host:owner/repo.
+func GitProtocol() string {
+	if CurrentConfig.GitProtocol == ProtocolHTTPS {
+		return ProtocolHTTPS
+	}
+	return ProtocolSSH
+}
+
 func GetCurrentOS() string {
 	return runtime.GOOS
 }
@@ -98,4 +211,27 @@ func GetOSFolder() string {
 	}
 	
 	return os
-} 
\ No newline at end of file
+} 
+
+// CommonLayer is the folder of packages every OS receives with MultiOS.
+const CommonLayer = "common"
+
+// HostsLayer holds one folder of packages per hostname with MultiOS.
+const HostsLayer = "hosts"
+
+// Layers returns the folders packages are read from with MultiOS, from the
+// lowest precedence to the highest: shared packages, the OS folders (see
+// OSFolders) and the folder of this host. Files in a higher layer override
+// the same file in a lower one.
+func Layers() []string {
+	if !CurrentConfig.MultiOS {
+		return nil
+	}
+
+	hostname, _ := os.Hostname()
+	layers := append([]string{CommonLayer}, OSFolders()...)
+	if hostname != "" {
+		layers = append(layers, filepath.Join(HostsLayer, hostname))
+	}
+	return layers
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestSaveConfigIsPrivate(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no permission bits on Windows")
+	}
+	cfgFile := filepath.Join(t.TempDir(), ".dfmgr")
+	t.Setenv("DFMGR_CONFIG", cfgFile)
+	if err := os.WriteFile(cfgFile, []byte("{}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved := CurrentConfig
+	t.Cleanup(func() { CurrentConfig = saved })
+	CurrentConfig = Defaults()
+	CurrentConfig.ForgeToken = "secret"
+
+	if err := SaveConfig(); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(cfgFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm != 0600 {
+		t.Errorf("config file mode = %04o, want 0600", perm)
+	}
+}
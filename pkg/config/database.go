@@ -1,5 +1,7 @@
 package config
 
+import "path/filepath"
+
 type ConfigFileInfo struct {
 	Name        string
 	Description string
@@ -144,4 +146,20 @@ func ListCategories() []string {
 	}
 	
 	return result
-} 
\ No newline at end of file
+}
+
+// FindByBasename returns the home-relative path of the single database
+// entry whose last path element is name, e.g. "nvim" -> ".config/nvim".
+// Ambiguous names such as "config" are not resolved.
+func FindByBasename(name string) (string, bool) {
+	match := ""
+	for path := range ConfigFileDatabase {
+		if path != name && filepath.Base(path) == name {
+			if match != "" {
+				return "", false
+			}
+			match = path
+		}
+	}
+	return match, match != ""
+}
@@ -0,0 +1,167 @@
+package config
+
+import (
+	"bufio"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Facts describe the machine dfmgr runs on beyond its operating system.
+type Facts struct {
+	OS   string `json:"os"`
+	Arch string `json:"arch"`
+
+	// Distro is the ID of the Linux distribution from os-release, such as
+	// "arch" or "debian", and DistroLike the distributions it derives from.
+	Distro        string   `json:"distro,omitempty"`
+	DistroLike    []string `json:"distro_like,omitempty"`
+	DistroVersion string   `json:"distro_version,omitempty"`
+
+	WSL bool `json:"wsl,omitempty"`
+
+	// Container names the container runtime, e.g. "docker" or "podman",
+	// when running inside one.
+	Container string `json:"container,omitempty"`
+}
+
+var (
+	osReleaseFiles = []string{"/etc/os-release", "/usr/lib/os-release"}
+	procVersion    = "/proc/version"
+	procCgroup     = "/proc/1/cgroup"
+
+	currentFacts     Facts
+	currentFactsOnce sync.Once
+)
+
+// CurrentFacts detects the facts of this machine once and returns them.
+func CurrentFacts() Facts {
+	currentFactsOnce.Do(func() {
+		currentFacts = DetectFacts()
+	})
+	return currentFacts
+}
+
+// DetectFacts reads os-release, /proc/version for WSL and the usual
+// container markers. Anything that cannot be read is left empty.
+func DetectFacts() Facts {
+	facts := Facts{OS: runtime.GOOS, Arch: runtime.GOARCH}
+	if facts.OS != "linux" {
+		return facts
+	}
+
+	for _, path := range osReleaseFiles {
+		release, err := readOSRelease(path)
+		if err != nil {
+			continue
+		}
+		facts.Distro = strings.ToLower(release["ID"])
+		facts.DistroLike = strings.Fields(strings.ToLower(release["ID_LIKE"]))
+		facts.DistroVersion = release["VERSION_ID"]
+		break
+	}
+
+	if os.Getenv("WSL_DISTRO_NAME") != "" {
+		facts.WSL = true
+	} else if version, err := os.ReadFile(procVersion); err == nil {
+		facts.WSL = strings.Contains(strings.ToLower(string(version)), "microsoft")
+	}
+
+	facts.Container = detectContainer()
+	return facts
+}
+
+func readOSRelease(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			value = unquoted
+		} else {
+			value = strings.Trim(value, `'"`)
+		}
+		values[key] = value
+	}
+	return values, scanner.Err()
+}
+
+func detectContainer() string {
+	if name := os.Getenv("container"); name != "" {
+		return name
+	}
+	if _, err := os.Stat("/.dockerenv"); err == nil {
+		return "docker"
+	}
+	if _, err := os.Stat("/run/.containerenv"); err == nil {
+		return "podman"
+	}
+	if cgroup, err := os.ReadFile(procCgroup); err == nil {
+		for _, name := range []string{"docker", "kubepods", "lxc", "containerd"} {
+			if strings.Contains(string(cgroup), name) {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// Names returns the names the machine is known by in os_separation and in
+// the os list of manifest packages, from the most specific to the least
+// specific. An Arch Linux machine running under WSL on arm64 is known as
+// "linux-wsl", "linux/arch", "linux-arm64" and "linux".
+func (f Facts) Names() []string {
+	var names []string
+	if f.WSL {
+		names = append(names, f.OS+"-wsl")
+	}
+	if f.Container != "" {
+		names = append(names, f.OS+"-container")
+	}
+	if f.Distro != "" {
+		names = append(names, f.OS+"/"+f.Distro)
+	}
+	for _, like := range f.DistroLike {
+		names = append(names, f.OS+"/"+like)
+	}
+	return append(names, f.OS+"-"+f.Arch, f.OS)
+}
+
+// OSFolders returns the OS folders of this machine with MultiOS, from the
+// least specific to the most specific: the folder of the operating system,
+// then the folders os_separation maps the other names of the machine to,
+// e.g. "linux/arch": "arch".
+func OSFolders() []string {
+	if !CurrentConfig.MultiOS {
+		return nil
+	}
+
+	folders := []string{GetOSFolder()}
+	names := CurrentFacts().Names()
+	for i := len(names) - 1; i >= 0; i-- {
+		folder, ok := CurrentConfig.OSSeparation[names[i]]
+		if !ok {
+			continue
+		}
+		seen := false
+		for _, f := range folders {
+			seen = seen || f == folder
+		}
+		if !seen {
+			folders = append(folders, folder)
+		}
+	}
+	return folders
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// AllProfile selects every package, ignoring host rules.
+const AllProfile = "all"
+
+// Profile is a named set of packages, e.g. "work" or "server". Packages and
+// Exclude are glob patterns matched against package names.
+type Profile struct {
+	Packages []string `json:"packages,omitempty"`
+	Exclude  []string `json:"exclude,omitempty"`
+}
+
+// HostRule applies to machines whose hostname matches the Match glob. It
+// selects a profile when none is active and adds packages of its own.
+type HostRule struct {
+	Match    string   `json:"match"`
+	Profile  string   `json:"profile,omitempty"`
+	Packages []string `json:"packages,omitempty"`
+}
+
+// Selection is the outcome of resolving profiles and host rules for one
+// machine.
+type Selection struct {
+	Profile  string
+	Rules    []HostRule
+	Include  []string
+	Exclude  []string
+	Filtered bool
+}
+
+// Select resolves which packages a machine receives. The active profile is
+// the one set in the configuration, or the first one named by a matching
+// host rule. Without a profile or matching host rule nothing is filtered.
+func Select(profiles map[string]Profile, rules []HostRule, active, hostname string) (*Selection, error) {
+	s := &Selection{Profile: active}
+	if active == AllProfile {
+		return s, nil
+	}
+
+	for _, rule := range rules {
+		if matched, _ := filepath.Match(rule.Match, hostname); !matched {
+			continue
+		}
+		s.Rules = append(s.Rules, rule)
+		if s.Profile == "" {
+			s.Profile = rule.Profile
+		}
+		s.Include = append(s.Include, rule.Packages...)
+	}
+
+	if s.Profile != "" {
+		profile, ok := profiles[s.Profile]
+		if !ok {
+			return nil, fmt.Errorf("unknown profile %q (available: %s)", s.Profile, ProfileNames(profiles))
+		}
+		if len(profile.Packages) == 0 {
+			// A profile without packages selects every package.
+			s.Include = nil
+		} else {
+			s.Include = append(s.Include, profile.Packages...)
+		}
+		s.Exclude = profile.Exclude
+	}
+
+	s.Filtered = len(s.Include) > 0 || len(s.Exclude) > 0
+	return s, nil
+}
+
+// Matches reports whether the selection keeps the package at path. Patterns
+// match either the full package path or its last element, so "i3" also
+// selects "linux/i3".
+func (s *Selection) Matches(path string) bool {
+	if len(s.Include) > 0 && !matchAny(s.Include, path) {
+		return false
+	}
+	return !matchAny(s.Exclude, path)
+}
+
+func matchAny(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := filepath.Match(pattern, filepath.ToSlash(path)); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, filepath.Base(path)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+func ProfileNames(profiles map[string]Profile) string {
+	names := []string{AllProfile}
+	for name := range profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
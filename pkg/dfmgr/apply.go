@@ -0,0 +1,435 @@
+package dfmgr
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/cetincetindag/dfmgr/pkg/backup"
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/hooks"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// ApplyOptions selects what Apply links.
+type ApplyOptions struct {
+	// Packages are the packages to apply, by path or by name without
+	// their OS folder ("nvim" for "linux/nvim"). Empty applies every
+	// package selected for this machine.
+	Packages []string
+
+	// DryRun plans the changes without making them.
+	DryRun bool
+
+	// OverwriteEdited backs up and replaces rendered files that were
+	// edited by hand instead of reporting them as conflicts.
+	OverwriteEdited bool
+}
+
+// ApplyResult describes what Apply did, or would do with DryRun.
+type ApplyResult struct {
+	Packages []string `json:"packages"`
+
+	// Plan holds the changes of the built-in linker. It is nil with the
+	// stow backend, which plans on its own.
+	Plan *stow.Plan `json:"plan,omitempty"`
+
+	// Snapshot is the ID of the backup snapshot holding the files Apply
+	// replaced, empty when nothing was replaced.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// MissingTools are tools the manifest requires that are not in PATH.
+	MissingTools []string `json:"missing_tools,omitempty"`
+
+	// RolledBack is set when Apply failed or was interrupted and undid
+	// the changes it had made.
+	RolledBack bool `json:"rolled_back,omitempty"`
+
+	Actions []utils.Action `json:"actions"`
+}
+
+// Apply links packages into the home directory, backing up the files in
+// their way. Every change is journaled first: when Apply fails, or gets
+// SIGINT or SIGTERM with WithSignals, it undoes them, and Recover undoes
+// those of an Apply that died. An aborting after_apply hook fails Apply
+// without undoing anything. On error the result describes the changes made and undone.
+func (m *Manager) Apply(opts ApplyOptions) (*ApplyResult, error) {
+	result := &ApplyResult{Packages: []string{}}
+	actions, err := m.do(func() error {
+		return apply(opts, result, m.signals)
+	})
+	result.Actions = actions
+	return result, err
+}
+
+// apply links the packages of opts, catching SIGINT and SIGTERM meanwhile
+// when signals is set.
+func apply(opts ApplyOptions, result *ApplyResult, signals bool) error {
+	localPath := config.CurrentConfig.LocalPath
+
+	packages, err := selectPackages(opts.Packages)
+	if err != nil {
+		return err
+	}
+	result.Packages = packages
+
+	linker, err := stow.ApplyLinker()
+	if err != nil {
+		return err
+	}
+	linker.ReplaceEdited = opts.OverwriteEdited
+
+	if config.CurrentConfig.LinkBackend == stow.BackendStow {
+		if err := stow.CheckStowable(linker, packages); err != nil {
+			return err
+		}
+	}
+
+	if opts.DryRun {
+		if result.Plan, err = linker.Plan(packages); err != nil {
+			return fmt.Errorf("failed to plan apply: %w", err)
+		}
+		return nil
+	}
+
+	if len(packages) == 0 {
+		utils.Warning("No packages to apply")
+		return nil
+	}
+
+	result.MissingTools = stow.MissingTools(packages)
+	for _, tool := range result.MissingTools {
+		utils.Warning("Required tool is not installed: %s", tool)
+	}
+
+	stow.EnforceModes(localPath)
+
+	plan, err := linker.Plan(packages)
+	if err != nil {
+		return err
+	}
+	if config.CurrentConfig.LinkBackend != stow.BackendStow {
+		if err := plan.Err(); err != nil {
+			return err
+		}
+	}
+	changes := planChanges(plan)
+	if err := hooks.Run(hooks.BeforeApply, changes); err != nil {
+		return err
+	}
+
+	journal, err := stow.StartJournal(linker.FS, stow.JournalFile())
+	if err != nil {
+		return err
+	}
+	if signals {
+		journal.Watch()
+		defer journal.Close()
+	}
+	linker.Journal = journal
+
+	if err := link(linker, plan, packages, result); err != nil {
+		if len(journal.Steps) == 0 {
+			journal.Finish()
+			return err
+		}
+		utils.Warning("Apply failed, rolling back %d change(s)", len(journal.Steps))
+		if rollbackErr := linker.Rollback(journal); rollbackErr != nil {
+			return fmt.Errorf("%w; rollback failed: %s, run 'dfmgr recover' to retry", err, rollbackErr)
+		}
+		result.RolledBack = true
+		utils.Info("Rolled back, the home directory is as it was before apply")
+		return err
+	}
+	if err := journal.Finish(); err != nil {
+		return err
+	}
+
+	// The links are made; a failing after_apply hook is reported but
+	// leaves them in place.
+	if err := hooks.Run(hooks.AfterApply, changes); err != nil {
+		return fmt.Errorf("%w, the dotfiles were applied", err)
+	}
+	return nil
+}
+
+// link makes the changes of apply with the configured backend. The stow
+// backend plans on its own; plan only tells the journal what it changes.
+func link(linker *stow.Linker, plan *stow.Plan, packages []string, result *ApplyResult) error {
+	localPath := config.CurrentConfig.LocalPath
+
+	if config.CurrentConfig.LinkBackend == stow.BackendStow {
+		backupDir := backup.DefaultDir()
+		for target, group := range stow.GroupByTarget(linker, packages) {
+			if err := stow.BackupAndRemoveConflicts(localPath, target, backupDir, group, linker.Journal); err != nil {
+				return err
+			}
+			if err := stow.StowJournaled(localPath, target, group, plan, linker.Journal); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	result.Plan = plan
+
+	for _, skipped := range plan.Skipped {
+		utils.Warning("Skipping secret %s: %s", skipped.Target, skipped.Reason)
+	}
+
+	utils.Info("Symlinking packages: %s", strings.Join(packages, ", "))
+	err := linker.Execute(plan)
+	if snapshot := linker.Snapshot(); snapshot != nil {
+		id := snapshot.Manifest.ID
+		result.Snapshot = id
+		if err == nil {
+			utils.Info("Replaced files were saved to backup snapshot %s (undo with 'dfmgr restore %s')", id, id)
+		}
+	}
+	return err
+}
+
+// planChanges groups the paths plan changes by package, for hooks.
+func planChanges(plan *stow.Plan) hooks.Changes {
+	changes := make(hooks.Changes)
+	seen := make(map[string]bool)
+	for _, action := range plan.Actions {
+		if action.Type == stow.ActionBackup || seen[action.Target] {
+			continue
+		}
+		seen[action.Target] = true
+		changes.Add(action.Package, action.Target)
+	}
+	return changes
+}
+
+// RecoverOptions says how Recover deals with an interrupted apply.
+type RecoverOptions struct {
+	// Discard deletes the journal without undoing anything, keeping the
+	// home directory as the interrupted apply left it.
+	Discard bool
+}
+
+// RecoverResult describes what Recover undid.
+type RecoverResult struct {
+	// Started is when the interrupted apply began, zero when there was
+	// nothing to recover.
+	Started time.Time `json:"started"`
+
+	Steps   int            `json:"steps"`
+	Actions []utils.Action `json:"actions"`
+}
+
+// Recover undoes the changes of an apply that died before it finished or
+// could roll back, as recorded in its journal.
+func (m *Manager) Recover(opts RecoverOptions) (*RecoverResult, error) {
+	result := &RecoverResult{}
+	actions, err := m.do(func() error {
+		return recoverJournal(opts, result)
+	})
+	result.Actions = actions
+	return result, err
+}
+
+func recoverJournal(opts RecoverOptions, result *RecoverResult) error {
+	journal, err := stow.LoadJournal(nil, stow.JournalFile())
+	if err != nil {
+		return err
+	}
+	if journal == nil {
+		utils.Info("No interrupted apply to recover")
+		return nil
+	}
+	result.Started = journal.Started
+	result.Steps = len(journal.Steps)
+
+	if opts.Discard {
+		if err := journal.Finish(); err != nil {
+			return err
+		}
+		utils.Warning("Discarded the journal of the apply started %s without undoing its %d change(s)", journal.Started.Format("2006-01-02 15:04:05"), len(journal.Steps))
+		return nil
+	}
+
+	linker, err := stow.NewLinker()
+	if err != nil {
+		return err
+	}
+
+	utils.Info("Undoing %d change(s) of the apply started %s", len(journal.Steps), journal.Started.Format("2006-01-02 15:04:05"))
+	if err := linker.Rollback(journal); err != nil {
+		return err
+	}
+	utils.Success("Recovered, the home directory is as it was before apply")
+	return nil
+}
+
+// UnapplyOptions selects what Unapply removes.
+type UnapplyOptions struct {
+	// Packages are the packages to unapply, named as for Apply. Packages
+	// the profile or host rules leave out can be unapplied too.
+	Packages []string
+
+	// All unapplies every package of the repository instead.
+	All bool
+
+	// RestoreBackups puts back the most recent backed up original of
+	// every removed path.
+	RestoreBackups bool
+}
+
+// UnapplyResult describes what Unapply did.
+type UnapplyResult struct {
+	Packages []string `json:"packages"`
+
+	// Removed lists the links and rendered files removed.
+	Removed []string `json:"removed"`
+
+	// Restored lists the home-relative paths put back from backups.
+	Restored []string `json:"restored"`
+
+	Actions []utils.Action `json:"actions"`
+}
+
+// Unapply removes the links Apply created for packages, along with any
+// directories that only existed to hold them. Files that are not dfmgr
+// links are never touched.
+func (m *Manager) Unapply(opts UnapplyOptions) (*UnapplyResult, error) {
+	result := &UnapplyResult{Packages: []string{}, Removed: []string{}, Restored: []string{}}
+	actions, err := m.do(func() error {
+		return unapply(opts, result)
+	})
+	result.Actions = actions
+	return result, err
+}
+
+func unapply(opts UnapplyOptions, result *UnapplyResult) error {
+	if len(opts.Packages) == 0 && !opts.All {
+		return fmt.Errorf("specify the packages to unapply or use --all")
+	}
+
+	available, err := stow.AllPackages()
+	if err != nil {
+		return err
+	}
+	packages := available
+	if !opts.All {
+		if packages, err = stow.MatchPackages(available, opts.Packages); err != nil {
+			return err
+		}
+	}
+	if len(packages) == 0 {
+		utils.Warning("No packages to unapply")
+		return nil
+	}
+	result.Packages = packages
+
+	linker, err := stow.NewLinker()
+	if err != nil {
+		return err
+	}
+	plan, err := linker.PlanUnlink(packages)
+	if err != nil {
+		return err
+	}
+
+	rendered := make(map[string]bool)
+	changes := make(hooks.Changes)
+	for _, action := range plan.Actions {
+		switch action.Type {
+		case stow.ActionUnlink:
+			result.Removed = append(result.Removed, action.Target)
+			changes.Add(action.Package, action.Target)
+		case stow.ActionRemove:
+			result.Removed = append(result.Removed, action.Target)
+			changes.Add(action.Package, action.Target)
+			rendered[action.Target] = true
+		}
+	}
+
+	if len(result.Removed) == 0 {
+		utils.Warning("No dfmgr links found for: %s", strings.Join(packages, ", "))
+	} else if config.CurrentConfig.LinkBackend == stow.BackendStow {
+		for target, group := range stow.GroupByTarget(linker, packages) {
+			if err := stow.UnstowPackages(config.CurrentConfig.LocalPath, target, group); err != nil {
+				return err
+			}
+		}
+	} else {
+		utils.Info("Removing symlinks: %s", strings.Join(packages, ", "))
+		if err := linker.Execute(plan); err != nil {
+			return err
+		}
+	}
+
+	for _, target := range result.Removed {
+		if rendered[target] {
+			utils.Info("Removed rendered file: %s", target)
+		} else {
+			utils.Info("Removed link: %s", target)
+		}
+	}
+
+	if opts.RestoreBackups && len(result.Removed) > 0 {
+		if err := restoreLatest(config.Home(), result); err != nil {
+			return err
+		}
+	}
+	return hooks.Run(hooks.AfterUnapply, changes)
+}
+
+// restoreLatest puts back the newest backup of each removed path, or of
+// the files below it for links that replaced a whole directory.
+func restoreLatest(home string, result *UnapplyResult) error {
+	snapshots, err := backup.List(backup.DefaultDir())
+	if err != nil {
+		return err
+	}
+
+	var paths []string
+	for _, target := range result.Removed {
+		if rel, err := filepath.Rel(home, target); err == nil {
+			paths = append(paths, rel)
+		}
+	}
+
+	seen := make(map[string]bool)
+	failed := 0
+	for _, snapshot := range snapshots {
+		for _, entry := range snapshot.Match(paths) {
+			if seen[entry.Path] {
+				continue
+			}
+			seen[entry.Path] = true
+
+			if err := snapshot.Restore(entry, stow.IsManagedLink); err != nil {
+				utils.Warning("Failed to restore %s: %s", entry.Path, err)
+				failed++
+				continue
+			}
+			utils.Success("Restored %s from snapshot %s", entry.Path, snapshot.Manifest.ID)
+			utils.Record("restore", entry.Path, snapshot.Manifest.ID)
+			result.Restored = append(result.Restored, entry.Path)
+		}
+	}
+
+	if len(seen) == 0 {
+		utils.Info("No backed up originals found for the removed links")
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to restore %d file(s)", failed)
+	}
+	return nil
+}
+
+// selectPackages returns the packages selected for this machine, narrowed
+// down to names unless it is empty.
+func selectPackages(names []string) ([]string, error) {
+	available, err := stow.SelectPackages(false)
+	if err != nil || len(names) == 0 {
+		return available, err
+	}
+	return stow.MatchPackages(available, names)
+}
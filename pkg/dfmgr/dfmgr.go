@@ -0,0 +1,147 @@
+// Package dfmgr is the Go API of dfmgr, for programs that manage dotfiles
+// in-process instead of running the dfmgr command. A Manager applies,
+// syncs and publishes the dotfiles repository of a config.Config and
+// describes what every operation changed in its result:
+//
+//	cfg, err := config.Read(config.ConfigFile())
+//	if err != nil {
+//		return err
+//	}
+//	m, err := dfmgr.New(cfg)
+//	if err != nil {
+//		return err
+//	}
+//	result, err := m.Apply(dfmgr.ApplyOptions{Packages: []string{"nvim"}})
+package dfmgr
+
+import (
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/hooks"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// Manager runs dfmgr operations on the dotfiles repository and home
+// directory of one configuration. Unless created with WithPrompts it never
+// asks questions: values that are not given fail the operation instead.
+//
+// The packages dfmgr is built from read the configuration, the home
+// directory, the logger and the command runner from package variables. A
+// Manager sets them for the duration of each operation, so the operations
+// of every Manager in a process run one at a time. A Manager must not run
+// concurrently with any other use of those packages either, such as
+// calling pkg/stow or pkg/git directly or reading config.CurrentConfig:
+// that use would see the settings of the Manager, or change them under it.
+type Manager struct {
+	config  config.Config
+	home    string
+	logger  *utils.Logger
+	runner  utils.Runner
+	prompts bool
+	noHooks bool
+	signals bool
+}
+
+// Option customizes a Manager.
+type Option func(*Manager)
+
+// WithHome links into, and keeps the state of dfmgr in, dir instead of
+// $HOME.
+func WithHome(dir string) Option {
+	return func(m *Manager) { m.home = dir }
+}
+
+// WithLogger reports progress to logger. By default messages are
+// discarded; results describe the changes either way.
+func WithLogger(logger *utils.Logger) Option {
+	return func(m *Manager) { m.logger = logger }
+}
+
+// WithRunner runs git, stow and gh through runner.
+func WithRunner(runner utils.Runner) Option {
+	return func(m *Manager) { m.runner = runner }
+}
+
+// WithPrompts lets operations ask on the terminal for what they were not
+// told, such as what to do with a file already in the repository.
+func WithPrompts() Option {
+	return func(m *Manager) { m.prompts = true }
+}
+
+// WithoutHooks never runs the hooks the repository manifest declares.
+func WithoutHooks() Option {
+	return func(m *Manager) { m.noHooks = true }
+}
+
+// WithSignals makes Apply catch SIGINT and SIGTERM while it changes the
+// home directory, rolling back instead of being killed halfway. It is off
+// by default because it takes those signals from the rest of the program
+// until Apply returns.
+func WithSignals() Option {
+	return func(m *Manager) { m.signals = true }
+}
+
+// New returns a Manager for cfg, usually config.Defaults or the result of
+// config.Read with changes of its own. The Manager keeps a copy of cfg, so
+// later changes to cfg do not affect it.
+func New(cfg config.Config, options ...Option) (*Manager, error) {
+	m := &Manager{
+		config: cfg.Copy(),
+		logger: &utils.Logger{Level: utils.LevelInfo, Writer: io.Discard},
+		runner: utils.DefaultRunner,
+	}
+	for _, option := range options {
+		option(m)
+	}
+
+	if m.config.LocalPath == "" {
+		return nil, fmt.Errorf("no local path for the dotfiles repository in the configuration")
+	}
+	if m.config.OSSeparation == nil {
+		m.config.OSSeparation = config.Defaults().OSSeparation
+	}
+	return m, nil
+}
+
+// Config returns a copy of the configuration of m.
+func (m *Manager) Config() config.Config {
+	return m.config.Copy()
+}
+
+// mu runs one operation at a time, see Manager.
+var mu sync.Mutex
+
+// do runs fn with the configuration of m in place and returns the actions
+// recorded meanwhile.
+func (m *Manager) do(fn func() error) ([]utils.Action, error) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	savedConfig, savedHome := config.CurrentConfig, config.HomeDir
+	savedLog, savedRunner, savedNonInteractive := utils.Log, utils.DefaultRunner, utils.NonInteractive
+	savedHooks := hooks.Disabled
+	defer func() {
+		config.CurrentConfig, config.HomeDir = savedConfig, savedHome
+		utils.Log, utils.DefaultRunner, utils.NonInteractive = savedLog, savedRunner, savedNonInteractive
+		hooks.Disabled = savedHooks
+	}()
+
+	config.CurrentConfig = m.config.Copy()
+	if m.home != "" {
+		config.HomeDir = m.home
+	}
+	utils.Log, utils.DefaultRunner = m.logger, m.runner
+	if !m.prompts {
+		utils.NonInteractive = true
+	}
+	if m.noHooks {
+		hooks.Disabled = true
+	}
+
+	start := len(m.logger.Actions())
+	err := fn()
+	return m.logger.Actions()[start:], err
+}
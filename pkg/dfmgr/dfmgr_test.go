@@ -0,0 +1,368 @@
+package dfmgr
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cetincetindag/dfmgr/internal/testutil"
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+)
+
+var run = testutil.Git
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// remote returns a bare repository holding an empty first commit, with git
+// kept away from the configuration of whoever runs the tests.
+func remote(t *testing.T) string {
+	t.Helper()
+	testutil.IsolateGit(t)
+
+	dir := t.TempDir()
+	bare := filepath.Join(dir, "dotfiles.git")
+	run(t, dir, "init", "--bare", "--initial-branch=main", bare)
+	run(t, dir, "clone", bare, "seed")
+	run(t, filepath.Join(dir, "seed"), "commit", "--allow-empty", "-m", "Initial commit")
+	run(t, filepath.Join(dir, "seed"), "push", "origin", "HEAD:main")
+	return bare
+}
+
+// machine clones url into the dotfiles folder of a new home directory and
+// returns a Manager for it.
+func machine(t *testing.T, url string) (*Manager, string) {
+	t.Helper()
+	home := t.TempDir()
+	run(t, home, "clone", url, "dotfiles")
+
+	cfg := config.Defaults()
+	cfg.LocalPath = filepath.Join(home, "dotfiles")
+	m, err := New(cfg, WithHome(home))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m, home
+}
+
+func TestSyncPushPullApply(t *testing.T) {
+	url := remote(t)
+	laptop, laptopHome := machine(t, url)
+	desktop, desktopHome := machine(t, url)
+
+	writeFile(t, filepath.Join(laptopHome, ".config/nvim/init.lua"), "-- init\n")
+	synced, err := laptop.Sync(SyncOptions{Paths: []string{".config/nvim"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(synced.Synced, []string{".config/nvim"}) {
+		t.Errorf("Synced = %v", synced.Synced)
+	}
+	if len(synced.Actions) == 0 || synced.Actions[len(synced.Actions)-1].Action != "adopt" {
+		t.Errorf("Actions = %+v, want the adoption last", synced.Actions)
+	}
+
+	status, err := laptop.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Summary[stow.StateLinked] != 1 || status.Repository == nil || status.Repository.Clean() {
+		t.Errorf("Status = %+v, want one linked file and uncommitted changes", status)
+	}
+
+	pushed, err := laptop.Push(PushOptions{Message: "Add nvim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pushed.Message != "Add nvim" {
+		t.Errorf("Message = %q", pushed.Message)
+	}
+
+	pulled, err := desktop.Pull()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pulled.From == pulled.To || !contains(pulled.Changed, "nvim/.config/nvim/init.lua") {
+		t.Errorf("Pull = %+v", pulled)
+	}
+
+	writeFile(t, filepath.Join(desktopHome, ".config/nvim/init.lua"), "-- old\n")
+	preview, err := desktop.Apply(ApplyOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if preview.Plan == nil || len(preview.Plan.Actions) == 0 || len(preview.Actions) != 0 {
+		t.Fatalf("dry run = %+v", preview)
+	}
+
+	applied, err := desktop.Apply(ApplyOptions{Packages: []string{"nvim"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if applied.Snapshot == "" {
+		t.Error("the replaced init.lua was not backed up")
+	}
+	data, err := os.ReadFile(filepath.Join(desktopHome, ".config/nvim/init.lua"))
+	if err != nil || string(data) != "-- init\n" {
+		t.Errorf("applied init.lua = %q, %v", data, err)
+	}
+
+	unapplied, err := desktop.Unapply(UnapplyOptions{All: true, RestoreBackups: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unapplied.Restored, []string{".config/nvim/init.lua"}) {
+		t.Errorf("Restored = %v", unapplied.Restored)
+	}
+	if data, _ := os.ReadFile(filepath.Join(desktopHome, ".config/nvim/init.lua")); string(data) != "-- old\n" {
+		t.Errorf("restored init.lua = %q", data)
+	}
+}
+
+func TestOperationsLeaveGlobalsAlone(t *testing.T) {
+	url := remote(t)
+	m, _ := machine(t, url)
+
+	before := config.CurrentConfig.LocalPath
+	if _, err := m.Status(); err != nil {
+		t.Fatal(err)
+	}
+	if config.CurrentConfig.LocalPath != before || config.HomeDir != "" {
+		t.Errorf("configuration left at %s in %s", config.CurrentConfig.LocalPath, config.HomeDir)
+	}
+}
+
+func TestSyncWithoutPromptsFailsOnConflict(t *testing.T) {
+	url := remote(t)
+	m, home := machine(t, url)
+	writeFile(t, filepath.Join(home, ".bashrc"), "export EDITOR=nvim\n")
+	writeFile(t, filepath.Join(home, "dotfiles/bashrc/.bashrc"), "export EDITOR=vi\n")
+
+	// Copy keeps the file in home, so the second attempt meets the copy
+	// in the repository.
+	if _, err := m.Sync(SyncOptions{Paths: []string{".bashrc"}, Copy: true}); err == nil {
+		t.Fatal("Sync without OnConflict overwrote the repository copy")
+	}
+
+	result, err := m.Sync(SyncOptions{Paths: []string{".bashrc"}, Copy: true, OnConflict: ConflictOverwrite})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Synced) != 1 {
+		t.Errorf("Synced = %v", result.Synced)
+	}
+	if data, _ := os.ReadFile(filepath.Join(home, "dotfiles/bashrc/.bashrc")); string(data) != "export EDITOR=nvim\n" {
+		t.Errorf("repository copy = %q", data)
+	}
+}
+
+func TestSyncWithoutPromptsNeedsCategory(t *testing.T) {
+	url := remote(t)
+	m, home := machine(t, url)
+	writeFile(t, filepath.Join(home, ".unknownrc"), "set all\n")
+
+	if _, err := m.Sync(SyncOptions{Paths: []string{".unknownrc"}, Organize: true}); err == nil {
+		t.Fatal("Sync without a category filed the file anyway")
+	}
+	if _, err := os.Stat(filepath.Join(home, "dotfiles/Misc")); err == nil {
+		t.Error("Sync without a category filed the file under Misc")
+	}
+
+	if _, err := m.Sync(SyncOptions{Paths: []string{".unknownrc"}, Organize: true, Category: "Shell"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(home, "dotfiles/Shell/.unknownrc")); err != nil {
+		t.Errorf("not filed under the category given: %v", err)
+	}
+}
+
+func TestSyncSavesMetadataOnlyForModes(t *testing.T) {
+	url := remote(t)
+	m, home := machine(t, url)
+	metadata := filepath.Join(home, "dotfiles", stow.MetadataFile)
+	writeFile(t, filepath.Join(home, ".bashrc"), "export EDITOR=nvim\n")
+
+	if _, err := m.Sync(SyncOptions{Paths: []string{".bashrc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(metadata); err == nil {
+		t.Fatalf("%s written without a mode to record", stow.MetadataFile)
+	}
+
+	writeFile(t, filepath.Join(home, ".netrc"), "machine example.com\n")
+	if err := os.Chmod(filepath.Join(home, ".netrc"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Sync(SyncOptions{Paths: []string{".netrc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(metadata); err != nil || !strings.Contains(string(data), "0600") {
+		t.Errorf("%s = %q, %v, want the mode of .netrc", stow.MetadataFile, data, err)
+	}
+}
+
+func TestNewNeedsLocalPath(t *testing.T) {
+	if _, err := New(config.Config{}); err == nil {
+		t.Error("New without a local path succeeded")
+	}
+}
+
+func TestNewCopiesConfig(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Variables = map[string]string{"email": "me@example.com"}
+	m, err := New(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cfg.Variables["email"] = "changed@example.com"
+	cfg.OSSeparation["linux"] = "changed"
+	if got := m.Config(); got.Variables["email"] != "me@example.com" || got.OSSeparation["linux"] != "linux" {
+		t.Errorf("the Manager shares the maps of its configuration: %+v", got)
+	}
+}
+
+func TestApplyRunsHooksOfChangedPackages(t *testing.T) {
+	url := remote(t)
+	m, home := machine(t, url)
+	repo := filepath.Join(home, "dotfiles")
+	writeFile(t, filepath.Join(repo, "tmux/.tmux.conf"), "set -g mouse on\n")
+	writeFile(t, filepath.Join(repo, "zsh/.zshrc"), "bindkey -v\n")
+	writeFile(t, filepath.Join(repo, "fonts/.local/share/fonts/mono.ttf"), "font\n")
+	writeFile(t, filepath.Join(repo, ".dfmgr.json"), `{
+  "version": 1,
+  "packages": {
+    "tmux": {"hooks": {"after_apply": ["echo \"$DFMGR_PACKAGE\" >> \"$DFMGR_HOME/ran\""]}},
+    "zsh": {"hooks": {"after_apply": ["echo \"$DFMGR_PACKAGE\" >> \"$DFMGR_HOME/ran\""]}},
+    "fonts": {"hooks": {"after_apply": [{"run": "exit 1", "on_failure": "abort"}]}}
+  }
+}`)
+	ran := func() string {
+		data, _ := os.ReadFile(filepath.Join(home, "ran"))
+		return string(data)
+	}
+
+	if _, err := m.Apply(ApplyOptions{Packages: []string{"tmux", "zsh"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(); got != "tmux\nzsh\n" {
+		t.Errorf("hooks ran for %q", got)
+	}
+
+	writeFile(t, filepath.Join(repo, "zsh/.zprofile"), "export EDITOR=nvim\n")
+	if _, err := m.Apply(ApplyOptions{Packages: []string{"tmux", "zsh"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := ran(); got != "tmux\nzsh\nzsh\n" {
+		t.Errorf("hooks ran for %q, want only zsh to run again", got)
+	}
+
+	result, err := m.Apply(ApplyOptions{Packages: []string{"fonts"}})
+	if err == nil || result.RolledBack {
+		t.Fatalf("Apply = %+v, %v, want the aborting hook reported without a rollback", result, err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".local")); err != nil {
+		t.Errorf("fonts were unlinked after the hook failed: %v", err)
+	}
+
+	quiet, err := New(m.Config(), WithHome(home), WithoutHooks())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := quiet.Apply(ApplyOptions{Packages: []string{"fonts"}}); err != nil {
+		t.Errorf("Apply without hooks = %v", err)
+	}
+}
+
+func TestUnapplyPackageOutsideProfile(t *testing.T) {
+	url := remote(t)
+	m, home := machine(t, url)
+	writeFile(t, filepath.Join(home, "dotfiles/tmux/.tmux.conf"), "set -g mouse on\n")
+	writeFile(t, filepath.Join(home, "dotfiles/zsh/.zshrc"), "bindkey -v\n")
+	if _, err := m.Apply(ApplyOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// tmux was dropped from the profile after it was applied.
+	cfg := m.Config()
+	cfg.Profiles = map[string]config.Profile{"minimal": {Packages: []string{"zsh"}}}
+	cfg.Profile = "minimal"
+	minimal, err := New(cfg, WithHome(home))
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := minimal.Unapply(UnapplyOptions{Packages: []string{"tmux"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Packages, []string{"tmux"}) {
+		t.Errorf("Packages = %v", result.Packages)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".tmux.conf")); !os.IsNotExist(err) {
+		t.Errorf(".tmux.conf is still linked: %v", err)
+	}
+}
+
+func TestApplyWithStowRefusesTemplates(t *testing.T) {
+	url := remote(t)
+	m, home := machine(t, url)
+	writeFile(t, filepath.Join(home, "dotfiles/git/.gitconfig.tmpl"), "[user]\n\temail = {{ .Vars.email }}\n")
+
+	cfg := m.Config()
+	cfg.LinkBackend = stow.BackendStow
+	m, err := New(cfg, WithHome(home))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Apply(ApplyOptions{}); err == nil || !strings.Contains(err.Error(), ".gitconfig.tmpl") {
+		t.Errorf("Apply with stow = %v, want it to refuse the template", err)
+	}
+	if _, err := os.Lstat(filepath.Join(home, ".gitconfig.tmpl")); !os.IsNotExist(err) {
+		t.Errorf("template linked into home: %v", err)
+	}
+}
+
+func TestPushSensitiveToPublicRepository(t *testing.T) {
+	url := remote(t)
+	m, home := machine(t, url)
+	writeFile(t, filepath.Join(home, "dotfiles/ssh/.ssh/config"), "Host work\n\tHostName 10.0.0.1\n")
+
+	// A local remote counts as private unless the configuration says
+	// otherwise.
+	cfg := m.Config()
+	cfg.RemoteVisibility = "public"
+	public, err := New(cfg, WithHome(home))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := public.Push(PushOptions{Message: "Add ssh", Allow: true}); err == nil {
+		t.Fatal("--allow pushed a sensitive file to a public repository")
+	}
+	result, err := public.Push(PushOptions{Message: "Add ssh", AllowSensitive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Findings) != 1 {
+		t.Errorf("Findings = %+v", result.Findings)
+	}
+}
@@ -0,0 +1,246 @@
+package dfmgr
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/git"
+	"github.com/cetincetindag/dfmgr/pkg/hooks"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/scan"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// PushOptions says how Push commits.
+type PushOptions struct {
+	// Message is the commit message, "Update dotfiles - <date>" when
+	// empty, or what the user answers with prompts.
+	Message string
+
+	// Allow pushes even if the secret scanner finds something.
+	Allow bool
+
+	// AllowSensitive pushes even if sensitive files are about to be
+	// published in a public repository.
+	AllowSensitive bool
+}
+
+// PushResult describes what Push published.
+type PushResult struct {
+	Message string `json:"message"`
+
+	// Findings are the possible secrets and sensitive files Allow and
+	// AllowSensitive let through.
+	Findings []scan.Finding `json:"findings"`
+
+	Actions []utils.Action `json:"actions"`
+}
+
+// Push commits every change in the dotfiles repository and pushes it to
+// its remote. Nothing is committed when the changes look like they contain
+// credentials, unless Allow is set, or publish sensitive files in a public
+// repository, unless AllowSensitive is set.
+func (m *Manager) Push(opts PushOptions) (*PushResult, error) {
+	result := &PushResult{Findings: []scan.Finding{}}
+	actions, err := m.do(func() error {
+		return push(opts, result)
+	})
+	result.Actions = actions
+	return result, err
+}
+
+func push(opts PushOptions, result *PushResult) error {
+	localPath := config.CurrentConfig.LocalPath
+
+	if !utils.IsGitRepo(localPath) {
+		return fmt.Errorf("no Git repository found at %s", localPath)
+	}
+
+	if err := git.AddFiles(localPath); err != nil {
+		return fmt.Errorf("failed to add files: %w", err)
+	}
+
+	if err := beforePush(localPath); err != nil {
+		return err
+	}
+
+	if err := scanOutgoing(localPath, opts.Allow, result); err != nil {
+		return err
+	}
+
+	if err := checkPublicPush(localPath, opts.AllowSensitive, result); err != nil {
+		return err
+	}
+
+	message := opts.Message
+	if message == "" {
+		var err error
+		message, err = utils.Prompt("Commit Message", fmt.Sprintf("Update dotfiles - %s", time.Now().Format("2006-01-02")), "--message", nil)
+		if err != nil {
+			return fmt.Errorf("failed to get commit message: %w", err)
+		}
+	}
+	result.Message = message
+
+	if err := git.Commit(localPath, message); err != nil {
+		return fmt.Errorf("failed to commit changes: %w", err)
+	}
+
+	if err := git.Push(localPath); err != nil {
+		return fmt.Errorf("failed to push changes: %w", err)
+	}
+
+	utils.Success("Successfully pushed changes to remote repository")
+	return nil
+}
+
+// beforePush runs the before_push hooks of the packages with changes about
+// to be pushed, and adds whatever they change in turn.
+func beforePush(localPath string) error {
+	diff, err := git.OutgoingDiff(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to list changes: %w", err)
+	}
+
+	changes := repoChanges(scan.Files(diff))
+	if len(changes) == 0 {
+		return nil
+	}
+	if err := hooks.Run(hooks.BeforePush, changes); err != nil {
+		return fmt.Errorf("%w, %s", err, notCommitted(localPath))
+	}
+
+	if err := git.AddFiles(localPath); err != nil {
+		return fmt.Errorf("failed to add files: %w", err)
+	}
+	return nil
+}
+
+// repoChanges groups the repository paths files by the package of this
+// machine holding them, for hooks.
+func repoChanges(files []string) hooks.Changes {
+	packages, err := stow.SelectPackages(false)
+	if err != nil {
+		utils.Warning("Not running hooks: %s", err)
+		return nil
+	}
+	return hooks.ByPackage(packages, files)
+}
+
+// scanOutgoing refuses to continue when the changes about to be pushed look
+// like they contain credentials, unless allow is set.
+func scanOutgoing(localPath string, allow bool, result *PushResult) error {
+	diff, err := git.OutgoingDiff(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to scan changes: %w", err)
+	}
+
+	findings := scan.Diff(diff)
+	if len(findings) == 0 {
+		return nil
+	}
+
+	for _, finding := range findings {
+		utils.Warning("%s", finding)
+	}
+	if allow {
+		utils.Warning("Pushing %d possible secret(s) because of --allow", len(findings))
+		result.Findings = append(result.Findings, findings...)
+		return nil
+	}
+
+	utils.Info("Remove these files or lines, store them with 'dfmgr secret add', mark safe lines with a %s comment, or push with --allow", scan.AllowMarker)
+	return fmt.Errorf("found %d possible secret(s), %s", len(findings), notCommitted(localPath))
+}
+
+// notCommitted tells that a refused push left the changes it added staged.
+func notCommitted(localPath string) string {
+	return fmt.Sprintf("nothing was committed but the changes are still staged, unstage them with 'git -C %s reset'", localPath)
+}
+
+// checkPublicPush refuses to continue when files flagged sensitive are
+// about to be pushed to a public repository, unless allow is set.
+func checkPublicPush(localPath string, allow bool, result *PushResult) error {
+	diff, err := git.OutgoingDiff(localPath)
+	if err != nil {
+		return fmt.Errorf("failed to scan changes: %w", err)
+	}
+
+	findings := scan.Sensitive(scan.Files(diff), manifest.SensitivePatterns(localPath))
+	if len(findings) == 0 {
+		return nil
+	}
+
+	visibility, err := git.OriginVisibility(localPath)
+	if err != nil {
+		utils.Warning("Cannot tell whether the remote repository is public, assuming it is: %s (set remote_visibility in %s if it is not)", err, config.ConfigFile())
+		visibility = git.VisibilityPublic
+	}
+	if visibility != git.VisibilityPublic {
+		return nil
+	}
+
+	for _, finding := range findings {
+		utils.Warning("%s", finding)
+	}
+	if allow {
+		utils.Warning("Pushing %d sensitive file(s) to a public repository because of --allow-sensitive", len(findings))
+		result.Findings = append(result.Findings, findings...)
+		return nil
+	}
+
+	utils.Info("Make the repository private with 'dfmgr repo visibility private', remove these files, or push with --allow-sensitive")
+	return fmt.Errorf("refusing to push %d sensitive file(s) to a public repository, %s", len(findings), notCommitted(localPath))
+}
+
+// PullResult describes what Pull brought in.
+type PullResult struct {
+	// From and To are the commits checked out before and after pulling,
+	// the same when there was nothing new.
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// Changed lists the repository files that changed.
+	Changed []string `json:"changed"`
+
+	Actions []utils.Action `json:"actions"`
+}
+
+// Pull fetches and merges the latest changes of the remote repository.
+// Links into the repository pick them up right away; new files need
+// another Apply.
+func (m *Manager) Pull() (*PullResult, error) {
+	result := &PullResult{Changed: []string{}}
+	actions, err := m.do(func() error {
+		return pull(result)
+	})
+	result.Actions = actions
+	return result, err
+}
+
+func pull(result *PullResult) error {
+	localPath := config.CurrentConfig.LocalPath
+
+	if !utils.IsGitRepo(localPath) {
+		return fmt.Errorf("no Git repository found at %s", localPath)
+	}
+
+	result.From = git.Head(localPath)
+	if err := git.Pull(localPath); err != nil {
+		return fmt.Errorf("failed to pull changes: %w", err)
+	}
+	result.To = git.Head(localPath)
+
+	if result.From != result.To {
+		changed, err := git.ChangedFiles(localPath, result.From, result.To)
+		if err != nil {
+			return err
+		}
+		result.Changed = changed
+	}
+
+	utils.Success("Successfully fetched latest changes from remote repository")
+	return hooks.Run(hooks.AfterFetch, repoChanges(result.Changed))
+}
@@ -0,0 +1,73 @@
+package dfmgr
+
+import (
+	"fmt"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/git"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// StatusResult compares the repository with the home directory.
+type StatusResult struct {
+	LocalPath string                 `json:"local_path"`
+	Packages  []string               `json:"packages"`
+	Files     []stow.FileStatus      `json:"files"`
+	Summary   map[stow.FileState]int `json:"summary"`
+
+	// Repository holds the uncommitted and unpushed changes, nil when git
+	// could not tell.
+	Repository *git.RepoStatus `json:"repository,omitempty"`
+}
+
+// Status reports, for every file of every package Apply would select,
+// whether it is linked, missing, blocked by a real file, linked somewhere
+// else or a broken link, and for rendered templates whether they are up to
+// date, outdated or edited by hand.
+func (m *Manager) Status() (*StatusResult, error) {
+	var result *StatusResult
+	_, err := m.do(func() error {
+		var err error
+		result, err = status()
+		return err
+	})
+	return result, err
+}
+
+func status() (*StatusResult, error) {
+	localPath := config.CurrentConfig.LocalPath
+
+	packages, err := stow.SelectPackages(false)
+	if err != nil {
+		return nil, err
+	}
+
+	linker, err := stow.NewLinker()
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := linker.Status(packages)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inspect packages: %w", err)
+	}
+
+	result := &StatusResult{
+		LocalPath: localPath,
+		Packages:  packages,
+		Files:     files,
+		Summary:   make(map[stow.FileState]int),
+	}
+	for _, f := range files {
+		result.Summary[f.State]++
+	}
+
+	repoStatus, err := git.Status(localPath)
+	if err != nil {
+		utils.Warning("Failed to get repository status: %s", err)
+	} else {
+		result.Repository = repoStatus
+	}
+	return result, nil
+}
@@ -0,0 +1,651 @@
+package dfmgr
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/secret"
+	"github.com/cetincetindag/dfmgr/pkg/stow"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// Ways SyncOptions.OnConflict handles entries already in the repository.
+const (
+	ConflictSkip      = "skip"
+	ConflictOverwrite = "overwrite"
+	ConflictMerge     = "merge"
+)
+
+// SyncOptions says what Sync adds and how.
+type SyncOptions struct {
+	// Paths are the files and directories to add, absolute or relative to
+	// the home directory. Glob patterns such as ~/.config/nvim/**/*.lua
+	// are expanded.
+	Paths []string
+
+	// Package is the package the files are added to. When empty it is
+	// derived from each path, so ".config/nvim" goes to "nvim".
+	Package string
+
+	// Organize files paths into packages by category, Category for the
+	// ones it does not recognize.
+	Organize bool
+	Category string
+
+	// Common adds the files to the folder shared by every OS with
+	// MultiOS, instead of the folder of this OS.
+	Common bool
+
+	// Copy only copies the files into the repository. By default they are
+	// adopted: moved into the repository and linked back in place.
+	Copy bool
+
+	// OnConflict handles files and directories already in the repository:
+	// ConflictSkip, ConflictOverwrite or ConflictMerge. When empty the
+	// user is asked with prompts, and the file is skipped otherwise.
+	OnConflict string
+
+	// Migrate moves files synced with the old flat layout to their
+	// home-relative paths without asking.
+	Migrate bool
+}
+
+// SyncResult describes what Sync added.
+type SyncResult struct {
+	// Synced lists the home-relative paths added to the repository.
+	Synced []string `json:"synced"`
+
+	// Migrated lists the repository paths moved out of the flat layout.
+	Migrated []string `json:"migrated"`
+
+	Actions []utils.Action `json:"actions"`
+}
+
+// Sync adds files from the home directory to the dotfiles repository,
+// keeping their home-relative path inside the package so that Apply links
+// them back to the same place.
+func (m *Manager) Sync(opts SyncOptions) (*SyncResult, error) {
+	result := &SyncResult{Synced: []string{}, Migrated: []string{}}
+	actions, err := m.do(func() error {
+		s := &syncer{opts: opts, result: result}
+		return s.run()
+	})
+	result.Actions = actions
+	return result, err
+}
+
+// syncer carries the options and repository metadata through one Sync.
+type syncer struct {
+	opts     SyncOptions
+	result   *SyncResult
+	metadata *stow.Metadata
+	linker   *stow.Linker
+
+	// rendered holds the files below synced directories that apply
+	// writes from a template or secret, which are left out.
+	rendered map[string]bool
+}
+
+func (s *syncer) run() error {
+	if len(s.opts.Paths) == 0 && !s.opts.Migrate {
+		return fmt.Errorf("no files specified")
+	}
+
+	switch s.opts.OnConflict {
+	case "", ConflictSkip, ConflictOverwrite, ConflictMerge:
+	default:
+		return fmt.Errorf("unknown --on-conflict %q, use %s, %s or %s", s.opts.OnConflict, ConflictSkip, ConflictOverwrite, ConflictMerge)
+	}
+
+	localPath := config.CurrentConfig.LocalPath
+	if !utils.IsGitRepo(localPath) {
+		return fmt.Errorf("no dotfiles repository found at %s", localPath)
+	}
+
+	home := config.Home()
+
+	packages, err := stow.SelectPackages(false)
+	if err != nil {
+		return err
+	}
+
+	s.metadata, err = stow.LoadMetadata(localPath)
+	if err != nil {
+		return err
+	}
+
+	if err := s.checkFlatLayout(localPath, home, packages); err != nil {
+		return err
+	}
+	if len(s.opts.Paths) == 0 {
+		return nil
+	}
+
+	repoManifest, err := manifest.Load(localPath)
+	if err != nil {
+		return err
+	}
+
+	linker, err := stow.NewLinker()
+	if err != nil {
+		return err
+	}
+	s.linker = linker
+	s.rendered = make(map[string]bool)
+
+	defer func() {
+		if !s.metadata.Changed() {
+			return
+		}
+		if err := s.metadata.Save(localPath); err != nil {
+			utils.Warning("Failed to save %s: %s", stow.MetadataFile, err)
+		}
+	}()
+
+	// Expand any glob patterns
+	expandedPaths := []string{}
+	for _, pattern := range s.opts.Paths {
+		if !strings.HasPrefix(pattern, "/") {
+			pattern = filepath.Join(home, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			utils.Warning("Invalid pattern %s: %s", pattern, err)
+			continue
+		}
+
+		if len(matches) == 0 {
+			utils.Warning("No matches found for pattern: %s", pattern)
+			continue
+		}
+
+		expandedPaths = append(expandedPaths, matches...)
+	}
+
+	if len(expandedPaths) == 0 {
+		return fmt.Errorf("no files matched the specified patterns")
+	}
+
+	for _, path := range expandedPaths {
+		relPath, err := filepath.Rel(home, path)
+		if err != nil || strings.HasPrefix(relPath, "..") {
+			utils.Warning("Skipping file outside of home directory: %s", path)
+			continue
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			utils.Warning("Failed to stat file: %s", err)
+			continue
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			utils.Warning("Skipping special file (socket, FIFO or device): %s", relPath)
+			continue
+		}
+
+		if resolved, err := filepath.EvalSymlinks(path); err == nil && stow.IsWithin(localPath, resolved) {
+			utils.Info("Already tracked: %s", relPath)
+			continue
+		}
+
+		if repoManifest != nil && matchesIgnore(relPath, repoManifest.Ignore) {
+			utils.Info("Ignored by %s: %s", manifest.FileName, relPath)
+			continue
+		}
+
+		pkg, err := s.packageFor(relPath)
+		if err != nil {
+			return err
+		}
+		if config.CurrentConfig.MultiOS && s.opts.Common {
+			pkg = filepath.Join(config.CommonLayer, pkg)
+		} else if config.CurrentConfig.MultiOS {
+			pkg = filepath.Join(config.GetOSFolder(), pkg)
+		}
+
+		if others := stow.FindTracked(localPath, relPath, pkg, packages); len(others) > 0 {
+			if s.opts.Package != "" || s.opts.Organize || len(others) > 1 {
+				utils.Warning("Skipping %s: already tracked in package %s", relPath, strings.Join(others, ", "))
+				continue
+			}
+			// Update the existing copy rather than tracking it twice.
+			pkg = others[0]
+		}
+
+		// Files keep their path relative to the package target, the home
+		// directory unless the manifest says otherwise, so that apply links
+		// them back to the same place.
+		target := linker.TargetFor(pkg)
+		pkgRelPath, err := filepath.Rel(target, path)
+		if err != nil || strings.HasPrefix(pkgRelPath, "..") {
+			utils.Warning("Skipping %s: outside of the target of package %s", relPath, pkg)
+			continue
+		}
+
+		targetPath := filepath.Join(localPath, pkg, pkgRelPath)
+		if s.renderedByApply(path, targetPath) {
+			utils.Info("Written by apply from a template or secret, edit that instead: %s", relPath)
+			continue
+		}
+		if err := checkLayoutConflict(filepath.Join(localPath, pkg), pkgRelPath); err != nil {
+			utils.Warning("Skipping %s: %s", relPath, err)
+			continue
+		}
+
+		if added, err := repoManifest.Declare(localPath, pkg); err != nil {
+			utils.Warning("Failed to add package %s to %s: %s", pkg, manifest.FileName, err)
+		} else if added {
+			utils.Info("Added package %s to %s", pkg, manifest.FileName)
+		}
+
+		if err := stow.CreateParents(filepath.Join(localPath, pkg), target, pkgRelPath, s.metadata); err != nil {
+			utils.Warning("Failed to create directory: %s", err)
+			continue
+		}
+
+		_, err = os.Lstat(targetPath)
+		isNew := os.IsNotExist(err)
+
+		// Handle directories differently
+		if info.IsDir() {
+			if err := s.syncDirectory(path, targetPath, relPath); err != nil {
+				utils.Warning("Failed to sync directory %s: %s", relPath, err)
+				continue
+			}
+		} else {
+			if err := s.syncFile(path, targetPath, relPath); err != nil {
+				utils.Warning("Failed to sync file %s: %s", relPath, err)
+				continue
+			}
+		}
+
+		if !s.opts.Copy {
+			if err := s.adopt(path, targetPath); err != nil {
+				utils.Warning("Failed to adopt %s, leaving it in place: %s", relPath, err)
+				if isNew {
+					os.RemoveAll(targetPath)
+					removeEmptyParents(filepath.Dir(targetPath), localPath)
+				}
+				continue
+			}
+			utils.Success("Linked %s to the repository", relPath)
+			utils.Record("adopt", path, targetPath)
+		}
+
+		s.result.Synced = append(s.result.Synced, relPath)
+	}
+
+	if len(s.result.Synced) == 0 {
+		return fmt.Errorf("failed to sync any files")
+	}
+
+	utils.Success("Successfully synced %d files/directories to your dotfiles repository", len(s.result.Synced))
+	if s.opts.Copy {
+		utils.Info("Remember to run 'dfmgr apply' to create symlinks for the new files")
+	}
+	return nil
+}
+
+func (s *syncer) syncFile(sourcePath, targetPath, relPath string) error {
+	// Check if file already exists
+	if _, err := os.Stat(targetPath); err == nil {
+		overwrite, err := s.resolveConflict(fmt.Sprintf("File %s already exists in repository. Overwrite", filepath.Base(relPath)), ConflictOverwrite)
+		if err != nil {
+			return err
+		}
+		if !overwrite {
+			utils.Info("Skipping file: %s", relPath)
+			return nil
+		}
+	}
+
+	info, err := os.Lstat(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return syncSymlink(sourcePath, targetPath, relPath)
+	case !info.Mode().IsRegular():
+		utils.Warning("Skipping special file (socket, FIFO or device): %s", relPath)
+		return nil
+	}
+
+	data, err := os.ReadFile(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	err = os.WriteFile(targetPath, data, info.Mode().Perm())
+	if err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+
+	// WriteFile leaves the mode of existing files alone and applies the umask.
+	if err := os.Chmod(targetPath, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := s.metadata.RecordMode(config.CurrentConfig.LocalPath, targetPath, info.Mode()); err != nil {
+		return err
+	}
+
+	utils.Success("Added file: %s", relPath)
+	utils.Record("add", targetPath, sourcePath)
+	return nil
+}
+
+// syncSymlink recreates a symlink in the repository instead of copying the
+// file it points to. Relative links keep working wherever the repository is
+// checked out; absolute ones are kept but may not resolve on other machines.
+func syncSymlink(sourcePath, targetPath, relPath string) error {
+	link, err := os.Readlink(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read symlink: %w", err)
+	}
+
+	if filepath.IsAbs(link) {
+		utils.Warning("Symlink %s points to absolute path %s, which may not exist on other machines", relPath, link)
+	}
+
+	if info, err := os.Lstat(targetPath); err == nil {
+		if info.IsDir() {
+			return fmt.Errorf("%s is a directory in the repository", relPath)
+		}
+		if err := os.Remove(targetPath); err != nil {
+			return err
+		}
+	}
+
+	if err := os.Symlink(link, targetPath); err != nil {
+		return fmt.Errorf("failed to create symlink: %w", err)
+	}
+
+	utils.Success("Added symlink: %s -> %s", relPath, link)
+	utils.Record("add", targetPath, sourcePath)
+	return nil
+}
+
+func (s *syncer) syncDirectory(sourcePath, targetPath, relPath string) error {
+	// Check if directory already exists
+	if _, err := os.Stat(targetPath); err == nil {
+		merge, err := s.resolveConflict(fmt.Sprintf("Directory %s already exists in repository. Merge", filepath.Base(relPath)), ConflictMerge, ConflictOverwrite)
+		if err != nil {
+			return err
+		}
+		if !merge {
+			utils.Info("Skipping directory: %s", relPath)
+			return nil
+		}
+	}
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	if err := utils.EnsureDirExists(targetPath); err != nil {
+		return fmt.Errorf("failed to create target directory: %w", err)
+	}
+	if err := os.Chmod(targetPath, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := s.metadata.RecordMode(config.CurrentConfig.LocalPath, targetPath, info.Mode()); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(sourcePath)
+	if err != nil {
+		return fmt.Errorf("failed to read directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		entryPath := filepath.Join(sourcePath, entry.Name())
+		entryRelPath := filepath.Join(relPath, entry.Name())
+
+		info, err := entry.Info()
+		if err != nil {
+			utils.Warning("Failed to get info for %s: %s", entryRelPath, err)
+			continue
+		}
+
+		entryTargetPath := filepath.Join(targetPath, entry.Name())
+		if s.renderedByApply(entryPath, entryTargetPath) {
+			utils.Info("Leaving out %s, written by apply from a template or secret", entryRelPath)
+			s.rendered[entryPath] = true
+			continue
+		}
+		if info.IsDir() {
+			if err := s.syncDirectory(entryPath, entryTargetPath, entryRelPath); err != nil {
+				utils.Warning("Failed to sync subdirectory %s: %s", entryRelPath, err)
+			}
+		} else {
+			if err := s.syncFile(entryPath, entryTargetPath, entryRelPath); err != nil {
+				utils.Warning("Failed to sync file %s: %s", entryRelPath, err)
+			}
+		}
+	}
+
+	utils.Success("Added directory: %s", relPath)
+	return nil
+}
+
+// renderedByApply reports whether apply writes the file at live from a
+// template or secret: it recorded rendering it, or the repository holds
+// one next to targetPath. Its content must never reach the repository.
+func (s *syncer) renderedByApply(live, targetPath string) bool {
+	if _, ok := s.linker.Rendered.Files[live]; ok {
+		return true
+	}
+	for _, suffix := range []string{stow.TemplateSuffix, secret.Suffix} {
+		if _, err := os.Lstat(targetPath + suffix); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// adopt replaces live with a link to its copy at repoPath. Directories
+// holding files apply renders are adopted entry by entry instead, leaving
+// those files in place.
+func (s *syncer) adopt(live, repoPath string) error {
+	if !s.holdsRendered(live) {
+		return stow.Adopt(live, repoPath)
+	}
+
+	entries, err := os.ReadDir(live)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		entryPath := filepath.Join(live, entry.Name())
+		if s.rendered[entryPath] {
+			continue
+		}
+		if err := s.adopt(entryPath, filepath.Join(repoPath, entry.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// holdsRendered reports whether a rendered file was left out below dir.
+func (s *syncer) holdsRendered(dir string) bool {
+	for path := range s.rendered {
+		if path != dir && stow.IsWithin(dir, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveConflict decides whether to replace an entry already in the
+// repository: yes when OnConflict is one of accept, otherwise the answer
+// to label, or an error without a terminal to ask on.
+func (s *syncer) resolveConflict(label string, accept ...string) (bool, error) {
+	if s.opts.OnConflict != "" {
+		for _, policy := range accept {
+			if s.opts.OnConflict == policy {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if !utils.Interactive() {
+		return false, fmt.Errorf("already in the repository, pass --on-conflict=%s|%s|%s", ConflictSkip, ConflictOverwrite, ConflictMerge)
+	}
+	return utils.Confirm(label)
+}
+
+// promptCategory asks which category filename is filed under, unless the
+// Category option says. Without a terminal it fails rather than guess.
+func (s *syncer) promptCategory(filename string) (string, error) {
+	if s.opts.Category != "" {
+		return s.opts.Category, nil
+	}
+
+	categories := config.ListCategories()
+
+	index, err := utils.Select(fmt.Sprintf("Select category for %s", filename), categories, -1, "--category")
+	if err != nil {
+		return "", fmt.Errorf("no category for %s: %w", filename, err)
+	}
+
+	return categories[index], nil
+}
+
+// packageFor picks the package a home-relative path is synced into: the
+// Package option, its category with Organize, or a name derived from the
+// application the file belongs to.
+func (s *syncer) packageFor(relPath string) (string, error) {
+	if s.opts.Package != "" {
+		return s.opts.Package, nil
+	}
+
+	if s.opts.Organize {
+		if fileInfo, found := config.GetConfigFileInfo(relPath); found {
+			return fileInfo.Category, nil
+		}
+		return s.promptCategory(filepath.Base(relPath))
+	}
+
+	return stow.PackageName(relPath), nil
+}
+
+// checkLayoutConflict makes sure relPath can be created inside pkgDir, i.e.
+// none of its parents already exists in the package as a file.
+func checkLayoutConflict(pkgDir, relPath string) error {
+	dir := pkgDir
+	parts := strings.Split(filepath.Dir(relPath), string(filepath.Separator))
+	for _, part := range parts {
+		if part == "." {
+			break
+		}
+		dir = filepath.Join(dir, part)
+		if info, err := os.Lstat(dir); err == nil && !info.IsDir() {
+			return fmt.Errorf("%s exists in the repository and is not a directory", dir)
+		}
+	}
+	return nil
+}
+
+// checkFlatLayout offers to move files synced by older versions of dfmgr,
+// which stored them by basename, to their home-relative paths. Entries the
+// user declined to move are only migrated with --migrate.
+func (s *syncer) checkFlatLayout(localPath, home string, packages []string) error {
+	declined := make(map[string]bool)
+	for _, rel := range s.metadata.FlatLayout {
+		declined[rel] = true
+	}
+
+	var migrations []stow.Migration
+	for _, m := range stow.FindFlatLayout(localPath, home, packages) {
+		if s.opts.Migrate || !declined[filepath.ToSlash(relTo(localPath, m.From))] {
+			migrations = append(migrations, m)
+		}
+	}
+	if len(migrations) == 0 {
+		if s.opts.Migrate {
+			utils.Info("Repository already uses the home-relative layout")
+		}
+		return nil
+	}
+
+	utils.Warning("Found %d entries stored with the old flat layout:", len(migrations))
+	for _, m := range migrations {
+		utils.Warning("  %s -> %s", relTo(localPath, m.From), relTo(localPath, m.To))
+	}
+
+	if !s.opts.Migrate {
+		ok, err := utils.Confirm("Migrate them to the home-relative layout now")
+		if err != nil {
+			utils.Info("Run 'dfmgr sync --migrate' to migrate later")
+			return nil
+		}
+		if !ok {
+			for _, m := range migrations {
+				s.metadata.FlatLayout = append(s.metadata.FlatLayout, filepath.ToSlash(relTo(localPath, m.From)))
+			}
+			if err := s.metadata.Save(localPath); err != nil {
+				return fmt.Errorf("failed to save %s: %w", stow.MetadataFile, err)
+			}
+			utils.Info("Not asking again, run 'dfmgr sync --migrate' to migrate them later")
+			return nil
+		}
+	}
+
+	for _, m := range migrations {
+		if err := m.Migrate(home); err != nil {
+			return fmt.Errorf("failed to migrate %s: %w", relTo(localPath, m.From), err)
+		}
+		utils.Success("Moved %s to %s", relTo(localPath, m.From), relTo(localPath, m.To))
+		utils.Record("migrate", m.To, m.From)
+		s.result.Migrated = append(s.result.Migrated, relTo(localPath, m.To))
+	}
+	if len(s.metadata.FlatLayout) > 0 {
+		s.metadata.FlatLayout = nil
+		if err := s.metadata.Save(localPath); err != nil {
+			return fmt.Errorf("failed to save %s: %w", stow.MetadataFile, err)
+		}
+	}
+	utils.Info("Run 'dfmgr apply' to link the migrated files to their correct locations")
+	return nil
+}
+
+// removeEmptyParents removes dir and its parents up to, but not including,
+// root for as long as they are empty.
+func removeEmptyParents(dir, root string) {
+	for dir != root && stow.IsWithin(root, dir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// matchesIgnore reports whether any element of relPath matches one of the
+// manifest ignore patterns.
+func matchesIgnore(relPath string, patterns []string) bool {
+	for _, part := range strings.Split(relPath, string(filepath.Separator)) {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, part); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// relTo returns path relative to base, or path itself if it has none.
+func relTo(base, path string) string {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return path
+	}
+	return rel
+}
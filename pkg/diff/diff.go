@@ -0,0 +1,301 @@
+package diff
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+type OpKind int
+
+const (
+	OpEqual OpKind = iota
+	OpDelete
+	OpInsert
+)
+
+// Edit is one line of an edit script turning a into b. A and B are the line
+// indices in a and b; only the one relevant to Kind is meaningful for
+// deletions and insertions.
+type Edit struct {
+	Kind OpKind
+	A    int
+	B    int
+}
+
+type Hunk struct {
+	AStart int
+	ALen   int
+	BStart int
+	BLen   int
+	Lines  []string
+}
+
+// Lines splits text into lines, keeping the trailing newline on each line
+// so a missing final newline shows up as a difference.
+func Lines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// IsBinary guesses whether data is binary the way git does, by looking for
+// a NUL byte near the start.
+func IsBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+// Compute returns the shortest edit script from a to b using the linear
+// space variant of Myers' O((N+M)D) algorithm, which splits the problem at
+// the middle snake of the shortest path instead of remembering every step
+// of it.
+func Compute(a, b []string) []Edit {
+	size := 2*(len(a)+len(b)) + 3
+	d := &differ{a: a, b: b, forward: make([]int, size), backward: make([]int, size)}
+	d.compare(0, len(a), 0, len(b))
+	return d.edits
+}
+
+type differ struct {
+	a, b  []string
+	edits []Edit
+
+	// forward and backward hold the furthest x reached on each diagonal
+	// by the paths from either end, reused by every middleSnake.
+	forward  []int
+	backward []int
+}
+
+// compare appends the edit script from a[aLo:aHi] to b[bLo:bHi].
+func (d *differ) compare(aLo, aHi, bLo, bHi int) {
+	for aLo < aHi && bLo < bHi && d.a[aLo] == d.b[bLo] {
+		d.edits = append(d.edits, Edit{Kind: OpEqual, A: aLo, B: bLo})
+		aLo++
+		bLo++
+	}
+	suffix := 0
+	for aLo < aHi && bLo < bHi && d.a[aHi-1] == d.b[bHi-1] {
+		aHi--
+		bHi--
+		suffix++
+	}
+
+	switch {
+	case aLo == aHi:
+		for y := bLo; y < bHi; y++ {
+			d.edits = append(d.edits, Edit{Kind: OpInsert, A: aLo, B: y})
+		}
+	case bLo == bHi:
+		for x := aLo; x < aHi; x++ {
+			d.edits = append(d.edits, Edit{Kind: OpDelete, A: x, B: bLo})
+		}
+	default:
+		// Both halves around the middle snake need fewer edits than the
+		// whole, which takes at least two once the ends are trimmed.
+		x, y, u, v := d.middleSnake(aLo, aHi, bLo, bHi)
+		d.compare(aLo, x, bLo, y)
+		for ; x < u; x, y = x+1, y+1 {
+			d.edits = append(d.edits, Edit{Kind: OpEqual, A: x, B: y})
+		}
+		d.compare(u, aHi, v, bHi)
+	}
+
+	for i := 0; i < suffix; i++ {
+		d.edits = append(d.edits, Edit{Kind: OpEqual, A: aHi + i, B: bHi + i})
+	}
+}
+
+// middleSnake returns the snake from (x, y) to (u, v) in the middle of a
+// shortest path from a[aLo:aHi] to b[bLo:bHi], found by searching from
+// both ends until the paths overlap.
+func (d *differ) middleSnake(aLo, aHi, bLo, bHi int) (x, y, u, v int) {
+	n, m := aHi-aLo, bHi-bLo
+	delta := n - m
+	odd := delta%2 != 0
+	offset := len(d.forward) / 2
+	fw, bw := d.forward, d.backward
+	fw[offset+1], bw[offset+1] = 0, 0
+
+	for step := 0; step <= (n+m+1)/2; step++ {
+		for k := -step; k <= step; k += 2 {
+			var x int
+			if k == -step || (k != step && fw[offset+k-1] < fw[offset+k+1]) {
+				x = fw[offset+k+1]
+			} else {
+				x = fw[offset+k-1] + 1
+			}
+			y := x - k
+			startX, startY := x, y
+			for x < n && y < m && d.a[aLo+x] == d.b[bLo+y] {
+				x++
+				y++
+			}
+			fw[offset+k] = x
+
+			// The backward path on the same diagonal, measured from the
+			// end, made step-1 edits.
+			if rk := delta - k; odd && rk >= -(step-1) && rk <= step-1 && x+bw[offset+rk] >= n {
+				return aLo + startX, bLo + startY, aLo + x, bLo + y
+			}
+		}
+
+		for rk := -step; rk <= step; rk += 2 {
+			var rx int
+			if rk == -step || (rk != step && bw[offset+rk-1] < bw[offset+rk+1]) {
+				rx = bw[offset+rk+1]
+			} else {
+				rx = bw[offset+rk-1] + 1
+			}
+			ry := rx - rk
+			startX, startY := rx, ry
+			for rx < n && ry < m && d.a[aHi-1-rx] == d.b[bHi-1-ry] {
+				rx++
+				ry++
+			}
+			bw[offset+rk] = rx
+
+			if k := delta - rk; !odd && k >= -step && k <= step && fw[offset+k]+rx >= n {
+				return aHi - rx, bHi - ry, aHi - startX, bHi - startY
+			}
+		}
+	}
+	panic("diff: no middle snake")
+}
+
+// Stat counts the lines inserted into and deleted from a to get b.
+func Stat(a, b []string) (inserted, deleted int) {
+	for _, e := range Compute(a, b) {
+		switch e.Kind {
+		case OpInsert:
+			inserted++
+		case OpDelete:
+			deleted++
+		}
+	}
+	return inserted, deleted
+}
+
+// Hunks groups the edit script from a to b into hunks with up to context
+// unchanged lines around every change.
+func Hunks(a, b []string, context int) []Hunk {
+	edits := Compute(a, b)
+
+	var hunks []Hunk
+	i := 0
+	for i < len(edits) {
+		// Find the next change.
+		for i < len(edits) && edits[i].Kind == OpEqual {
+			i++
+		}
+		if i == len(edits) {
+			break
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		// Extend the hunk while changes are close enough to merge.
+		end := i
+		for end < len(edits) {
+			if edits[end].Kind != OpEqual {
+				end++
+				continue
+			}
+			run := end
+			for run < len(edits) && edits[run].Kind == OpEqual {
+				run++
+			}
+			if run == len(edits) || run-end > 2*context {
+				end += context
+				if end > run {
+					end = run
+				}
+				break
+			}
+			end = run
+		}
+
+		hunks = append(hunks, makeHunk(a, b, edits[start:end]))
+		i = end
+	}
+	return hunks
+}
+
+func makeHunk(a, b []string, edits []Edit) Hunk {
+	h := Hunk{AStart: -1, BStart: -1}
+	for _, e := range edits {
+		switch e.Kind {
+		case OpEqual:
+			h.use(e.A, e.B)
+			h.ALen++
+			h.BLen++
+			h.Lines = append(h.Lines, " "+a[e.A])
+		case OpDelete:
+			h.use(e.A, e.B)
+			h.ALen++
+			h.Lines = append(h.Lines, "-"+a[e.A])
+		case OpInsert:
+			h.use(e.A, e.B)
+			h.BLen++
+			h.Lines = append(h.Lines, "+"+b[e.B])
+		}
+	}
+	return h
+}
+
+func (h *Hunk) use(a, b int) {
+	if h.AStart < 0 {
+		h.AStart = a
+	}
+	if h.BStart < 0 {
+		h.BStart = b
+	}
+}
+
+// Header returns the @@ line of the hunk using 1-based line numbers.
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.AStart, h.ALen), hunkRange(h.BStart, h.BLen))
+}
+
+func hunkRange(start, length int) string {
+	if length == 0 {
+		return fmt.Sprintf("%d,0", start)
+	}
+	if length == 1 {
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, length)
+}
+
+// Unified renders a unified diff of a and b with three lines of context.
+// It returns an empty string when both are equal.
+func Unified(aName, bName string, a, b []string) string {
+	hunks := Hunks(a, b, 3)
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+	for _, h := range hunks {
+		sb.WriteString(h.Header())
+		sb.WriteString("\n")
+		for _, line := range h.Lines {
+			sb.WriteString(line)
+			if !strings.HasSuffix(line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+	return sb.String()
+}
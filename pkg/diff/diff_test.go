@@ -0,0 +1,89 @@
+package diff
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// lcs returns the length of the longest common subsequence of a and b.
+func lcs(a, b []string) int {
+	prev, cur := make([]int, len(b)+1), make([]int, len(b)+1)
+	for i := range a {
+		for j := range b {
+			switch {
+			case a[i] == b[j]:
+				cur[j+1] = prev[j] + 1
+			case prev[j+1] > cur[j]:
+				cur[j+1] = prev[j+1]
+			default:
+				cur[j+1] = cur[j]
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func TestComputeIsShortest(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := func() []string {
+		lines := make([]string, r.Intn(30))
+		for i := range lines {
+			lines[i] = string(rune('a' + r.Intn(4)))
+		}
+		return lines
+	}
+
+	for i := 0; i < 2000; i++ {
+		a, b := random(), random()
+		edits := Compute(a, b)
+
+		var got []string
+		changes, x, y := 0, 0, 0
+		for _, e := range edits {
+			switch e.Kind {
+			case OpEqual:
+				if e.A != x || e.B != y || a[e.A] != b[e.B] {
+					t.Fatalf("%v -> %v: bad equal %+v", a, b, e)
+				}
+				got = append(got, a[e.A])
+				x, y = x+1, y+1
+			case OpDelete:
+				if e.A != x {
+					t.Fatalf("%v -> %v: bad delete %+v", a, b, e)
+				}
+				x++
+				changes++
+			case OpInsert:
+				if e.B != y {
+					t.Fatalf("%v -> %v: bad insert %+v", a, b, e)
+				}
+				got = append(got, b[e.B])
+				y++
+				changes++
+			}
+		}
+
+		if strings.Join(got, "") != strings.Join(b, "") || x != len(a) {
+			t.Fatalf("%v -> %v: edits produce %v", a, b, got)
+		}
+		if want := len(a) + len(b) - 2*lcs(a, b); changes != want {
+			t.Fatalf("%v -> %v: %d changes, want %d", a, b, changes, want)
+		}
+	}
+}
+
+// TestComputeLargeFiles diffs files without a line in common, the worst
+// case, where remembering every step of the path took over a gigabyte.
+func TestComputeLargeFiles(t *testing.T) {
+	a := make([]string, 5000)
+	b := make([]string, 5000)
+	for i := range a {
+		a[i] = string(rune('a' + i%26))
+		b[i] = string(rune('A' + i%26))
+	}
+	if inserted, deleted := Stat(a, b); inserted != len(b) || deleted != len(a) {
+		t.Errorf("Stat = %d, %d", inserted, deleted)
+	}
+}
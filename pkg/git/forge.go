@@ -0,0 +1,258 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+type Visibility string
+
+const (
+	VisibilityPublic  Visibility = "public"
+	VisibilityPrivate Visibility = "private"
+)
+
+const (
+	ForgeGitHub = "github"
+	ForgeGitLab = "gitlab"
+	ForgeGitea  = "gitea"
+	ForgeGH     = "gh"
+)
+
+// ForgeTokenEnv holds the API token used to create and manage repositories
+// when none is set in the configuration.
+const ForgeTokenEnv = "DFMGR_FORGE_TOKEN"
+
+var ErrRepoNotFound = errors.New("repository not found")
+
+// Repository is a repository as reported by a forge.
+type Repository struct {
+	Owner         string     `json:"owner"`
+	Name          string     `json:"name"`
+	Visibility    Visibility `json:"visibility"`
+	DefaultBranch string     `json:"default_branch,omitempty"`
+}
+
+// Forge manages repositories on a hosting service. Owners and names are
+// those of Remote; CreateRepo creates name for the authenticated user.
+type Forge interface {
+	Name() string
+	CreateRepo(name string, visibility Visibility) (*Repository, error)
+	ForkRepo(owner, name string) (*Repository, error)
+	RepoExists(owner, name string) (bool, error)
+	Visibility(owner, name string) (Visibility, error)
+	SetVisibility(owner, name string, visibility Visibility) error
+	DefaultBranch(owner, name string) (string, error)
+}
+
+// NewForge returns the Forge for host. The kind of forge is taken from the
+// configuration, or guessed from the host name. The REST APIs are used
+// when a token is available; for GitHub the GitHub CLI is the fallback.
+func NewForge(host string) (Forge, error) {
+	kind := config.CurrentConfig.Forge
+	if kind == "" {
+		kind = forgeKind(host)
+	}
+	if kind == "" {
+		return nil, fmt.Errorf("unknown forge for %s, set forge in %s to github, gitlab or gitea", host, config.ConfigFile())
+	}
+
+	token := forgeToken(kind)
+	if kind == ForgeGH || kind == ForgeGitHub && token == "" {
+		if !utils.IsCommandAvailable("gh") {
+			return nil, fmt.Errorf("no API token for %s, set %s or install the GitHub CLI (gh)", host, ForgeTokenEnv)
+		}
+		return &ghForge{host: host}, nil
+	}
+	if token == "" {
+		return nil, fmt.Errorf("no API token for %s, set %s or forge_token in %s", host, ForgeTokenEnv, config.ConfigFile())
+	}
+
+	api := &apiClient{base: config.CurrentConfig.ForgeURL, token: token}
+	switch kind {
+	case ForgeGitHub:
+		if api.base == "" {
+			api.base = "https://api.github.com"
+			if host != config.DefaultGitHost {
+				api.base = fmt.Sprintf("https://%s/api/v3", host)
+			}
+		}
+		api.header = "Authorization"
+		api.prefix = "Bearer "
+		return &githubForge{name: "GitHub", api: api}, nil
+	case ForgeGitea:
+		if api.base == "" {
+			api.base = fmt.Sprintf("https://%s/api/v1", host)
+		}
+		api.header = "Authorization"
+		api.prefix = "token "
+		return &githubForge{name: "Gitea", api: api}, nil
+	case ForgeGitLab:
+		if api.base == "" {
+			api.base = fmt.Sprintf("https://%s/api/v4", host)
+		}
+		api.header = "PRIVATE-TOKEN"
+		return &gitlabForge{api: api}, nil
+	default:
+		return nil, fmt.Errorf("unknown forge %q, use github, gitlab, gitea or gh", kind)
+	}
+}
+
+func forgeKind(host string) string {
+	switch {
+	case host == config.DefaultGitHost:
+		return ForgeGitHub
+	case strings.Contains(host, "gitlab"):
+		return ForgeGitLab
+	case strings.Contains(host, "gitea"), host == "codeberg.org":
+		return ForgeGitea
+	}
+	return ""
+}
+
+// forgeToken looks for an API token in the configuration, ForgeTokenEnv,
+// the usual variables of each forge and finally TokenEnv.
+func forgeToken(kind string) string {
+	if config.CurrentConfig.ForgeToken != "" {
+		return config.CurrentConfig.ForgeToken
+	}
+
+	names := []string{ForgeTokenEnv}
+	switch kind {
+	case ForgeGitHub:
+		names = append(names, "GITHUB_TOKEN", "GH_TOKEN")
+	case ForgeGitLab:
+		names = append(names, "GITLAB_TOKEN")
+	case ForgeGitea:
+		names = append(names, "GITEA_TOKEN")
+	}
+	names = append(names, TokenEnv)
+
+	for _, name := range names {
+		if token := os.Getenv(name); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// APIError is an unsuccessful response of a forge API.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%d %s", e.Status, http.StatusText(e.Status))
+	}
+	return fmt.Sprintf("%d %s: %s", e.Status, http.StatusText(e.Status), e.Message)
+}
+
+type apiClient struct {
+	base   string
+	token  string
+	header string
+	prefix string
+	client *http.Client
+}
+
+// do sends body, if any, as JSON and decodes the response into out, if
+// any. A 404 is reported as ErrRepoNotFound.
+func (c *apiClient) do(method, path string, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimRight(c.base, "/")+path, reader)
+	if err != nil {
+		return err
+	}
+	req.Header.Set(c.header, c.prefix+c.token)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	client := c.client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrRepoNotFound
+	}
+	if resp.StatusCode >= 300 {
+		var failure struct {
+			Message interface{} `json:"message"`
+			Error   string      `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		json.Unmarshal(data, &failure)
+		message := failure.Error
+		if failure.Message != nil {
+			message = fmt.Sprint(failure.Message)
+		}
+		return &APIError{Status: resp.StatusCode, Message: message}
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// How long and how often waitForFork asks whether a fork is ready.
+var (
+	forkTimeout  = 5 * time.Minute
+	forkInterval = 2 * time.Second
+)
+
+// waitForFork waits until forge reports a default branch for fork. Forges
+// answer the fork request at once and copy the repository in the
+// background, so cloning right away may find nothing.
+func waitForFork(forge Forge, fork *Repository) error {
+	deadline := time.Now().Add(forkTimeout)
+	for {
+		branch, err := forge.DefaultBranch(fork.Owner, fork.Name)
+		if err == nil && branch != "" {
+			return nil
+		}
+		if err != nil && !errors.Is(err, ErrRepoNotFound) {
+			return err
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("the fork %s/%s is still not ready after %s", fork.Owner, fork.Name, forkTimeout)
+		}
+		utils.Debug("Waiting for %s to finish the fork %s/%s", forge.Name(), fork.Owner, fork.Name)
+		time.Sleep(forkInterval)
+	}
+}
+
+// exists turns the ErrRepoNotFound of a lookup into false.
+func exists(err error) (bool, error) {
+	if errors.Is(err, ErrRepoNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
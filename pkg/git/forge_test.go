@@ -0,0 +1,176 @@
+package git
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+)
+
+// fakeForge serves the repositories in repos, by API path, and records
+// the requests it gets as "METHOD path" with their JSON body.
+type fakeForge struct {
+	t        *testing.T
+	header   string
+	repos    map[string]interface{}
+	requests []string
+	bodies   []map[string]interface{}
+
+	// pending answers that many lookups with 404, like a fork that is
+	// still being copied.
+	pending int
+}
+
+func (f *fakeForge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Header.Get(f.header) == "" {
+		f.t.Errorf("%s %s without %s", r.Method, r.URL, f.header)
+	}
+	path := r.URL.EscapedPath()
+	f.requests = append(f.requests, r.Method+" "+path)
+	var body map[string]interface{}
+	json.NewDecoder(r.Body).Decode(&body)
+	f.bodies = append(f.bodies, body)
+
+	w.Header().Set("Content-Type", "application/json")
+	if body["name"] == "taken" {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		json.NewEncoder(w).Encode(map[string]string{"message": "name already exists"})
+		return
+	}
+	repo, ok := f.repos[r.Method+" "+path]
+	if r.Method == http.MethodGet && f.pending > 0 {
+		f.pending--
+		ok = false
+	}
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(repo)
+}
+
+// newTestForge returns the forge of kind talking to a server for fake.
+func newTestForge(t *testing.T, kind string, fake *fakeForge) Forge {
+	t.Helper()
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	saved := config.CurrentConfig
+	t.Cleanup(func() { config.CurrentConfig = saved })
+	config.CurrentConfig.Forge = kind
+	config.CurrentConfig.ForgeURL = server.URL
+	config.CurrentConfig.ForgeToken = "secret"
+
+	forge, err := NewForge("git.example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	switch f := forge.(type) {
+	case *githubForge:
+		f.api.client = server.Client()
+	case *gitlabForge:
+		f.api.client = server.Client()
+	}
+	return forge
+}
+
+func githubJSON(owner, name string, private bool) map[string]interface{} {
+	return map[string]interface{}{"name": name, "owner": map[string]string{"login": owner}, "private": private, "default_branch": "main"}
+}
+
+func gitlabJSON(owner, name, visibility string) map[string]interface{} {
+	return map[string]interface{}{"path": name, "namespace": map[string]string{"full_path": owner}, "visibility": visibility, "default_branch": "main"}
+}
+
+func TestForges(t *testing.T) {
+	github := func() *fakeForge {
+		return &fakeForge{header: "Authorization", repos: map[string]interface{}{
+			"POST /user/repos":                 githubJSON("me", "dotfiles", true),
+			"POST /repos/alice/dotfiles/forks": githubJSON("me", "dotfiles", false),
+			"GET /repos/me/dotfiles":           githubJSON("me", "dotfiles", true),
+			"PATCH /repos/me/dotfiles":         githubJSON("me", "dotfiles", false),
+		}}
+	}
+	tests := []struct {
+		kind       string
+		fake       *fakeForge
+		visibility map[string]interface{}
+	}{
+		{ForgeGitHub, github(), map[string]interface{}{"private": false}},
+		{ForgeGitea, github(), map[string]interface{}{"private": false}},
+		{ForgeGitLab, &fakeForge{header: "PRIVATE-TOKEN", repos: map[string]interface{}{
+			"POST /projects":                       gitlabJSON("me", "dotfiles", "private"),
+			"POST /projects/alice%2Fdotfiles/fork": gitlabJSON("me", "dotfiles", "public"),
+			"GET /projects/me%2Fdotfiles":          gitlabJSON("me", "dotfiles", "private"),
+			"PUT /projects/me%2Fdotfiles":          gitlabJSON("me", "dotfiles", "public"),
+		}}, map[string]interface{}{"visibility": "public"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind, func(t *testing.T) {
+			tt.fake.t = t
+			forge := newTestForge(t, tt.kind, tt.fake)
+
+			created, err := forge.CreateRepo("dotfiles", VisibilityPrivate)
+			if err != nil || *created != (Repository{Owner: "me", Name: "dotfiles", Visibility: VisibilityPrivate, DefaultBranch: "main"}) {
+				t.Errorf("CreateRepo = %+v, %v", created, err)
+			}
+			if _, err := forge.CreateRepo("taken", VisibilityPrivate); err == nil {
+				t.Error("CreateRepo of a taken name succeeded")
+			} else if apiErr := new(APIError); !errors.As(err, &apiErr) || apiErr.Message != "name already exists" {
+				t.Errorf("CreateRepo of a taken name = %v", err)
+			}
+
+			fork, err := forge.ForkRepo("alice", "dotfiles")
+			if err != nil || fork.Owner != "me" || fork.Visibility != VisibilityPublic {
+				t.Errorf("ForkRepo = %+v, %v", fork, err)
+			}
+
+			if visibility, err := forge.Visibility("me", "dotfiles"); err != nil || visibility != VisibilityPrivate {
+				t.Errorf("Visibility = %s, %v", visibility, err)
+			}
+			if err := forge.SetVisibility("me", "dotfiles", VisibilityPublic); err != nil {
+				t.Errorf("SetVisibility = %v", err)
+			}
+			if body := tt.fake.bodies[len(tt.fake.bodies)-1]; !reflect.DeepEqual(body, tt.visibility) {
+				t.Errorf("SetVisibility sent %v, want %v", body, tt.visibility)
+			}
+			if branch, err := forge.DefaultBranch("me", "dotfiles"); err != nil || branch != "main" {
+				t.Errorf("DefaultBranch = %q, %v", branch, err)
+			}
+
+			if ok, err := forge.RepoExists("me", "dotfiles"); !ok || err != nil {
+				t.Errorf("RepoExists = %t, %v", ok, err)
+			}
+			if ok, err := forge.RepoExists("me", "missing"); ok || err != nil {
+				t.Errorf("RepoExists of a missing repository = %t, %v", ok, err)
+			}
+			if _, err := forge.Visibility("me", "missing"); !errors.Is(err, ErrRepoNotFound) {
+				t.Errorf("Visibility of a missing repository = %v", err)
+			}
+		})
+	}
+}
+
+func TestForkRepoWaitsForTheFork(t *testing.T) {
+	fake := &fakeForge{t: t, header: "Authorization", pending: 2, repos: map[string]interface{}{
+		"POST /repos/alice/dotfiles/forks": githubJSON("me", "dotfiles", false),
+		"GET /repos/me/dotfiles":           githubJSON("me", "dotfiles", false),
+	}}
+	newTestForge(t, ForgeGitHub, fake)
+	interval := forkInterval
+	forkInterval = 0
+	t.Cleanup(func() { forkInterval = interval })
+
+	fork, err := ForkRepo(NewRemote("git.example.com", "alice", "dotfiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fork.Owner != "me" || len(fake.requests) != 4 {
+		t.Errorf("ForkRepo = %+v after %v", fork, fake.requests)
+	}
+}
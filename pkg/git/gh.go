@@ -0,0 +1,122 @@
+package git
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// ghForge manages GitHub repositories through the GitHub CLI, for machines
+// where gh is logged in but no API token is configured.
+type ghForge struct {
+	host string
+}
+
+func (f *ghForge) Name() string {
+	return "GitHub CLI"
+}
+
+func (f *ghForge) CreateRepo(name string, visibility Visibility) (*Repository, error) {
+	cmd := f.command("repo", "create", name, "--"+string(visibility), "--confirm")
+	if err := utils.RunCommand(cmd); err != nil {
+		return nil, fmt.Errorf("gh repo create failed: %w", err)
+	}
+
+	login, err := f.output("api", "user", "--jq", ".login")
+	if err != nil {
+		return nil, err
+	}
+	return &Repository{Owner: login, Name: name, Visibility: visibility}, nil
+}
+
+func (f *ghForge) ForkRepo(owner, name string) (*Repository, error) {
+	cmd := f.command("repo", "fork", owner+"/"+name, "--clone=false")
+	if err := utils.RunCommand(cmd); err != nil {
+		return nil, fmt.Errorf("gh repo fork failed: %w", err)
+	}
+
+	login, err := f.output("api", "user", "--jq", ".login")
+	if err != nil {
+		return nil, err
+	}
+	return &Repository{Owner: login, Name: name, Visibility: VisibilityPublic}, nil
+}
+
+type ghRepo struct {
+	Visibility       string `json:"visibility"`
+	DefaultBranchRef struct {
+		Name string `json:"name"`
+	} `json:"defaultBranchRef"`
+}
+
+func (f *ghForge) view(owner, name string) (*ghRepo, error) {
+	cmd := f.command("repo", "view", owner+"/"+name, "--json", "visibility,defaultBranchRef")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := utils.OutputOf(cmd)
+	if err != nil {
+		if strings.Contains(stderr.String(), "Could not resolve to a Repository") {
+			return nil, ErrRepoNotFound
+		}
+		return nil, fmt.Errorf("gh repo view failed: %s", strings.TrimSpace(stderr.String()))
+	}
+
+	var repo ghRepo
+	if err := json.Unmarshal(output, &repo); err != nil {
+		return nil, err
+	}
+	return &repo, nil
+}
+
+func (f *ghForge) RepoExists(owner, name string) (bool, error) {
+	_, err := f.view(owner, name)
+	return exists(err)
+}
+
+func (f *ghForge) Visibility(owner, name string) (Visibility, error) {
+	repo, err := f.view(owner, name)
+	if err != nil {
+		return "", err
+	}
+	if strings.EqualFold(repo.Visibility, string(VisibilityPublic)) {
+		return VisibilityPublic, nil
+	}
+	return VisibilityPrivate, nil
+}
+
+func (f *ghForge) SetVisibility(owner, name string, visibility Visibility) error {
+	cmd := f.command("repo", "edit", owner+"/"+name, "--visibility", string(visibility), "--accept-visibility-change-consequences")
+	if err := utils.RunCommand(cmd); err != nil {
+		return fmt.Errorf("gh repo edit failed: %w", err)
+	}
+	return nil
+}
+
+func (f *ghForge) DefaultBranch(owner, name string) (string, error) {
+	repo, err := f.view(owner, name)
+	if err != nil {
+		return "", err
+	}
+	return repo.DefaultBranchRef.Name, nil
+}
+
+func (f *ghForge) command(args ...string) *exec.Cmd {
+	cmd := exec.Command("gh", args...)
+	if f.host != "" {
+		cmd.Env = append(os.Environ(), "GH_HOST="+f.host)
+	}
+	return cmd
+}
+
+func (f *ghForge) output(args ...string) (string, error) {
+	output, err := utils.OutputOf(f.command(args...))
+	if err != nil {
+		return "", fmt.Errorf("gh %s failed: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -8,48 +8,56 @@ import (
 	"strings"
 
 	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
 	"github.com/cetincetindag/dfmgr/pkg/utils"
 )
 
-func CreateRepo(name string) error {
-	utils.Info("Creating GitHub repository: %s", name)
-	
-	if !utils.IsCommandAvailable("gh") {
-		return fmt.Errorf("GitHub CLI (gh) is not installed")
+// CreateRepo creates the repository name for the authenticated user of the
+// forge at host.
+func CreateRepo(host, name string, visibility Visibility) (*Repository, error) {
+	forge, err := NewForge(host)
+	if err != nil {
+		return nil, err
 	}
 	
-	cmd := exec.Command("gh", "repo", "create", name, "--public", "--confirm")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	utils.Info("Creating %s repository on %s: %s", visibility, host, name)
+	return forge.CreateRepo(name, visibility)
 }
 
-func CloneRepo(username, repoName, destPath string) error {
-	utils.Info("Cloning repository: %s/%s", username, repoName)
-	
-	repoURL := fmt.Sprintf("git@github.com:%s/%s.git", username, repoName)
+func CloneRepo(remote *Remote, destPath string) error {
+	utils.Info("Cloning repository: %s", remote)
 	
-	cmd := exec.Command("git", "clone", repoURL, destPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	cmd := exec.Command("git", "clone", remote.URL, destPath)
+	cmd.Env = withAuth(remote.URL)
+	if err := utils.RunCommand(cmd); err != nil {
+		return err
+	}
+	utils.Record("clone", destPath, remote.URL)
+	return nil
 }
 
-func ForkRepo(username, repoName string) error {
-	utils.Info("Forking repository: %s/%s", username, repoName)
+// ForkRepo forks remote for the authenticated user of its forge and
+// returns the remote of the fork once it can be cloned.
+func ForkRepo(remote *Remote) (*Remote, error) {
+	utils.Info("Forking repository: %s", remote)
 	
-	if !utils.IsCommandAvailable("gh") {
-		return fmt.Errorf("GitHub CLI (gh) is not installed")
+	if remote.Host == "" || remote.Owner == "" {
+		return nil, fmt.Errorf("%s is not hosted on a forge", remote.URL)
 	}
 	
-	repoFullName := fmt.Sprintf("%s/%s", username, repoName)
-	cmd := exec.Command("gh", "repo", "fork", repoFullName, "--clone")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	forge, err := NewForge(remote.Host)
+	if err != nil {
+		return nil, err
+	}
 	
-	return cmd.Run()
+	fork, err := forge.ForkRepo(remote.Owner, remote.Repo)
+	if err != nil {
+		return nil, err
+	}
+	if err := waitForFork(forge, fork); err != nil {
+		return nil, err
+	}
+	return NewRemote(remote.Host, fork.Owner, fork.Name), nil
 }
 
 func InitRepo(path string) error {
@@ -61,10 +69,7 @@ func InitRepo(path string) error {
 	
 	cmd := exec.Command("git", "init")
 	cmd.Dir = path
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	return utils.RunCommand(cmd)
 }
 
 func AddFiles(repoPath string) error {
@@ -72,10 +77,7 @@ func AddFiles(repoPath string) error {
 	
 	cmd := exec.Command("git", "add", ".")
 	cmd.Dir = repoPath
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	return utils.RunCommand(cmd)
 }
 
 func Commit(repoPath, message string) error {
@@ -87,35 +89,44 @@ func Commit(repoPath, message string) error {
 	
 	cmd := exec.Command("git", "commit", "-m", message)
 	cmd.Dir = repoPath
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	if err := utils.RunCommand(cmd); err != nil {
+		return err
+	}
+	utils.Record("commit", repoPath, message)
+	return nil
 }
 
 func Push(repoPath string) error {
 	utils.Info("Pushing changes to remote repository")
 	
-	cmd := exec.Command("git", "push", "origin", "HEAD")
+	// Setting the upstream lets OutgoingDiff compare with what was pushed.
+	cmd := exec.Command("git", "push", "-u", "origin", "HEAD")
+	cmd.Env = withAuth(originURL(repoPath))
 	cmd.Dir = repoPath
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	if err := utils.RunCommand(cmd); err != nil {
+		return err
+	}
+	utils.Record("push", repoPath, originURL(repoPath))
+	return nil
 }
 
 func Pull(repoPath string) error {
 	utils.Info("Pulling latest changes from remote repository")
 	
 	cmd := exec.Command("git", "pull")
+	cmd.Env = withAuth(originURL(repoPath))
 	cmd.Dir = repoPath
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	if err := utils.RunCommand(cmd); err != nil {
+		return err
+	}
+	utils.Record("pull", repoPath, originURL(repoPath))
+	return nil
 }
 
-func SetupDefaultRepo() error {
+// SetupDefaultRepo creates the local dotfiles repository and pushes it to
+// the remote in the configuration, after creating the remote repository on
+// its forge with visibility when create is set.
+func SetupDefaultRepo(create bool, visibility Visibility) error {
 	localPath := config.CurrentConfig.LocalPath
 	if err := utils.EnsureDirExists(localPath); err != nil {
 		return err
@@ -133,6 +144,10 @@ func SetupDefaultRepo() error {
 			return err
 		}
 		
+		if err := manifest.New().Save(localPath); err != nil {
+			return err
+		}
+		
 		if err := AddFiles(localPath); err != nil {
 			return err
 		}
@@ -141,17 +156,15 @@ func SetupDefaultRepo() error {
 			return err
 		}
 		
-		username := config.CurrentConfig.GithubUsername
-		repoName := config.CurrentConfig.DotfilesRepo
-		
-		if err := CreateRepo(repoName); err != nil {
-			return err
+		if create {
+			if _, err := CreateRepo(config.GitHost(), config.CurrentConfig.DotfilesRepo, visibility); err != nil {
+				return err
+			}
 		}
 		
-		remoteURL := fmt.Sprintf("git@github.com:%s/%s.git", username, repoName)
-		cmd := exec.Command("git", "remote", "add", "origin", remoteURL)
+		cmd := exec.Command("git", "remote", "add", "origin", config.CurrentConfig.RemoteURL)
 		cmd.Dir = localPath
-		if err := cmd.Run(); err != nil {
+		if err := utils.RunCommand(cmd); err != nil {
 			return err
 		}
 		
@@ -167,6 +180,11 @@ func GenerateReadme() string {
 	username := config.CurrentConfig.GithubUsername
 	currentOS := config.GetCurrentOS()
 	
+	cloneArg := username
+	if config.GitHost() != config.DefaultGitHost || username == "" {
+		cloneArg = config.CurrentConfig.RemoteURL
+	}
+	
 	content := []string{
 		"# Dotfiles",
 		"",
@@ -195,7 +213,7 @@ func GenerateReadme() string {
 		"",
 		"2. Clone and apply the dotfiles:",
 		"```bash",
-		fmt.Sprintf("dfmgr clone %s", username),
+		fmt.Sprintf("dfmgr clone %s", cloneArg),
 		"```",
 		"",
 		"## License",
@@ -204,4 +222,201 @@ func GenerateReadme() string {
 	)
 	
 	return strings.Join(content, "\n")
-} 
\ No newline at end of file
+}
+
+type RepoStatus struct {
+	Branch   string   `json:"branch"`
+	Upstream string   `json:"upstream,omitempty"`
+	Ahead    int      `json:"ahead"`
+	Behind   int      `json:"behind"`
+	Changes  []string `json:"changes"`
+}
+
+// Clean reports whether there is nothing to commit and nothing to push.
+func (s *RepoStatus) Clean() bool {
+	return len(s.Changes) == 0 && s.Ahead == 0
+}
+
+// Status reports uncommitted changes and how far the current branch is
+// ahead of or behind its upstream.
+func Status(repoPath string) (*RepoStatus, error) {
+	cmd := exec.Command("git", "status", "--porcelain=v2", "--branch")
+	cmd.Dir = repoPath
+	output, err := utils.OutputOf(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("git status failed: %w", err)
+	}
+	
+	status := &RepoStatus{Changes: []string{}}
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# branch.head "):
+			status.Branch = strings.TrimPrefix(line, "# branch.head ")
+		case strings.HasPrefix(line, "# branch.upstream "):
+			status.Upstream = strings.TrimPrefix(line, "# branch.upstream ")
+		case strings.HasPrefix(line, "# branch.ab "):
+			fmt.Sscanf(strings.TrimPrefix(line, "# branch.ab "), "+%d -%d", &status.Ahead, &status.Behind)
+		case strings.HasPrefix(line, "#"):
+		case strings.HasPrefix(line, "? "):
+			status.Changes = append(status.Changes, "?? "+line[2:])
+		case strings.HasPrefix(line, "1 "):
+			fields := strings.SplitN(line, " ", 9)
+			if len(fields) == 9 {
+				status.Changes = append(status.Changes, fields[1]+" "+fields[8])
+			}
+		case strings.HasPrefix(line, "2 "):
+			fields := strings.SplitN(line, " ", 10)
+			if len(fields) == 10 {
+				status.Changes = append(status.Changes, fields[1]+" "+strings.Replace(fields[9], "\t", " <- ", 1))
+			}
+		case strings.HasPrefix(line, "u "):
+			fields := strings.SplitN(line, " ", 11)
+			if len(fields) == 11 {
+				status.Changes = append(status.Changes, fields[1]+" "+fields[10])
+			}
+		}
+	}
+	
+	return status, nil
+}
+
+// OutgoingDiff returns, with no context lines, everything a push would
+// publish once the staged changes are committed: the index compared with
+// the upstream branch. Without an upstream, it is the changes of the
+// commits no remote branch has, followed by the index compared with HEAD.
+func OutgoingDiff(repoPath string) (string, error) {
+	options := []string{"--unified=0", "--no-color", "--no-ext-diff", "--diff-filter=d"}
+	args := append([]string{"-c", "core.quotepath=off", "diff", "--cached"}, options...)
+	
+	if revParse(repoPath, "@{upstream}") {
+		return diffOutput(repoPath, append(args, "@{upstream}"))
+	}
+	
+	unpushed := ""
+	if revParse(repoPath, "HEAD") {
+		logArgs := append([]string{"-c", "core.quotepath=off", "log", "-p", "--format="}, options...)
+		output, err := diffOutput(repoPath, append(logArgs, "HEAD", "--not", "--remotes"))
+		if err != nil {
+			return "", err
+		}
+		unpushed = output
+	}
+	
+	staged, err := diffOutput(repoPath, args)
+	if err != nil {
+		return "", err
+	}
+	return unpushed + staged, nil
+}
+
+// revParse reports whether rev names a commit in the repository at
+// repoPath.
+func revParse(repoPath, rev string) bool {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", rev)
+	cmd.Dir = repoPath
+	_, err := utils.OutputOf(cmd)
+	return err == nil
+}
+
+func diffOutput(repoPath string, args []string) (string, error) {
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	output, err := utils.OutputOf(cmd)
+	if err != nil {
+		return "", fmt.Errorf("git %s failed: %w", args[2], err)
+	}
+	return string(output), nil
+}
+
+// Origin returns the origin remote of the repository at repoPath.
+func Origin(repoPath string) (*Remote, error) {
+	url := originURL(repoPath)
+	if url == "" {
+		return nil, fmt.Errorf("the repository at %s has no origin remote", repoPath)
+	}
+	return ParseRemote(url, "")
+}
+
+// TrackedFiles lists the files committed or staged in the repository at
+// repoPath.
+func TrackedFiles(repoPath string) ([]string, error) {
+	cmd := exec.Command("git", "ls-files", "-z")
+	cmd.Dir = repoPath
+	output, err := utils.OutputOf(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("git ls-files failed: %w", err)
+	}
+	
+	var files []string
+	for _, file := range strings.Split(string(output), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// Head returns the commit checked out in the repository at repoPath, or ""
+// for a repository without commits.
+func Head(repoPath string) string {
+	cmd := exec.Command("git", "rev-parse", "--verify", "--quiet", "HEAD")
+	cmd.Dir = repoPath
+	output, err := utils.OutputOf(cmd)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
+
+// ChangedFiles lists the files that differ between the commits from and to
+// of the repository at repoPath. An empty from lists every file of to.
+func ChangedFiles(repoPath, from, to string) ([]string, error) {
+	args := []string{"-c", "core.quotepath=off", "diff", "--name-only", "-z", from, to}
+	if from == "" {
+		args = []string{"ls-tree", "-r", "--name-only", "-z", to}
+	}
+	
+	cmd := exec.Command("git", args...)
+	cmd.Dir = repoPath
+	output, err := utils.OutputOf(cmd)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list changed files: %w", err)
+	}
+	
+	files := []string{}
+	for _, file := range strings.Split(string(output), "\x00") {
+		if file != "" {
+			files = append(files, file)
+		}
+	}
+	return files, nil
+}
+
+// OriginVisibility asks the forge of the origin remote of the repository
+// at repoPath whether it is public, unless remote_visibility in the
+// configuration says so for forges dfmgr cannot ask. Repositories outside
+// of a forge, such as local ones, count as private.
+func OriginVisibility(repoPath string) (Visibility, error) {
+	switch visibility := Visibility(config.CurrentConfig.RemoteVisibility); visibility {
+	case "":
+	case VisibilityPublic, VisibilityPrivate:
+		return visibility, nil
+	default:
+		return "", fmt.Errorf("invalid remote_visibility %q in %s, use public or private", visibility, config.ConfigFile())
+	}
+	
+	remote, err := Origin(repoPath)
+	if err != nil {
+		return "", err
+	}
+	if remote.Host == "" || remote.Owner == "" {
+		return VisibilityPrivate, nil
+	}
+	
+	forge, err := NewForge(remote.Host)
+	if err != nil {
+		return "", err
+	}
+	return forge.Visibility(remote.Owner, remote.Repo)
+}
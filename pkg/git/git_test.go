@@ -0,0 +1,193 @@
+package git
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/cetincetindag/dfmgr/internal/testutil"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// isolate keeps git away from the configuration and the token of whoever
+// runs the tests.
+func isolate(t *testing.T) {
+	t.Helper()
+	testutil.IsolateGit(t)
+	t.Setenv(TokenEnv, "")
+}
+
+var run = testutil.Git
+
+// bareRepo returns the URL of a bare repository holding one commit with
+// files.
+func bareRepo(t *testing.T, files map[string]string) string {
+	t.Helper()
+	dir := t.TempDir()
+	bare := filepath.Join(dir, "dotfiles.git")
+	seed := filepath.Join(dir, "seed")
+
+	run(t, dir, "init", "--bare", "--initial-branch=main", bare)
+	run(t, dir, "init", "--initial-branch=main", seed)
+	for name, content := range files {
+		path := filepath.Join(seed, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	run(t, seed, "add", ".")
+	run(t, seed, "commit", "-m", "Initial dotfiles")
+	run(t, seed, "push", bare, "main")
+	return "file://" + bare
+}
+
+func TestCloneCommitPushPull(t *testing.T) {
+	isolate(t)
+	url := bareRepo(t, map[string]string{"bash/.bashrc": "export EDITOR=nvim\n"})
+
+	remote, err := ParseRemote(url, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remote.Repo != "dotfiles" {
+		t.Errorf("Repo = %q, want dotfiles", remote.Repo)
+	}
+
+	work := filepath.Join(t.TempDir(), "dotfiles")
+	if err := CloneRepo(remote, work); err != nil {
+		t.Fatal(err)
+	}
+	files, err := TrackedFiles(work)
+	if err != nil || !reflect.DeepEqual(files, []string{"bash/.bashrc"}) {
+		t.Fatalf("TrackedFiles = %v, %v", files, err)
+	}
+	if origin, err := Origin(work); err != nil || origin.URL != url {
+		t.Errorf("Origin = %+v, %v", origin, err)
+	}
+
+	os.MkdirAll(filepath.Join(work, "git"), 0755)
+	os.WriteFile(filepath.Join(work, "git", ".gitconfig"), []byte("[user]\n"), 0644)
+
+	status, err := Status(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Clean() || !reflect.DeepEqual(status.Changes, []string{"?? git/"}) {
+		t.Errorf("Status before commit = %+v", status)
+	}
+
+	if err := AddFiles(work); err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(work, "Add git"); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := Status(work); status.Ahead != 1 {
+		t.Errorf("Ahead = %d after commit, want 1", status.Ahead)
+	}
+	if err := Push(work); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := Status(work); !status.Clean() || status.Upstream != "origin/main" {
+		t.Errorf("Status after push = %+v", status)
+	}
+
+	other := filepath.Join(t.TempDir(), "dotfiles")
+	if err := CloneRepo(remote, other); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(work, "bash", ".bashrc"), []byte("export EDITOR=vi\n"), 0644)
+	if err := AddFiles(work); err != nil {
+		t.Fatal(err)
+	}
+	if err := Commit(work, "Switch editor"); err != nil {
+		t.Fatal(err)
+	}
+	if err := Push(work); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Pull(other); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(other, "bash", ".bashrc")); string(data) != "export EDITOR=vi\n" {
+		t.Errorf("pulled .bashrc = %q", data)
+	}
+}
+
+func TestOutgoingDiffWithoutUpstream(t *testing.T) {
+	isolate(t)
+	url := bareRepo(t, map[string]string{"bash/.bashrc": "export EDITOR=nvim\n"})
+	work := filepath.Join(t.TempDir(), "dotfiles")
+	run(t, filepath.Dir(work), "init", "--initial-branch=main", work)
+	run(t, work, "remote", "add", "origin", url)
+	run(t, work, "fetch", "origin")
+	run(t, work, "reset", "--hard", "origin/main")
+
+	// A commit made outside dfmgr and never pushed, then a staged change.
+	os.MkdirAll(filepath.Join(work, "git"), 0755)
+	os.WriteFile(filepath.Join(work, "git", ".gitconfig"), []byte("[user]\n"), 0644)
+	run(t, work, "add", "git")
+	run(t, work, "commit", "-m", "Add git")
+	os.WriteFile(filepath.Join(work, "bash", ".bashrc"), []byte("export EDITOR=vi\n"), 0644)
+	run(t, work, "add", "bash")
+
+	diff, err := OutgoingDiff(work)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"+[user]", "+export EDITOR=vi"} {
+		if !strings.Contains(diff, want) {
+			t.Errorf("OutgoingDiff lacks %q:\n%s", want, diff)
+		}
+	}
+	if strings.Contains(diff, "+export EDITOR=nvim") {
+		t.Errorf("OutgoingDiff holds the pushed commit:\n%s", diff)
+	}
+}
+
+// recorder is a utils.Runner that records commands instead of running
+// them, answering each with output.
+type recorder struct {
+	output   string
+	commands [][]string
+	envs     [][]string
+}
+
+func (r *recorder) CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	r.commands = append(r.commands, cmd.Args)
+	r.envs = append(r.envs, cmd.Env)
+	return []byte(r.output), nil
+}
+
+func (r *recorder) Output(cmd *exec.Cmd) ([]byte, error) {
+	return r.CombinedOutput(cmd)
+}
+
+func TestPushSendsToken(t *testing.T) {
+	r := &recorder{output: "https://example.com/me/dotfiles.git\n"}
+	runner := utils.DefaultRunner
+	utils.DefaultRunner = r
+	t.Cleanup(func() { utils.DefaultRunner = runner })
+	t.Setenv(TokenEnv, "secret")
+
+	if err := Push("/nonexistent"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(r.commands) < 2 {
+		t.Fatalf("ran %v", r.commands)
+	}
+	push := strings.Join(r.commands[1], " ")
+	if push != "git push -u origin HEAD" {
+		t.Errorf("push ran %q, the token must not be on the command line", push)
+	}
+	env := strings.Join(r.envs[1], "\n")
+	if !strings.Contains(env, "GIT_CONFIG_KEY_0=http.https://example.com/.extraHeader\n") || !strings.Contains(env, "GIT_CONFIG_VALUE_0=Authorization: Basic ") {
+		t.Errorf("push environment lacks the token")
+	}
+}
@@ -0,0 +1,93 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// githubForge speaks the GitHub REST API. Gitea implements the same
+// endpoints for repositories, so it serves both.
+type githubForge struct {
+	name string
+	api  *apiClient
+}
+
+type githubRepo struct {
+	Name  string `json:"name"`
+	Owner struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+	Private       bool   `json:"private"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+func (r *githubRepo) repository() *Repository {
+	repo := &Repository{Owner: r.Owner.Login, Name: r.Name, Visibility: VisibilityPublic, DefaultBranch: r.DefaultBranch}
+	if r.Private {
+		repo.Visibility = VisibilityPrivate
+	}
+	return repo
+}
+
+func (f *githubForge) Name() string {
+	return f.name
+}
+
+func (f *githubForge) CreateRepo(name string, visibility Visibility) (*Repository, error) {
+	var repo githubRepo
+	body := map[string]interface{}{"name": name, "private": visibility == VisibilityPrivate}
+	if err := f.api.do(http.MethodPost, "/user/repos", body, &repo); err != nil {
+		return nil, fmt.Errorf("failed to create repository %s: %w", name, err)
+	}
+	return repo.repository(), nil
+}
+
+func (f *githubForge) ForkRepo(owner, name string) (*Repository, error) {
+	var repo githubRepo
+	if err := f.api.do(http.MethodPost, f.path(owner, name)+"/forks", map[string]interface{}{}, &repo); err != nil {
+		return nil, fmt.Errorf("failed to fork %s/%s: %w", owner, name, err)
+	}
+	return repo.repository(), nil
+}
+
+func (f *githubForge) get(owner, name string) (*Repository, error) {
+	var repo githubRepo
+	if err := f.api.do(http.MethodGet, f.path(owner, name), nil, &repo); err != nil {
+		return nil, err
+	}
+	return repo.repository(), nil
+}
+
+func (f *githubForge) RepoExists(owner, name string) (bool, error) {
+	_, err := f.get(owner, name)
+	return exists(err)
+}
+
+func (f *githubForge) Visibility(owner, name string) (Visibility, error) {
+	repo, err := f.get(owner, name)
+	if err != nil {
+		return "", err
+	}
+	return repo.Visibility, nil
+}
+
+func (f *githubForge) SetVisibility(owner, name string, visibility Visibility) error {
+	body := map[string]interface{}{"private": visibility == VisibilityPrivate}
+	if err := f.api.do(http.MethodPatch, f.path(owner, name), body, nil); err != nil {
+		return fmt.Errorf("failed to make %s/%s %s: %w", owner, name, visibility, err)
+	}
+	return nil
+}
+
+func (f *githubForge) DefaultBranch(owner, name string) (string, error) {
+	repo, err := f.get(owner, name)
+	if err != nil {
+		return "", err
+	}
+	return repo.DefaultBranch, nil
+}
+
+func (f *githubForge) path(owner, name string) string {
+	return fmt.Sprintf("/repos/%s/%s", url.PathEscape(owner), url.PathEscape(name))
+}
@@ -0,0 +1,92 @@
+package git
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+)
+
+// gitlabForge speaks the GitLab REST API, where projects are addressed by
+// their URL-encoded path, which may include subgroups.
+type gitlabForge struct {
+	api *apiClient
+}
+
+type gitlabProject struct {
+	Path      string `json:"path"`
+	Namespace struct {
+		FullPath string `json:"full_path"`
+	} `json:"namespace"`
+	Visibility    string `json:"visibility"`
+	DefaultBranch string `json:"default_branch"`
+}
+
+func (p *gitlabProject) repository() *Repository {
+	repo := &Repository{Owner: p.Namespace.FullPath, Name: p.Path, Visibility: VisibilityPrivate, DefaultBranch: p.DefaultBranch}
+	if p.Visibility == string(VisibilityPublic) {
+		repo.Visibility = VisibilityPublic
+	}
+	return repo
+}
+
+func (f *gitlabForge) Name() string {
+	return "GitLab"
+}
+
+func (f *gitlabForge) CreateRepo(name string, visibility Visibility) (*Repository, error) {
+	var project gitlabProject
+	body := map[string]interface{}{"name": name, "path": name, "visibility": visibility}
+	if err := f.api.do(http.MethodPost, "/projects", body, &project); err != nil {
+		return nil, fmt.Errorf("failed to create repository %s: %w", name, err)
+	}
+	return project.repository(), nil
+}
+
+func (f *gitlabForge) ForkRepo(owner, name string) (*Repository, error) {
+	var project gitlabProject
+	if err := f.api.do(http.MethodPost, f.path(owner, name)+"/fork", map[string]interface{}{}, &project); err != nil {
+		return nil, fmt.Errorf("failed to fork %s/%s: %w", owner, name, err)
+	}
+	return project.repository(), nil
+}
+
+func (f *gitlabForge) get(owner, name string) (*Repository, error) {
+	var project gitlabProject
+	if err := f.api.do(http.MethodGet, f.path(owner, name), nil, &project); err != nil {
+		return nil, err
+	}
+	return project.repository(), nil
+}
+
+func (f *gitlabForge) RepoExists(owner, name string) (bool, error) {
+	_, err := f.get(owner, name)
+	return exists(err)
+}
+
+func (f *gitlabForge) Visibility(owner, name string) (Visibility, error) {
+	repo, err := f.get(owner, name)
+	if err != nil {
+		return "", err
+	}
+	return repo.Visibility, nil
+}
+
+func (f *gitlabForge) SetVisibility(owner, name string, visibility Visibility) error {
+	body := map[string]interface{}{"visibility": visibility}
+	if err := f.api.do(http.MethodPut, f.path(owner, name), body, nil); err != nil {
+		return fmt.Errorf("failed to make %s/%s %s: %w", owner, name, visibility, err)
+	}
+	return nil
+}
+
+func (f *gitlabForge) DefaultBranch(owner, name string) (string, error) {
+	repo, err := f.get(owner, name)
+	if err != nil {
+		return "", err
+	}
+	return repo.DefaultBranch, nil
+}
+
+func (f *gitlabForge) path(owner, name string) string {
+	return "/projects/" + url.PathEscape(owner+"/"+name)
+}
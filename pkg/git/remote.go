@@ -0,0 +1,192 @@
+package git
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// TokenEnv holds an access token used for HTTPS remotes, so that CI jobs
+// can clone and push without a credential helper.
+const TokenEnv = "DFMGR_GIT_TOKEN"
+
+// Remote is a git repository dfmgr clones from or pushes to. Host, Owner
+// and Repo are empty when they cannot be told from URL, e.g. for local
+// repositories.
+type Remote struct {
+	Host  string
+	Owner string
+	Repo  string
+	URL   string
+}
+
+// String returns a short name for the remote, such as
+// github.com/alice/dotfiles.
+func (r *Remote) String() string {
+	if r.Host == "" || r.Owner == "" {
+		return r.URL
+	}
+	return fmt.Sprintf("%s/%s/%s", r.Host, r.Owner, r.Repo)
+}
+
+// NewRemote returns the remote of owner/repo on host, reached over the
+// protocol set in the configuration.
+func NewRemote(host, owner, repo string) *Remote {
+	remote := &Remote{Host: host, Owner: owner, Repo: repo}
+	if config.GitProtocol() == config.ProtocolHTTPS {
+		remote.URL = fmt.Sprintf("https://%s/%s/%s.git", host, owner, repo)
+	} else {
+		remote.URL = fmt.Sprintf("git@%s:%s/%s.git", host, owner, repo)
+	}
+	return remote
+}
+
+// ParseRemote resolves what a user passed to clone, fork or init:
+//
+//   - a URL such as https://gitea.example.com/alice/dotfiles.git,
+//     ssh://git@host/alice/dotfiles or file:///srv/git/dotfiles.git
+//   - an scp-style address such as git@host:alice/dotfiles.git
+//   - a host:owner/repo shorthand such as gitlab.com:alice/dotfiles
+//   - owner/repo or a bare owner on the default host, with defaultRepo as
+//     the repository name
+//   - a path to a local repository starting with /, ./, ../ or ~/
+//
+// Addresses without a user or scheme are reached over the configured
+// protocol.
+func ParseRemote(arg, defaultRepo string) (*Remote, error) {
+	arg = strings.TrimSpace(arg)
+
+	switch {
+	case arg == "":
+		return nil, fmt.Errorf("no repository given")
+
+	case strings.Contains(arg, "://"):
+		u, err := url.Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid repository URL %q: %w", arg, err)
+		}
+		if u.Scheme == "file" {
+			return &Remote{Repo: repoName(u.Path), URL: arg}, nil
+		}
+		owner, repo, err := splitRepoPath(u.Path, defaultRepo)
+		if err != nil {
+			return nil, err
+		}
+		return &Remote{Host: u.Hostname(), Owner: owner, Repo: repo, URL: arg}, nil
+
+	case isLocalPath(arg):
+		path := arg
+		if strings.HasPrefix(path, "~/") {
+			path = filepath.Join(config.Home(), path[2:])
+		}
+		path, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		return &Remote{Repo: repoName(path), URL: path}, nil
+
+	case strings.Contains(arg, ":"):
+		address, path, _ := strings.Cut(arg, ":")
+		owner, repo, err := splitRepoPath(path, defaultRepo)
+		if err != nil {
+			return nil, err
+		}
+		if user, host, ok := strings.Cut(address, "@"); ok {
+			if user == "" || host == "" {
+				return nil, fmt.Errorf("invalid repository address %q", arg)
+			}
+			return &Remote{Host: host, Owner: owner, Repo: repo, URL: arg}, nil
+		}
+		return NewRemote(address, owner, repo), nil
+
+	default:
+		owner, repo, err := splitRepoPath(arg, defaultRepo)
+		if err != nil {
+			return nil, err
+		}
+		return NewRemote(config.GitHost(), owner, repo), nil
+	}
+}
+
+// splitRepoPath splits owner/repo. Everything before the last element is
+// the owner, so GitLab subgroups such as team/infra/dotfiles work.
+func splitRepoPath(path, defaultRepo string) (string, string, error) {
+	path = strings.TrimSuffix(strings.Trim(path, "/"), ".git")
+	if path == "" || strings.ContainsAny(path, " \t") {
+		return "", "", fmt.Errorf("invalid repository %q", path)
+	}
+
+	i := strings.LastIndex(path, "/")
+	if i < 0 {
+		if defaultRepo == "" {
+			return "", "", fmt.Errorf("no repository name in %q", path)
+		}
+		return path, defaultRepo, nil
+	}
+	return path[:i], path[i+1:], nil
+}
+
+func repoName(path string) string {
+	return strings.TrimSuffix(filepath.Base(strings.TrimRight(path, "/")), ".git")
+}
+
+func isLocalPath(arg string) bool {
+	for _, prefix := range []string{"/", "./", "../", "~/"} {
+		if strings.HasPrefix(arg, prefix) {
+			return true
+		}
+	}
+	return arg == "." || arg == ".."
+}
+
+// authEnv returns the environment that makes git send the token from
+// TokenEnv to an HTTPS remote, or nothing when there is no token or the
+// remote uses another protocol. The token goes through the environment of
+// git so that it shows up neither in its command line, which other users
+// can read from ps, nor in the repository configuration.
+func authEnv(remoteURL string) []string {
+	token := os.Getenv(TokenEnv)
+	if token == "" || !strings.HasPrefix(remoteURL, "https://") {
+		return nil
+	}
+	u, err := url.Parse(remoteURL)
+	if err != nil {
+		return nil
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+	return []string{
+		"GIT_CONFIG_COUNT=1",
+		fmt.Sprintf("GIT_CONFIG_KEY_0=http.https://%s/.extraHeader", u.Host),
+		"GIT_CONFIG_VALUE_0=Authorization: Basic " + credentials,
+	}
+}
+
+// withAuth returns the environment of a git command talking to remoteURL:
+// nil, to inherit that of dfmgr, unless authEnv has a token to add.
+func withAuth(remoteURL string) []string {
+	env := authEnv(remoteURL)
+	if env == nil {
+		return nil
+	}
+	return append(os.Environ(), env...)
+}
+
+// originURL returns the URL of the origin remote of the repository at
+// repoPath, or "" if it has none.
+func originURL(repoPath string) string {
+	cmd := exec.Command("git", "remote", "get-url", "origin")
+	cmd.Dir = repoPath
+	output, err := utils.OutputOf(cmd)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(output))
+}
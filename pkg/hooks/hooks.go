@@ -0,0 +1,171 @@
+// Package hooks runs the commands the repository manifest declares for
+// dfmgr events, such as reloading tmux after apply, for the packages an
+// operation actually changed.
+package hooks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// The events of manifest.HookEvents.
+const (
+	BeforeApply  = "before_apply"
+	AfterApply   = "after_apply"
+	AfterUnapply = "after_unapply"
+	BeforePush   = "before_push"
+	AfterFetch   = "after_fetch"
+)
+
+// Disabled turns every hook off, for repositories that are not trusted.
+var Disabled bool
+
+// Changes maps the packages an operation changed, by path, to the files it
+// changed in them: home directory paths for apply and unapply, repository
+// paths for push and fetch.
+type Changes map[string][]string
+
+// Add records file as changed in pkg.
+func (c Changes) Add(pkg, file string) {
+	c[pkg] = append(c[pkg], file)
+}
+
+// Packages returns the changed packages, sorted.
+func (c Changes) Packages() []string {
+	packages := make([]string, 0, len(c))
+	for pkg := range c {
+		packages = append(packages, pkg)
+	}
+	sort.Strings(packages)
+	return packages
+}
+
+// Files returns the changed files of every package, sorted.
+func (c Changes) Files() []string {
+	var files []string
+	for _, list := range c {
+		files = append(files, list...)
+	}
+	sort.Strings(files)
+	return files
+}
+
+// ByPackage groups files, repository paths as git prints them, by the
+// package among packages holding them. Files outside every package are
+// left out.
+func ByPackage(packages, files []string) Changes {
+	changes := make(Changes)
+	for _, file := range files {
+		best := ""
+		for _, pkg := range packages {
+			dir := filepath.ToSlash(pkg)
+			if strings.HasPrefix(file, dir+"/") && len(pkg) > len(best) {
+				best = pkg
+			}
+		}
+		if best != "" {
+			changes.Add(best, file)
+		}
+	}
+	return changes
+}
+
+// Run runs the hooks of event in the manifest of the dotfiles repository:
+// those of every package in changes, in the order of their names, then the
+// global ones. Nothing runs when changes is empty. A failing hook is
+// reported and, when it aborts, returned without running the others.
+func Run(event string, changes Changes) error {
+	if Disabled || len(changes) == 0 {
+		return nil
+	}
+
+	localPath := config.CurrentConfig.LocalPath
+	m, err := manifest.Load(localPath)
+	if err != nil || m == nil {
+		return err
+	}
+
+	for _, name := range m.Names() {
+		pkg := m.Packages[name]
+		path := pkg.PathOf(name)
+		files, ok := changes[path]
+		if !ok {
+			continue
+		}
+		for _, hook := range pkg.Hooks[event] {
+			label := fmt.Sprintf("%s hook of %s", event, name)
+			if err := run(label, hook, filepath.Join(localPath, path), environ(event, path, files, changes)); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, hook := range m.Hooks[event] {
+		if err := run(event+" hook", hook, localPath, environ(event, "", changes.Files(), changes)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// environ describes the event to a hook of pkg, empty for global hooks.
+// Lists hold one entry per line.
+func environ(event, pkg string, files []string, changes Changes) []string {
+	return []string{
+		"DFMGR_EVENT=" + event,
+		"DFMGR_PACKAGE=" + pkg,
+		"DFMGR_PACKAGES=" + strings.Join(changes.Packages(), "\n"),
+		"DFMGR_CHANGED_FILES=" + strings.Join(files, "\n"),
+		"DFMGR_REPO=" + config.CurrentConfig.LocalPath,
+		"DFMGR_HOME=" + config.Home(),
+	}
+}
+
+func run(label string, hook manifest.Hook, dir string, env []string) error {
+	utils.Info("Running %s: %s", label, hook.Run)
+
+	timeout := hook.TimeoutDuration()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := shell(ctx, hook.Run)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), env...)
+	// Commands that leave a child holding their output open still end
+	// with the hook.
+	cmd.WaitDelay = time.Second
+
+	err := utils.RunCommand(cmd)
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("timed out after %s", timeout)
+	}
+	if err == nil {
+		utils.Record("hook", hook.Run, label)
+		return nil
+	}
+
+	if hook.Aborts() {
+		return fmt.Errorf("%s failed: %w", label, err)
+	}
+	utils.Warning("%s failed: %s", label, err)
+	return nil
+}
+
+func shell(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
@@ -0,0 +1,152 @@
+package hooks
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+)
+
+// repository writes a manifest with m to a temporary dotfiles repository
+// and makes it the configured one.
+func repository(t *testing.T, m string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("hooks in these tests are shell scripts")
+	}
+
+	dir := t.TempDir()
+	for _, pkg := range []string{"tmux", "zsh"} {
+		if err := os.Mkdir(filepath.Join(dir, pkg), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, manifest.FileName), []byte(m), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, log := config.CurrentConfig, utils.Log
+	config.CurrentConfig.LocalPath = dir
+	utils.Log = &utils.Logger{Level: utils.LevelInfo, Writer: io.Discard}
+	t.Cleanup(func() { config.CurrentConfig, utils.Log = saved, log })
+	return dir
+}
+
+func read(t *testing.T, path string) string {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+func TestHookJSON(t *testing.T) {
+	var hooks []manifest.Hook
+	if err := json.Unmarshal([]byte(`["fc-cache", {"run": "sleep 5", "timeout": "1s", "on_failure": "abort"}]`), &hooks); err != nil {
+		t.Fatal(err)
+	}
+	want := []manifest.Hook{{Run: "fc-cache"}, {Run: "sleep 5", Timeout: "1s", OnFailure: manifest.HookAbort}}
+	if !reflect.DeepEqual(hooks, want) {
+		t.Fatalf("hooks = %+v", hooks)
+	}
+
+	data, err := json.Marshal(hooks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != `["fc-cache",{"run":"sleep 5","timeout":"1s","on_failure":"abort"}]` {
+		t.Errorf("Marshal = %s", data)
+	}
+}
+
+func TestRunOnlyChangedPackages(t *testing.T) {
+	dir := repository(t, `{
+  "version": 1,
+  "packages": {
+    "tmux": {"hooks": {"after_apply": ["echo \"$DFMGR_PACKAGE $DFMGR_CHANGED_FILES\" >> ../ran"]}},
+    "zsh": {"hooks": {"after_apply": ["echo zsh >> ../ran"]}}
+  },
+  "hooks": {"after_apply": ["echo \"global $DFMGR_EVENT $DFMGR_PACKAGES\" >> ran"], "after_fetch": ["echo fetch >> ran"]}
+}`)
+
+	changes := Changes{"tmux": {"/home/me/.tmux.conf"}}
+	if err := Run(AfterApply, changes); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, filepath.Join(dir, "ran")); got != "tmux /home/me/.tmux.conf\nglobal after_apply tmux\n" {
+		t.Errorf("hooks wrote %q", got)
+	}
+
+	os.Remove(filepath.Join(dir, "ran"))
+	if err := Run(AfterFetch, Changes{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := read(t, filepath.Join(dir, "ran")); got != "" {
+		t.Errorf("hooks ran without changes: %q", got)
+	}
+}
+
+func TestRunFailurePolicies(t *testing.T) {
+	dir := repository(t, `{
+  "version": 1,
+  "packages": {"tmux": {}},
+  "hooks": {
+    "before_apply": ["exit 3", "echo after-warning >> ran"],
+    "before_push": [{"run": "exit 3", "on_failure": "abort"}, "echo after-abort >> ran"],
+    "after_apply": [{"run": "sleep 5", "timeout": "100ms", "on_failure": "abort"}]
+  }
+}`)
+	changes := Changes{"tmux": {"tmux/.tmux.conf"}}
+
+	if err := Run(BeforeApply, changes); err != nil {
+		t.Errorf("warning hook failed the operation: %v", err)
+	}
+	if err := Run(BeforePush, changes); err == nil || !strings.Contains(err.Error(), "before_push hook failed") {
+		t.Errorf("Run = %v, want the aborting hook's failure", err)
+	}
+	if got := read(t, filepath.Join(dir, "ran")); got != "after-warning\n" {
+		t.Errorf("hooks wrote %q", got)
+	}
+
+	if err := Run(AfterApply, changes); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Run = %v, want a timeout", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	dir := repository(t, `{"version": 1, "hooks": {"after_unapply": ["touch ran"]}}`)
+	Disabled = true
+	defer func() { Disabled = false }()
+
+	if err := Run(AfterUnapply, Changes{"zsh": {"/home/me/.zshrc"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ran")); err == nil {
+		t.Error("hook ran while disabled")
+	}
+}
+
+func TestByPackage(t *testing.T) {
+	changes := ByPackage([]string{"nvim", "linux/nvim", "zsh"}, []string{
+		"nvim/.config/nvim/init.lua",
+		"linux/nvim/.config/nvim/linux.lua",
+		".dfmgr.json",
+		"zshrc",
+	})
+	want := Changes{
+		"nvim":       {"nvim/.config/nvim/init.lua"},
+		"linux/nvim": {"linux/nvim/.config/nvim/linux.lua"},
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("ByPackage = %v", changes)
+	}
+}
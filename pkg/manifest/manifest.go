@@ -0,0 +1,361 @@
+package manifest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+)
+
+const (
+	FileName       = ".dfmgr.json"
+	CurrentVersion = 1
+)
+
+var HookEvents = []string{"before_apply", "after_apply", "after_unapply", "before_push", "after_fetch"}
+
+// What a failing hook does: HookWarn reports it and carries on, HookAbort
+// fails the operation.
+const (
+	HookWarn  = "warn"
+	HookAbort = "abort"
+)
+
+// DefaultHookTimeout is how long a hook may run without a timeout of its
+// own.
+const DefaultHookTimeout = time.Minute
+
+// Hook is a shell command run on one of HookEvents. In the manifest it is
+// either the command alone or an object with a timeout ("30s") and what to
+// do when it fails ("warn", the default, or "abort").
+type Hook struct {
+	Run       string `json:"run"`
+	Timeout   string `json:"timeout,omitempty"`
+	OnFailure string `json:"on_failure,omitempty"`
+}
+
+func (h *Hook) UnmarshalJSON(data []byte) error {
+	var command string
+	if err := json.Unmarshal(data, &command); err == nil {
+		*h = Hook{Run: command}
+		return nil
+	}
+
+	type hook Hook
+	return json.Unmarshal(data, (*hook)(h))
+}
+
+func (h Hook) MarshalJSON() ([]byte, error) {
+	if h.Timeout == "" && h.OnFailure == "" {
+		return json.Marshal(h.Run)
+	}
+
+	type hook Hook
+	return json.Marshal(hook(h))
+}
+
+// TimeoutDuration returns how long h may run.
+func (h Hook) TimeoutDuration() time.Duration {
+	if d, err := time.ParseDuration(h.Timeout); err == nil && d > 0 {
+		return d
+	}
+	return DefaultHookTimeout
+}
+
+// Aborts reports whether h failing fails the operation.
+func (h Hook) Aborts() bool {
+	return h.OnFailure == HookAbort
+}
+
+// Manifest describes a dotfiles repository. It is stored as .dfmgr.json at
+// the root of the repository so that a clone knows which packages exist,
+// where they go and on which machines they apply. Without packages, every
+// top-level directory is a package linked into the home directory.
+//
+// Profiles and HostRules are shared by every machine using the repository;
+// the same settings in the dfmgr configuration take precedence.
+//
+// Sensitive lists files, in addition to the built-in list, that push
+// refuses to publish to a public repository.
+type Manifest struct {
+	Version   int                       `json:"version"`
+	Packages  map[string]Package        `json:"packages,omitempty"`
+	Ignore    []string                  `json:"ignore,omitempty"`
+	Sensitive []string                  `json:"sensitive,omitempty"`
+	Requires  []string                  `json:"requires,omitempty"`
+	Hooks     map[string][]Hook         `json:"hooks,omitempty"`
+	Profiles  map[string]config.Profile `json:"profiles,omitempty"`
+	HostRules []config.HostRule         `json:"host_rules,omitempty"`
+}
+
+type Package struct {
+	Path     string            `json:"path,omitempty"`
+	Target   string            `json:"target,omitempty"`
+	OS       []string          `json:"os,omitempty"`
+	Hosts    []string          `json:"hosts,omitempty"`
+	Ignore   []string          `json:"ignore,omitempty"`
+	Requires []string          `json:"requires,omitempty"`
+	Hooks    map[string][]Hook `json:"hooks,omitempty"`
+}
+
+func New() *Manifest {
+	return &Manifest{Version: CurrentVersion}
+}
+
+// Load reads the manifest of the repository at repoPath. A repository
+// without one yields nil and no error.
+func Load(repoPath string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(repoPath, FileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manifest{}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", FileName, err)
+	}
+	return m, nil
+}
+
+func (m *Manifest) Save(repoPath string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(repoPath, FileName), append(data, '\n'), 0644)
+}
+
+// Declare adds the package at path to a manifest that lists its packages
+// explicitly and saves it, so the repository stays self-describing. It
+// reports whether the package had to be added.
+func (m *Manifest) Declare(repoPath, path string) (bool, error) {
+	if m == nil || len(m.Packages) == 0 {
+		return false, nil
+	}
+	for _, name := range m.Names() {
+		if m.Packages[name].PathOf(name) == path {
+			return false, nil
+		}
+	}
+
+	m.Packages[path] = Package{}
+	return true, m.Save(repoPath)
+}
+
+// SensitivePatterns returns the sensitive patterns of the manifest of the
+// repository at repoPath, if it has one.
+func SensitivePatterns(repoPath string) []string {
+	m, err := Load(repoPath)
+	if err != nil || m == nil {
+		return nil
+	}
+	return m.Sensitive
+}
+
+// Names returns the package names in a stable order.
+func (m *Manifest) Names() []string {
+	var names []string
+	for name := range m.Packages {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// PathOf returns the directory of the package relative to the repository.
+func (p Package) PathOf(name string) string {
+	if p.Path != "" {
+		return filepath.Clean(p.Path)
+	}
+	return name
+}
+
+// TargetDir expands the package target against home. Targets are always
+// inside the home directory; the default is the home directory itself.
+func (p Package) TargetDir(home string) string {
+	switch {
+	case p.Target == "" || p.Target == "~":
+		return home
+	case strings.HasPrefix(p.Target, "~/"):
+		return filepath.Join(home, p.Target[2:])
+	default:
+		return filepath.Join(home, p.Target)
+	}
+}
+
+// AppliesTo reports whether the package is meant for a machine known by
+// osNames, its OS folders and fact names such as "linux/arch" or
+// "linux-wsl". An empty OS or host list matches everything; hosts are glob
+// patterns.
+func (p Package) AppliesTo(osNames []string, hostname string) bool {
+	if len(p.OS) > 0 {
+		found := false
+		for _, want := range p.OS {
+			for _, have := range osNames {
+				if strings.EqualFold(want, have) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(p.Hosts) > 0 {
+		for _, pattern := range p.Hosts {
+			if matched, _ := filepath.Match(pattern, hostname); matched {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// Validate checks the manifest against the repository at repoPath and
+// returns every problem found.
+func (m *Manifest) Validate(repoPath string) []error {
+	var errs []error
+
+	if m.Version < 1 || m.Version > CurrentVersion {
+		errs = append(errs, fmt.Errorf("unsupported version %d (this dfmgr supports up to %d)", m.Version, CurrentVersion))
+	}
+
+	errs = append(errs, validatePatterns("ignore", m.Ignore)...)
+	errs = append(errs, validatePatterns("sensitive", m.Sensitive)...)
+	errs = append(errs, validateHooks("hooks", m.Hooks)...)
+	errs = append(errs, validateRequires("requires", m.Requires)...)
+
+	for name, profile := range m.Profiles {
+		if name == config.AllProfile {
+			errs = append(errs, fmt.Errorf("profiles.%s: %q is reserved for selecting every package", name, name))
+		}
+		errs = append(errs, validatePatterns(fmt.Sprintf("profiles.%s.packages", name), profile.Packages)...)
+		errs = append(errs, validatePatterns(fmt.Sprintf("profiles.%s.exclude", name), profile.Exclude)...)
+	}
+	for i, rule := range m.HostRules {
+		field := fmt.Sprintf("host_rules[%d]", i)
+		if rule.Match == "" {
+			errs = append(errs, fmt.Errorf("%s.match: cannot be empty", field))
+		}
+		errs = append(errs, validatePatterns(field+".match", []string{rule.Match})...)
+		errs = append(errs, validatePatterns(field+".packages", rule.Packages)...)
+		if _, ok := m.Profiles[rule.Profile]; rule.Profile != "" && !ok && rule.Profile != config.AllProfile {
+			errs = append(errs, fmt.Errorf("%s.profile: unknown profile %q", field, rule.Profile))
+		}
+	}
+
+	paths := make(map[string]string)
+	for _, name := range m.Names() {
+		pkg := m.Packages[name]
+		prefix := fmt.Sprintf("packages.%s", name)
+
+		if strings.TrimSpace(name) == "" {
+			errs = append(errs, fmt.Errorf("%s: package name cannot be empty", prefix))
+		}
+
+		path := pkg.PathOf(name)
+		if filepath.IsAbs(path) || path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator)) {
+			errs = append(errs, fmt.Errorf("%s.path: %s must be inside the repository", prefix, path))
+		} else if info, err := os.Stat(filepath.Join(repoPath, path)); err != nil {
+			errs = append(errs, fmt.Errorf("%s.path: %s does not exist", prefix, path))
+		} else if !info.IsDir() {
+			errs = append(errs, fmt.Errorf("%s.path: %s is not a directory", prefix, path))
+		}
+
+		if other, ok := paths[path]; ok {
+			errs = append(errs, fmt.Errorf("%s.path: %s is already used by package %s", prefix, path, other))
+		}
+		paths[path] = name
+
+		if !validTarget(pkg.Target) {
+			errs = append(errs, fmt.Errorf("%s.target: %s must be ~ or a path inside the home directory", prefix, pkg.Target))
+		}
+
+		errs = append(errs, validatePatterns(prefix+".hosts", pkg.Hosts)...)
+		errs = append(errs, validatePatterns(prefix+".ignore", pkg.Ignore)...)
+		errs = append(errs, validateHooks(prefix+".hooks", pkg.Hooks)...)
+		errs = append(errs, validateRequires(prefix+".requires", pkg.Requires)...)
+	}
+
+	return errs
+}
+
+func validTarget(target string) bool {
+	switch {
+	case target == "" || target == "~":
+		return true
+	case strings.HasPrefix(target, "~/"):
+		target = target[2:]
+	case strings.HasPrefix(target, "~"), filepath.IsAbs(target):
+		return false
+	}
+	target = filepath.Clean(target)
+	return target != ".." && !strings.HasPrefix(target, ".."+string(filepath.Separator))
+}
+
+func validatePatterns(field string, patterns []string) []error {
+	var errs []error
+	for _, pattern := range patterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid pattern %q", field, pattern))
+		}
+	}
+	return errs
+}
+
+func validateHooks(field string, hooks map[string][]Hook) []error {
+	var errs []error
+	for event, list := range hooks {
+		known := false
+		for _, e := range HookEvents {
+			if e == event {
+				known = true
+			}
+		}
+		if !known {
+			errs = append(errs, fmt.Errorf("%s: unknown event %q (expected one of %s)", field, event, strings.Join(HookEvents, ", ")))
+		}
+		for _, hook := range list {
+			if strings.TrimSpace(hook.Run) == "" {
+				errs = append(errs, fmt.Errorf("%s.%s: empty command", field, event))
+			}
+			if d, err := time.ParseDuration(hook.Timeout); hook.Timeout != "" && (err != nil || d <= 0) {
+				errs = append(errs, fmt.Errorf("%s.%s: invalid timeout %q (expected a duration such as 30s)", field, event, hook.Timeout))
+			}
+			if hook.OnFailure != "" && hook.OnFailure != HookWarn && hook.OnFailure != HookAbort {
+				errs = append(errs, fmt.Errorf("%s.%s: unknown on_failure %q (expected %s or %s)", field, event, hook.OnFailure, HookWarn, HookAbort))
+			}
+		}
+	}
+	return errs
+}
+
+func validateRequires(field string, tools []string) []error {
+	var errs []error
+	for _, tool := range tools {
+		if strings.TrimSpace(tool) == "" {
+			errs = append(errs, fmt.Errorf("%s: empty tool name", field))
+		}
+	}
+	return errs
+}
+
+// Join combines the problems returned by Validate into a single error.
+func Join(errs []error) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid %s: %w", FileName, errors.Join(errs...))
+}
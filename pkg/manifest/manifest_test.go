@@ -0,0 +1,171 @@
+package manifest
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeManifest(t *testing.T, content string) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, FileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := writeManifest(t, `{
+  "version": 1,
+  "packages": {
+    "nvim": {"path": "linux/nvim", "target": "~/.config", "os": ["linux"]}
+  },
+  "hooks": {
+    "after_apply": [
+      "tmux source-file ~/.tmux.conf",
+      {"run": "systemctl --user daemon-reload", "timeout": "30s", "on_failure": "abort"}
+    ]
+  }
+}`)
+
+	m, err := Load(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != 1 || m.Packages["nvim"].PathOf("nvim") != filepath.Join("linux", "nvim") {
+		t.Errorf("Load = %+v", m)
+	}
+
+	want := []Hook{
+		{Run: "tmux source-file ~/.tmux.conf"},
+		{Run: "systemctl --user daemon-reload", Timeout: "30s", OnFailure: HookAbort},
+	}
+	hooks := m.Hooks["after_apply"]
+	if !reflect.DeepEqual(hooks, want) {
+		t.Fatalf("hooks = %+v, want %+v", hooks, want)
+	}
+	if hooks[0].Aborts() || hooks[0].TimeoutDuration() != DefaultHookTimeout {
+		t.Errorf("command-only hook = %+v, want the defaults", hooks[0])
+	}
+	if !hooks[1].Aborts() || hooks[1].TimeoutDuration() != 30*time.Second {
+		t.Errorf("hook object = %+v, want abort after 30s", hooks[1])
+	}
+
+	// Hooks keep their short form when saved.
+	if err := m.Save(dir); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(dir, FileName))
+	if !strings.Contains(string(data), `"tmux source-file ~/.tmux.conf"`) {
+		t.Errorf("saved manifest lost the short hook form:\n%s", data)
+	}
+	if saved, err := Load(dir); err != nil || !reflect.DeepEqual(saved, m) {
+		t.Errorf("Load after Save = %+v, %v, want %+v", saved, err, m)
+	}
+}
+
+func TestLoadWithoutManifest(t *testing.T) {
+	if m, err := Load(t.TempDir()); m != nil || err != nil {
+		t.Errorf("Load = %+v, %v, want nil, nil", m, err)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	for _, content := range []string{`{"version": `, `{"hooks": {"after_apply": [42]}}`} {
+		if _, err := Load(writeManifest(t, content)); err == nil {
+			t.Errorf("Load(%s) succeeded", content)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest Manifest
+		want     string
+	}{
+		{"valid", Manifest{Version: 1, Packages: map[string]Package{
+			"nvim": {Path: "linux/nvim", Target: "~/.config", Hosts: []string{"work-*"}},
+			"bin":  {Target: ".local/bin"},
+		}}, ""},
+		{"version 0", Manifest{Version: 0}, "unsupported version 0"},
+		{"future version", Manifest{Version: CurrentVersion + 1}, "unsupported version"},
+		{"bad ignore pattern", Manifest{Version: 1, Ignore: []string{"[a-"}}, `ignore: invalid pattern "[a-"`},
+		{"bad host pattern", Manifest{Version: 1, Packages: map[string]Package{"bin": {Hosts: []string{"["}}}}, "packages.bin.hosts: invalid pattern"},
+		{"absolute target", Manifest{Version: 1, Packages: map[string]Package{"bin": {Target: "/etc"}}}, "packages.bin.target"},
+		{"target above home", Manifest{Version: 1, Packages: map[string]Package{"bin": {Target: "~/../root"}}}, "packages.bin.target"},
+		{"target of another user", Manifest{Version: 1, Packages: map[string]Package{"bin": {Target: "~root"}}}, "packages.bin.target"},
+		{"path outside repository", Manifest{Version: 1, Packages: map[string]Package{"bin": {Path: "../bin"}}}, "must be inside the repository"},
+		{"absolute path", Manifest{Version: 1, Packages: map[string]Package{"bin": {Path: "/usr/bin"}}}, "must be inside the repository"},
+		{"missing path", Manifest{Version: 1, Packages: map[string]Package{"zsh": {}}}, "zsh does not exist"},
+		{"shared path", Manifest{Version: 1, Packages: map[string]Package{"bin": {}, "scripts": {Path: "bin"}}}, "already used by package bin"},
+		{"unknown event", Manifest{Version: 1, Hooks: map[string][]Hook{"after_push": {{Run: "true"}}}}, `unknown event "after_push"`},
+		{"bad timeout", Manifest{Version: 1, Hooks: map[string][]Hook{"after_apply": {{Run: "true", Timeout: "soon"}}}}, "invalid timeout"},
+		{"bad failure policy", Manifest{Version: 1, Hooks: map[string][]Hook{"after_apply": {{Run: "true", OnFailure: "retry"}}}}, "unknown on_failure"},
+	}
+
+	repo := t.TempDir()
+	for _, dir := range []string{"bin", "linux/nvim"} {
+		if err := os.MkdirAll(filepath.Join(repo, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Join(tt.manifest.Validate(repo))
+			if tt.want == "" {
+				if err != nil {
+					t.Errorf("Validate = %v, want no problems", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTargetDir(t *testing.T) {
+	home := filepath.Join("/home", "user")
+	tests := []struct {
+		target string
+		want   string
+	}{
+		{"", home},
+		{"~", home},
+		{"~/.config", filepath.Join(home, ".config")},
+		{".local/bin", filepath.Join(home, ".local", "bin")},
+	}
+	for _, tt := range tests {
+		if got := (Package{Target: tt.target}).TargetDir(home); got != tt.want {
+			t.Errorf("TargetDir(%q) = %s, want %s", tt.target, got, tt.want)
+		}
+	}
+}
+
+func TestAppliesTo(t *testing.T) {
+	osNames := []string{"linux", "linux/arch"}
+	tests := []struct {
+		name string
+		pkg  Package
+		want bool
+	}{
+		{"everywhere", Package{}, true},
+		{"OS", Package{OS: []string{"Linux"}}, true},
+		{"OS fact", Package{OS: []string{"linux/arch"}}, true},
+		{"other OS", Package{OS: []string{"darwin"}}, false},
+		{"host pattern", Package{Hosts: []string{"work-*"}}, true},
+		{"other host", Package{Hosts: []string{"home-*"}}, false},
+		{"OS and other host", Package{OS: []string{"linux"}, Hosts: []string{"home-*"}}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.pkg.AppliesTo(osNames, "work-laptop"); got != tt.want {
+			t.Errorf("%s: AppliesTo = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
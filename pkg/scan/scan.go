@@ -0,0 +1,238 @@
+package scan
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// AllowMarker on a line, usually in a comment, tells the scanner the line
+// is known to be safe.
+const AllowMarker = "dfmgr:allow"
+
+type Finding struct {
+	File  string `json:"file"`
+	Line  int    `json:"line,omitempty"`
+	Rule  string `json:"rule"`
+	Match string `json:"match,omitempty"`
+}
+
+func (f Finding) String() string {
+	location := f.File
+	if f.Line > 0 {
+		location = fmt.Sprintf("%s:%d", f.File, f.Line)
+	}
+	if f.Match == "" {
+		return fmt.Sprintf("%s: %s", location, f.Rule)
+	}
+	return fmt.Sprintf("%s: %s (%s)", location, f.Rule, f.Match)
+}
+
+type rule struct {
+	name    string
+	pattern *regexp.Regexp
+	// group selects the part of the match that must look random, for
+	// rules that would otherwise match placeholders.
+	group int
+	// fallback rules only apply to lines no other rule matched.
+	fallback bool
+}
+
+var rules = []rule{
+	{name: "private key", pattern: regexp.MustCompile(`-----BEGIN ([A-Z]+ )*PRIVATE KEY( BLOCK)?-----`)},
+	{name: "GitHub token", pattern: regexp.MustCompile(`\b(gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{name: "AWS access key", pattern: regexp.MustCompile(`\b(AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{name: "AWS secret key", pattern: regexp.MustCompile(`(?i)aws_?secret_?access_?key["']?\s*[:=]\s*["']?([A-Za-z0-9/+=]{40})`), group: 1},
+	{name: "Slack token", pattern: regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}`)},
+	{name: "Slack webhook", pattern: regexp.MustCompile(`https://hooks\.slack\.com/services/[A-Za-z0-9/]+`)},
+	{name: "high-entropy secret", pattern: regexp.MustCompile(`(?i)(password|passwd|pwd|secret|token|api[_-]?key|auth[_-]?token|access[_-]?key|client[_-]?secret)["']?\s*[:=]\s*["']?([A-Za-z0-9+/=_\-.~!@#$%^&*]{12,})`), group: 2, fallback: true},
+}
+
+// sensitivePath reports why a file must never be pushed, or "" if it may.
+// Paths are relative to the repository, so packages add a leading
+// directory to the home-relative path.
+func sensitivePath(path string) string {
+	parts := strings.Split(filepath.ToSlash(path), "/")
+	base := parts[len(parts)-1]
+
+	for i, part := range parts[:len(parts)-1] {
+		switch part {
+		case ".ssh":
+			if strings.HasPrefix(base, "id_") && !strings.HasSuffix(base, ".pub") {
+				return "SSH private key"
+			}
+		case ".gnupg":
+			rest := parts[i+1:]
+			if base == "secring.gpg" || rest[0] == "private-keys-v1.d" {
+				return "GnuPG private keyring"
+			}
+		}
+	}
+	return ""
+}
+
+// Diff scans the lines a unified diff adds, as printed by git diff with
+// --unified=0, and the paths of the files it touches. Lines starting with
+// --- or +++ are file headers only before the first hunk of a file; after
+// it they are removed or added lines.
+func Diff(diff string) []Finding {
+	var findings []Finding
+	file := ""
+	line := 0
+	skip := false
+	inHunk := false
+
+	scanner := bufio.NewScanner(strings.NewReader(diff))
+	scanner.Buffer(make([]byte, 1024*1024), 16*1024*1024)
+	for scanner.Scan() {
+		text := scanner.Text()
+		switch {
+		case strings.HasPrefix(text, "diff --git "):
+			file = diffPath(text)
+			inHunk = false
+			skip = strings.HasSuffix(file, ".secret")
+			if reason := sensitivePath(file); reason != "" {
+				findings = append(findings, Finding{File: file, Rule: reason})
+				skip = true
+			}
+		case !inHunk && (strings.HasPrefix(text, "+++ ") || strings.HasPrefix(text, "--- ")):
+		case strings.HasPrefix(text, "@@ "):
+			line = hunkStart(text)
+			inHunk = true
+		case strings.HasPrefix(text, "+"):
+			if !skip {
+				findings = append(findings, Line(file, line, text[1:])...)
+			}
+			line++
+		case strings.HasPrefix(text, " "):
+			line++
+		}
+	}
+	return findings
+}
+
+// Line scans a single line of file.
+func Line(file string, number int, text string) []Finding {
+	if strings.Contains(text, AllowMarker) {
+		return nil
+	}
+
+	var findings []Finding
+	for _, r := range rules {
+		if r.fallback && len(findings) > 0 {
+			continue
+		}
+		for _, match := range r.pattern.FindAllStringSubmatch(text, -1) {
+			value := match[r.group]
+			if r.group > 0 && !random(value) {
+				continue
+			}
+			findings = append(findings, Finding{File: file, Line: number, Rule: r.name, Match: redact(value)})
+		}
+	}
+	return findings
+}
+
+// random tells secrets from placeholders such as "changeme" or
+// "${GITHUB_TOKEN}" by their Shannon entropy.
+func random(value string) bool {
+	if strings.ContainsAny(value, "${}<>") {
+		return false
+	}
+
+	counts := make(map[rune]int)
+	for _, r := range value {
+		counts[r]++
+	}
+	entropy := 0.0
+	n := float64(len([]rune(value)))
+	for _, c := range counts {
+		p := float64(c) / n
+		entropy -= p * math.Log2(p)
+	}
+	return entropy >= 3.5
+}
+
+func redact(value string) string {
+	if len(value) <= 8 {
+		return strings.Repeat("*", len(value))
+	}
+	return value[:4] + strings.Repeat("*", len(value)-4)
+}
+
+func diffPath(header string) string {
+	// diff --git a/path b/path
+	header = strings.TrimPrefix(header, "diff --git ")
+	if i := strings.LastIndex(header, " b/"); i >= 0 {
+		return header[i+3:]
+	}
+	return header
+}
+
+func hunkStart(header string) int {
+	// @@ -a,b +c,d @@
+	fields := strings.Fields(header)
+	if len(fields) < 3 {
+		return 0
+	}
+	start := strings.TrimPrefix(fields[2], "+")
+	if i := strings.Index(start, ","); i >= 0 {
+		start = start[:i]
+	}
+	n, _ := strconv.Atoi(start)
+	return n
+}
+
+// DefaultSensitive lists files that are harmless in a private repository
+// but reveal host names, habits or credentials once published.
+var DefaultSensitive = []string{
+	".ssh/config",
+	".ssh/known_hosts",
+	"*_history",
+	".histfile",
+	".netrc",
+	".git-credentials",
+	".pgpass",
+	".aws/config",
+	".aws/credentials",
+	".kube/config",
+	".docker/config.json",
+	".config/gh/hosts.yml",
+}
+
+// Sensitive returns the paths matching DefaultSensitive or patterns. A
+// pattern matches the whole path or any trailing part of it, so
+// ".ssh/config" matches "ssh/.ssh/config" whatever the package is called.
+func Sensitive(paths []string, patterns []string) []Finding {
+	patterns = append(append([]string{}, DefaultSensitive...), patterns...)
+
+	var findings []Finding
+	for _, path := range paths {
+		parts := strings.Split(filepath.ToSlash(path), "/")
+	match:
+		for i := range parts {
+			for _, pattern := range patterns {
+				if matched, _ := filepath.Match(pattern, strings.Join(parts[i:], "/")); matched {
+					findings = append(findings, Finding{File: path, Rule: "sensitive file"})
+					break match
+				}
+			}
+		}
+	}
+	return findings
+}
+
+// Files returns the paths of the files a unified diff touches.
+func Files(diff string) []string {
+	var files []string
+	for _, line := range strings.Split(diff, "\n") {
+		if strings.HasPrefix(line, "diff --git ") {
+			files = append(files, diffPath(line))
+		}
+	}
+	return files
+}
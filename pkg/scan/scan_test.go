@@ -0,0 +1,131 @@
+package scan
+
+import (
+	"strings"
+	"testing"
+)
+
+// Tokens are split so that this file does not look like it leaks any.
+var (
+	githubToken = "ghp_" + "aB3dE5fG7hJ9kL1mN3pQ5rS7tU9vW1xY3zA5"
+	awsKeyID    = "AKIA" + "IOSFODNN7EXAMPLE"
+	slackToken  = "xoxb-" + "1234567890-a1B2c3D4e5F6"
+	privateKey  = "-----BEGIN OPENSSH " + "PRIVATE KEY-----"
+)
+
+func TestLine(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{"GitHub token", "export GITHUB_TOKEN=" + githubToken, "GitHub token"},
+		{"GitHub token placeholder", "export GITHUB_TOKEN=ghp_xxxx", ""},
+		{"AWS key ID", "aws_access_key_id = " + awsKeyID, "AWS access key"},
+		{"AWS key ID too short", "aws_access_key_id = AKIA1234", ""},
+		{"Slack token", "token: " + slackToken, "Slack token"},
+		{"Slack token of unknown type", "see xoxz-1234567890-abcdef", ""},
+		{"private key", privateKey, "private key"},
+		{"public key", "-----BEGIN PUBLIC KEY-----", ""},
+		{"random password", `password = "Xk9mQ2vL7pR4tZwB"`, "high-entropy secret"},
+		{"placeholder password", `password = "aaaaaaaaaaaaaaaa"`, ""},
+		{"variable password", `password = "${DB_PASSWORD_FROM_ENV}"`, ""},
+		{"allowed", "export GITHUB_TOKEN=" + githubToken + " # " + AllowMarker, ""},
+		{"allowed private key", privateKey + " " + AllowMarker, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			findings := Line("file", 1, tt.text)
+			if tt.want == "" {
+				if len(findings) > 0 {
+					t.Errorf("Line = %v, want nothing", findings)
+				}
+				return
+			}
+			if len(findings) != 1 || findings[0].Rule != tt.want {
+				t.Errorf("Line = %v, want one %s", findings, tt.want)
+			}
+		})
+	}
+}
+
+func TestEntropyFallbackOnlyWithoutOtherRules(t *testing.T) {
+	findings := Line("file", 1, "token = "+githubToken)
+	if len(findings) != 1 || findings[0].Rule != "GitHub token" {
+		t.Errorf("Line = %v, want only the GitHub token", findings)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	diff := strings.Join([]string{
+		"diff --git a/shell/.bashrc b/shell/.bashrc",
+		"--- a/shell/.bashrc",
+		"+++ b/shell/.bashrc",
+		"@@ -10,0 +11,3 @@",
+		"+alias ll='ls -l'",
+		"+++ export GITHUB_TOKEN=" + githubToken,
+		"+--- " + privateKey,
+		"diff --git a/ssh/.ssh/id_ed25519 b/ssh/.ssh/id_ed25519",
+		"--- /dev/null",
+		"+++ b/ssh/.ssh/id_ed25519",
+		"@@ -0,0 +1 @@",
+		"+" + privateKey,
+		"diff --git a/git/.gitconfig.secret b/git/.gitconfig.secret",
+		"@@ -0,0 +1 @@",
+		"+token = " + githubToken,
+	}, "\n")
+
+	want := []Finding{
+		{File: "shell/.bashrc", Line: 12, Rule: "GitHub token"},
+		{File: "shell/.bashrc", Line: 13, Rule: "private key"},
+		{File: "ssh/.ssh/id_ed25519", Rule: "SSH private key"},
+	}
+	findings := Diff(diff)
+	if len(findings) != len(want) {
+		t.Fatalf("Diff = %v, want %v", findings, want)
+	}
+	for i, f := range findings {
+		if f.File != want[i].File || f.Line != want[i].Line || f.Rule != want[i].Rule {
+			t.Errorf("finding %d = %v, want %v", i, f, want[i])
+		}
+	}
+}
+
+func TestSensitivePath(t *testing.T) {
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"ssh/.ssh/id_ed25519", "SSH private key"},
+		{"ssh/.ssh/id_ed25519.pub", ""},
+		{"gpg/.gnupg/private-keys-v1.d/0123ABCD.key", "GnuPG private keyring"},
+		{"gpg/.gnupg/secring.gpg", "GnuPG private keyring"},
+		{"gpg/.gnupg/gpg.conf", ""},
+		{"shell/.bashrc", ""},
+	}
+	for _, tt := range tests {
+		if got := sensitivePath(tt.path); got != tt.want {
+			t.Errorf("sensitivePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestSensitive(t *testing.T) {
+	paths := []string{
+		"ssh/.ssh/config",
+		"ssh/.ssh/id_ed25519.pub",
+		"shell/.zsh_history",
+		"shell/.bashrc",
+		"work/notes/clients.txt",
+	}
+	findings := Sensitive(paths, []string{"notes/*.txt"})
+
+	var got []string
+	for _, f := range findings {
+		got = append(got, f.File)
+	}
+	want := "ssh/.ssh/config shell/.zsh_history work/notes/clients.txt"
+	if strings.Join(got, " ") != want {
+		t.Errorf("Sensitive = %v, want %s", got, want)
+	}
+}
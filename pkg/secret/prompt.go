@@ -0,0 +1,62 @@
+package secret
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/manifoldco/promptui"
+)
+
+// PassphraseEnv lets scripts provide the passphrase without a prompt.
+const PassphraseEnv = "DFMGR_PASSPHRASE"
+
+// AskPassphrase reads the passphrase from the environment or asks for it.
+func AskPassphrase() (string, error) {
+	if passphrase := os.Getenv(PassphraseEnv); passphrase != "" {
+		return passphrase, nil
+	}
+	if !utils.Interactive() {
+		return "", fmt.Errorf("no terminal to ask for the passphrase, set %s", PassphraseEnv)
+	}
+
+	prompt := promptui.Prompt{
+		Label:  "Secret passphrase",
+		Mask:   '*',
+		Stdout: utils.PromptOutput,
+	}
+	passphrase, err := prompt.Run()
+	if err != nil {
+		return "", fmt.Errorf("passphrase prompt failed: %w", err)
+	}
+	return passphrase, nil
+}
+
+// KeyFile returns the path of the secret key, set with secret_key in the
+// dfmgr configuration and ~/.dfmgr_key by default.
+func KeyFile() string {
+	path := config.CurrentConfig.SecretKey
+	switch {
+	case path == "":
+		return filepath.Join(config.Home(), ".dfmgr_key")
+	case strings.HasPrefix(path, "~/"):
+		return filepath.Join(config.Home(), path[2:])
+	}
+	return path
+}
+
+// LoadKeyring returns a keyring with the configured secret key, if this
+// machine has one, that asks for a passphrase when a secret needs it.
+func LoadKeyring() (*Keyring, error) {
+	keyring := &Keyring{Passphrase: AskPassphrase}
+
+	identity, err := LoadIdentity(KeyFile())
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	keyring.Identity = identity
+	return keyring, nil
+}
@@ -0,0 +1,329 @@
+package secret
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Suffix marks encrypted files in the repository. apply decrypts them to a
+// real file without the suffix, readable only by the owner.
+const Suffix = ".secret"
+
+const (
+	header          = "dfmgr-secret/v1"
+	identityPrefix  = "DFMGR-SECRET-KEY-"
+	recipientPrefix = "dfmgr-pub-"
+	scryptLogN      = 16
+)
+
+var (
+	// ErrNoKey is returned when a file is encrypted for a key this machine
+	// does not have, and no passphrase can be asked for.
+	ErrNoKey = errors.New("no key to decrypt it, copy your secret key to this machine or set secret_key in ~/.dfmgr")
+
+	ErrWrongKey = errors.New("the secret key or passphrase does not match")
+
+	b64 = base64.RawStdEncoding
+)
+
+// Identity is an X25519 private key. It never belongs in the repository;
+// the same key is copied to every machine that needs to decrypt secrets.
+type Identity struct {
+	key *ecdh.PrivateKey
+}
+
+func GenerateIdentity() (*Identity, error) {
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	return &Identity{key: key}, nil
+}
+
+// LoadIdentity reads a key written by Save. Lines starting with # are
+// comments.
+func LoadIdentity(path string) (*Identity, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		raw, err := b64.DecodeString(strings.TrimPrefix(line, identityPrefix))
+		if !strings.HasPrefix(line, identityPrefix) || err != nil {
+			return nil, fmt.Errorf("%s is not a dfmgr secret key", path)
+		}
+		key, err := ecdh.X25519().NewPrivateKey(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		return &Identity{key: key}, nil
+	}
+	return nil, fmt.Errorf("%s is empty", path)
+}
+
+// Save writes the key to path with 0600 permissions, refusing to replace
+// an existing key.
+func (i *Identity) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	content := fmt.Sprintf("# dfmgr secret key, keep it out of the dotfiles repository\n# public key: %s\n%s%s\n",
+		i.Recipient(), identityPrefix, b64.EncodeToString(i.key.Bytes()))
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(content); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Recipient returns the public key files are encrypted to.
+func (i *Identity) Recipient() string {
+	return recipientPrefix + b64.EncodeToString(i.key.PublicKey().Bytes())
+}
+
+// Encrypt encrypts plaintext with a random file key that is wrapped for
+// the identity's public key, or for a passphrase when identity is nil.
+func Encrypt(plaintext []byte, identity *Identity, passphrase string) ([]byte, error) {
+	fileKey := make([]byte, 32)
+	if _, err := rand.Read(fileKey); err != nil {
+		return nil, err
+	}
+
+	var stanza string
+	switch {
+	case identity != nil:
+		ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		recipient := identity.key.PublicKey()
+		shared, err := ephemeral.ECDH(recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrapKey, err := x25519WrapKey(shared, ephemeral.PublicKey(), recipient)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := seal(wrapKey, fileKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		stanza = fmt.Sprintf("-> x25519 %s %s", b64.EncodeToString(ephemeral.PublicKey().Bytes()), b64.EncodeToString(wrapped))
+	case passphrase != "":
+		salt := make([]byte, 16)
+		if _, err := rand.Read(salt); err != nil {
+			return nil, err
+		}
+		wrapKey, err := scryptWrapKey(passphrase, salt, scryptLogN)
+		if err != nil {
+			return nil, err
+		}
+		wrapped, err := seal(wrapKey, fileKey, nil)
+		if err != nil {
+			return nil, err
+		}
+		stanza = fmt.Sprintf("-> scrypt %s %d %s", b64.EncodeToString(salt), scryptLogN, b64.EncodeToString(wrapped))
+	default:
+		return nil, fmt.Errorf("a secret key or a passphrase is required")
+	}
+
+	head := header + "\n" + stanza + "\n---\n"
+	nonce := make([]byte, 12)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	body, err := sealNonce(fileKey, nonce, plaintext, []byte(head))
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString(head)
+	encoded := base64.StdEncoding.EncodeToString(append(nonce, body...))
+	for len(encoded) > 64 {
+		out.WriteString(encoded[:64] + "\n")
+		encoded = encoded[64:]
+	}
+	out.WriteString(encoded + "\n")
+	return out.Bytes(), nil
+}
+
+// IsEncrypted reports whether data looks like the output of Encrypt.
+func IsEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(header+"\n"))
+}
+
+// Keyring decrypts files with the local secret key, falling back to a
+// passphrase for files encrypted with one. The passphrase is asked for at
+// most once.
+type Keyring struct {
+	Identity   *Identity
+	Passphrase func() (string, error)
+
+	passphrase string
+}
+
+func (k *Keyring) Decrypt(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, fmt.Errorf("not a dfmgr secret")
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Scan()
+	scanner.Scan()
+	stanza := strings.Fields(scanner.Text())
+	if !scanner.Scan() || scanner.Text() != "---" || len(stanza) < 2 || stanza[0] != "->" {
+		return nil, fmt.Errorf("malformed secret header")
+	}
+	head := header + "\n" + strings.Join(stanza, " ") + "\n---\n"
+
+	var encoded strings.Builder
+	for scanner.Scan() {
+		encoded.WriteString(strings.TrimSpace(scanner.Text()))
+	}
+	body, err := base64.StdEncoding.DecodeString(encoded.String())
+	if err != nil || len(body) < 12 {
+		return nil, fmt.Errorf("malformed secret body")
+	}
+
+	fileKey, err := k.unwrap(stanza[1:])
+	if err != nil {
+		return nil, err
+	}
+
+	plaintext, err := openNonce(fileKey, body[:12], body[12:], []byte(head))
+	if err != nil {
+		return nil, fmt.Errorf("secret was modified or corrupted")
+	}
+	return plaintext, nil
+}
+
+func (k *Keyring) unwrap(stanza []string) ([]byte, error) {
+	switch {
+	case stanza[0] == "x25519" && len(stanza) == 3:
+		if k.Identity == nil {
+			return nil, ErrNoKey
+		}
+		ephemeral, err1 := b64.DecodeString(stanza[1])
+		wrapped, err2 := b64.DecodeString(stanza[2])
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("malformed secret header")
+		}
+		public, err := ecdh.X25519().NewPublicKey(ephemeral)
+		if err != nil {
+			return nil, err
+		}
+		shared, err := k.Identity.key.ECDH(public)
+		if err != nil {
+			return nil, err
+		}
+		wrapKey, err := x25519WrapKey(shared, public, k.Identity.key.PublicKey())
+		if err != nil {
+			return nil, err
+		}
+		fileKey, err := open(wrapKey, wrapped)
+		if err != nil {
+			return nil, ErrWrongKey
+		}
+		return fileKey, nil
+
+	case stanza[0] == "scrypt" && len(stanza) == 4:
+		salt, err1 := b64.DecodeString(stanza[1])
+		logN, err2 := strconv.Atoi(stanza[2])
+		wrapped, err3 := b64.DecodeString(stanza[3])
+		if err1 != nil || err2 != nil || err3 != nil || logN < 1 || logN > 22 {
+			return nil, fmt.Errorf("malformed secret header")
+		}
+		if k.passphrase == "" {
+			if k.Passphrase == nil {
+				return nil, ErrNoKey
+			}
+			passphrase, err := k.Passphrase()
+			if err != nil {
+				return nil, err
+			}
+			k.passphrase = passphrase
+		}
+		wrapKey, err := scryptWrapKey(k.passphrase, salt, logN)
+		if err != nil {
+			return nil, err
+		}
+		fileKey, err := open(wrapKey, wrapped)
+		if err != nil {
+			k.passphrase = ""
+			return nil, ErrWrongKey
+		}
+		return fileKey, nil
+	}
+	return nil, fmt.Errorf("unsupported secret type %q", stanza[0])
+}
+
+// x25519WrapKey derives the key that wraps the file key from the secret
+// shared between an ephemeral key and the recipient.
+func x25519WrapKey(shared []byte, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	salt := append(append([]byte{}, ephemeral.Bytes()...), recipient.Bytes()...)
+	return hkdf.Key(sha256.New, shared, salt, header+" x25519", 32)
+}
+
+func scryptWrapKey(passphrase string, salt []byte, logN int) ([]byte, error) {
+	return scryptKey([]byte(passphrase), append([]byte(header+" scrypt"), salt...), logN, 8, 1, 32)
+}
+
+// seal and open wrap file keys. Every wrapping key is used exactly once,
+// so a zero nonce is safe.
+func seal(key, plaintext, aad []byte) ([]byte, error) {
+	return sealNonce(key, make([]byte, 12), plaintext, aad)
+}
+
+func open(key, ciphertext []byte) ([]byte, error) {
+	return openNonce(key, make([]byte, 12), ciphertext, nil)
+}
+
+func sealNonce(key, nonce, plaintext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+func openNonce(key, nonce, ciphertext, aad []byte) ([]byte, error) {
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
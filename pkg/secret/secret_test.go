@@ -0,0 +1,202 @@
+package secret
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func passphrase(p string) func() (string, error) {
+	return func() (string, error) { return p, nil }
+}
+
+func identity(t *testing.T) *Identity {
+	t.Helper()
+	id, err := GenerateIdentity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// body rewrites the encrypted body of data, nonce included, with edit.
+func body(t *testing.T, data []byte, edit func([]byte)) []byte {
+	t.Helper()
+	head, encoded, ok := strings.Cut(string(data), "---\n")
+	if !ok {
+		t.Fatal("no end of header")
+	}
+	raw, err := base64.StdEncoding.DecodeString(strings.ReplaceAll(encoded, "\n", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	edit(raw)
+	return []byte(head + "---\n" + base64.StdEncoding.EncodeToString(raw) + "\n")
+}
+
+// stanza rewrites field i of the recipient stanza of data with edit.
+func stanza(t *testing.T, data []byte, i int, edit func(string) string) []byte {
+	t.Helper()
+	lines := strings.SplitN(string(data), "\n", 3)
+	fields := strings.Fields(lines[1])
+	fields[i] = edit(fields[i])
+	lines[1] = strings.Join(fields, " ")
+	return []byte(strings.Join(lines, "\n"))
+}
+
+func flipBase64(field string) string {
+	raw, err := b64.DecodeString(field)
+	if err != nil {
+		panic(err)
+	}
+	raw[len(raw)/2] ^= 1
+	return b64.EncodeToString(raw)
+}
+
+func TestRoundTrip(t *testing.T) {
+	plaintext := []byte("machine example.com password hunter2\n")
+	id := identity(t)
+
+	tests := []struct {
+		name     string
+		identity *Identity
+		pass     string
+		keyring  *Keyring
+	}{
+		{"key", id, "", &Keyring{Identity: id}},
+		{"passphrase", nil, "correct horse", &Keyring{Passphrase: passphrase("correct horse")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Encrypt(plaintext, tt.identity, tt.pass)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !IsEncrypted(data) || bytes.Contains(data, plaintext) {
+				t.Fatalf("Encrypt = %q", data)
+			}
+			got, err := tt.keyring.Decrypt(data)
+			if err != nil || !bytes.Equal(got, plaintext) {
+				t.Errorf("Decrypt = %q, %v, want %q", got, err, plaintext)
+			}
+		})
+	}
+}
+
+func TestDecryptWithWrongKey(t *testing.T) {
+	data, err := Encrypt([]byte("secret\n"), identity(t), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Keyring{Identity: identity(t)}).Decrypt(data); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Decrypt with another key = %v, want %v", err, ErrWrongKey)
+	}
+	if _, err := (&Keyring{}).Decrypt(data); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Decrypt without a key = %v, want %v", err, ErrNoKey)
+	}
+
+	data, err = Encrypt([]byte("secret\n"), nil, "right")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := (&Keyring{Passphrase: passphrase("wrong")}).Decrypt(data); !errors.Is(err, ErrWrongKey) {
+		t.Errorf("Decrypt with a wrong passphrase = %v, want %v", err, ErrWrongKey)
+	}
+}
+
+func TestDecryptTampered(t *testing.T) {
+	id := identity(t)
+	data, err := Encrypt([]byte("secret\n"), id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := &Keyring{Identity: id}
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"nonce", body(t, data, func(raw []byte) { raw[0] ^= 1 })},
+		{"ciphertext", body(t, data, func(raw []byte) { raw[12] ^= 1 })},
+		{"tag", body(t, data, func(raw []byte) { raw[len(raw)-1] ^= 1 })},
+		{"ephemeral key", stanza(t, data, 2, flipBase64)},
+		{"wrapped key", stanza(t, data, 3, flipBase64)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got, err := keyring.Decrypt(tt.data); err == nil {
+				t.Errorf("Decrypt = %q, want an error", got)
+			}
+		})
+	}
+}
+
+func TestDecryptTruncated(t *testing.T) {
+	id := identity(t)
+	data, err := Encrypt([]byte("secret\n"), id, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring := &Keyring{Identity: id}
+
+	for n := 0; n < len(data)-1; n++ {
+		if got, err := keyring.Decrypt(data[:n]); err == nil {
+			t.Errorf("Decrypt of %d of %d bytes = %q, want an error", n, len(data), got)
+		}
+	}
+}
+
+func TestDecryptRejectsExpensiveScrypt(t *testing.T) {
+	data, err := Encrypt([]byte("secret\n"), nil, "passphrase")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, logN := range []string{"23", "64", "0", "-1"} {
+		edited := stanza(t, data, 3, func(string) string { return logN })
+		asked := false
+		keyring := &Keyring{Passphrase: func() (string, error) {
+			asked = true
+			return "passphrase", nil
+		}}
+		if _, err := keyring.Decrypt(edited); err == nil {
+			t.Errorf("Decrypt with logN %s succeeded", logN)
+		}
+		if asked {
+			t.Errorf("passphrase asked for with logN %s", logN)
+		}
+	}
+}
+
+// TestScryptVectors checks scryptKey against the test vectors of RFC 7914,
+// section 12.
+func TestScryptVectors(t *testing.T) {
+	tests := []struct {
+		password, salt string
+		logN, r, p     int
+		want           string
+	}{
+		{"", "", 4, 1, 1,
+			"77d6576238657b203b19ca42c18a0497f16b4844e3074ae8dfdffa3fede21442fcd0069ded0948f8326a753a0fc81f17e8d3e0fb2e0d3628cf35e20c38d18906"},
+		{"password", "NaCl", 10, 8, 16,
+			"fdbabe1c9d3472007856e7190d01e9fe7c6ad7cbc8237830e77376634b3731622eaf30d92e22a3886ff109279d9830dac727afb94a83ee6d8360cbdfa2cc0640"},
+		{"pleaseletmein", "SodiumChloride", 14, 8, 1,
+			"7023bdcb3afd7348461c06cd81fd38ebfda8fbba904f8e3ea9b543f6545da1f2d5432955613f0fcf62d49705242a9af9e61e85dc0d651e40dfcf017b45575887"},
+	}
+	for _, tt := range tests {
+		got, err := scryptKey([]byte(tt.password), []byte(tt.salt), tt.logN, tt.r, tt.p, 64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hex.EncodeToString(got) != tt.want {
+			t.Errorf("scrypt(%q, %q, N=%d) = %x, want %s", tt.password, tt.salt, 1<<tt.logN, got, tt.want)
+		}
+	}
+
+	if _, err := scryptKey([]byte("password"), []byte("salt"), 23, 8, 1, 32); err == nil {
+		t.Error("scryptKey accepted logN 23")
+	}
+}
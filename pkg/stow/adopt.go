@@ -0,0 +1,107 @@
+package stow
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Adopt replaces live with a relative symlink to repoPath, which must
+// already hold a copy of it, like stow --adopt. The original is moved aside
+// until the link is in place and moved back if any step fails, so live is
+// never left missing.
+func Adopt(live, repoPath string) error {
+	if err := VerifyCopy(live, repoPath); err != nil {
+		return err
+	}
+
+	aside := filepath.Join(filepath.Dir(live), fmt.Sprintf(".%s.dfmgr-adopt", filepath.Base(live)))
+	if _, err := os.Lstat(aside); err == nil {
+		return fmt.Errorf("%s exists from an earlier interrupted adopt, remove it first", aside)
+	}
+
+	if err := os.Rename(live, aside); err != nil {
+		return err
+	}
+
+	rel, err := filepath.Rel(filepath.Dir(live), repoPath)
+	if err != nil {
+		rel = repoPath
+	}
+
+	if err := os.Symlink(rel, live); err != nil {
+		if restoreErr := os.Rename(aside, live); restoreErr != nil {
+			return fmt.Errorf("%w (restoring the original also failed, it is kept at %s: %v)", err, aside, restoreErr)
+		}
+		return err
+	}
+
+	if resolved, err := filepath.EvalSymlinks(live); err != nil || !sameFile(resolved, repoPath) {
+		os.Remove(live)
+		if restoreErr := os.Rename(aside, live); restoreErr != nil {
+			return fmt.Errorf("new link does not resolve to %s (restoring the original also failed, it is kept at %s: %v)", repoPath, aside, restoreErr)
+		}
+		return fmt.Errorf("new link does not resolve to %s", repoPath)
+	}
+
+	return os.RemoveAll(aside)
+}
+
+// VerifyCopy checks that every file and symlink below live exists in
+// repoPath with the same content, so nothing is lost when live is replaced
+// by a link. Special files cannot be copied, so their presence is an error.
+func VerifyCopy(live, repoPath string) error {
+	return filepath.Walk(live, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(live, path)
+		if err != nil {
+			return err
+		}
+		copyPath := filepath.Join(repoPath, rel)
+
+		switch {
+		case info.IsDir():
+			return nil
+		case info.Mode()&os.ModeSymlink != 0:
+			original, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if copied, err := os.Readlink(copyPath); err != nil || copied != original {
+				return fmt.Errorf("symlink %s was not copied to the repository", path)
+			}
+			return nil
+		case !info.Mode().IsRegular():
+			return fmt.Errorf("%s is a special file that cannot be stored in the repository", path)
+		}
+
+		original, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		copied, err := os.ReadFile(copyPath)
+		if err != nil {
+			return fmt.Errorf("%s was not copied to the repository", path)
+		}
+		if !bytes.Equal(original, copied) {
+			return fmt.Errorf("%s differs from the repository copy", path)
+		}
+		return nil
+	})
+}
+
+func sameFile(a, b string) bool {
+	infoA, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	infoB, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(infoA, infoB)
+}
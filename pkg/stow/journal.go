@@ -0,0 +1,350 @@
+package stow
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/cetincetindag/dfmgr/pkg/backup"
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+const JournalVersion = 1
+
+// ErrInterrupted is returned for the step apply was about to make when it
+// received SIGINT or SIGTERM.
+var ErrInterrupted = errors.New("interrupted")
+
+// Step is one change to the home directory recorded in a Journal.
+type Step struct {
+	Type    ActionType `json:"type"`
+	Package string     `json:"package,omitempty"`
+	Target  string     `json:"target"`
+	Source  string     `json:"source,omitempty"`
+
+	// Snapshot is the directory of the backup snapshot a backup step
+	// copied Target into.
+	Snapshot string `json:"snapshot,omitempty"`
+
+	// Existed records whether a file was already at Target when a render
+	// step started.
+	Existed bool `json:"existed,omitempty"`
+
+	// Rendered records whether a remove step removed a file apply had
+	// rendered, so that it is known as rendered again once restored.
+	Rendered bool `json:"rendered,omitempty"`
+
+	// Done is set once the step completed. The last step of a journal
+	// left behind by a crash may have been made or not.
+	Done bool `json:"done"`
+}
+
+// Journal records every change apply makes to the home directory before
+// making it, so that a failed or interrupted apply can be rolled back,
+// even by the next dfmgr run after a crash. The journal file only exists
+// while apply runs, or when it could not be rolled back.
+type Journal struct {
+	Version int       `json:"version"`
+	Started time.Time `json:"started"`
+	Steps   []Step    `json:"steps"`
+
+	path    string
+	fs      vfs.FS
+	signals chan os.Signal
+}
+
+// JournalFile returns where apply keeps its journal.
+func JournalFile() string {
+	return filepath.Join(config.Home(), ".dfmgr_journal.json")
+}
+
+// StartJournal creates the journal at path on fsys, the real filesystem
+// when nil. It refuses to while the journal of an earlier apply is still
+// there, because starting over would lose what it knows.
+func StartJournal(fsys vfs.FS, path string) (*Journal, error) {
+	fsys = vfs.Or(fsys)
+	if _, err := fsys.Lstat(path); err == nil {
+		return nil, fmt.Errorf("an interrupted apply left a journal at %s, run 'dfmgr recover' first", path)
+	}
+
+	j := &Journal{Version: JournalVersion, Started: time.Now(), Steps: []Step{}, path: path, fs: fsys}
+	return j, j.save()
+}
+
+// LoadJournal reads the journal at path on fsys, the real filesystem when
+// nil. It returns nil when there is none.
+func LoadJournal(fsys vfs.FS, path string) (*Journal, error) {
+	fsys = vfs.Or(fsys)
+	data, err := fsys.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	j := &Journal{path: path, fs: fsys}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// Path returns the file j is kept in.
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Watch catches SIGINT and SIGTERM until Close, so that instead of killing
+// dfmgr halfway they fail the next step with ErrInterrupted.
+func (j *Journal) Watch() {
+	j.signals = make(chan os.Signal, 1)
+	signal.Notify(j.signals, os.Interrupt, syscall.SIGTERM)
+}
+
+// Close stops catching signals. The journal file is left alone.
+func (j *Journal) Close() {
+	if j.signals != nil {
+		signal.Stop(j.signals)
+	}
+}
+
+// Finish removes the journal file once apply succeeded or was rolled back.
+func (j *Journal) Finish() error {
+	j.Close()
+	if err := j.fs.Remove(j.path); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", j.path, err)
+	}
+	return nil
+}
+
+// Interrupted returns an error wrapping ErrInterrupted once SIGINT or
+// SIGTERM arrived since Watch.
+func (j *Journal) Interrupted() error {
+	select {
+	case sig := <-j.signals:
+		return fmt.Errorf("%w by %s", ErrInterrupted, sig)
+	default:
+		return nil
+	}
+}
+
+// begin records step before it is made, unless a signal arrived. Like
+// done, it does nothing without a journal.
+func (j *Journal) begin(step Step) error {
+	if j == nil {
+		return nil
+	}
+	if err := j.Interrupted(); err != nil {
+		return err
+	}
+
+	j.Steps = append(j.Steps, step)
+	return j.save()
+}
+
+// beginAll records steps that a single command, such as stow, makes all
+// at once, before running it.
+func (j *Journal) beginAll(steps []Step) error {
+	if j == nil {
+		return nil
+	}
+	if err := j.Interrupted(); err != nil {
+		return err
+	}
+
+	j.Steps = append(j.Steps, steps...)
+	return j.save()
+}
+
+// doneAll marks the last n steps begun as made.
+func (j *Journal) doneAll(n int) error {
+	if j == nil {
+		return nil
+	}
+	for i := len(j.Steps) - n; i < len(j.Steps); i++ {
+		j.Steps[i].Done = true
+	}
+	return j.save()
+}
+
+// done marks the step begun last as made, with snapshot as the backup
+// snapshot it used, if any.
+func (j *Journal) done(snapshot string) error {
+	if j == nil {
+		return nil
+	}
+	step := &j.Steps[len(j.Steps)-1]
+	step.Done = true
+	step.Snapshot = snapshot
+	return j.save()
+}
+
+func (j *Journal) save() error {
+	data, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	tmp := j.path + ".tmp"
+	if err := j.fs.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	if err := j.fs.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("failed to write journal: %w", err)
+	}
+	return nil
+}
+
+// Rollback undoes the steps of j, newest first, and removes the journal
+// when all of them could be undone. Removed files come back from their
+// backup snapshot. Files apply rendered over an earlier rendering are left
+// as they are; they hold nothing apply cannot render again.
+func (l *Linker) Rollback(j *Journal) error {
+	fsys := l.filesystem()
+	failed := 0
+	rendered := false
+
+	for i := len(j.Steps) - 1; i >= 0; i-- {
+		step := j.Steps[i]
+		err := l.undo(fsys, j, i)
+		if err != nil {
+			utils.Warning("Failed to undo %s of %s: %s", step.Type, step.Target, err)
+			failed++
+			continue
+		}
+		rendered = rendered || step.Type == ActionRender || step.Type == ActionDecrypt || step.Type == ActionMkdir || step.Type == ActionRmdir || step.Rendered
+	}
+
+	if rendered {
+		if err := l.renderState().Save(); err != nil {
+			utils.Warning("Failed to record rendered templates: %s", err)
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to undo %d change(s), the journal is kept in %s until 'dfmgr recover --discard'", failed, j.path)
+	}
+	return j.Finish()
+}
+
+// undo reverts the step at index i of j. Steps may not have been made, so
+// each one checks what is in place first.
+func (l *Linker) undo(fsys vfs.FS, j *Journal, i int) error {
+	step := j.Steps[i]
+	info, err := fsys.Lstat(step.Target)
+	exists := err == nil
+
+	switch step.Type {
+	case ActionMkdir:
+		// Directories that hold anything apply did not put there stay.
+		if entries, err := fsys.ReadDir(step.Target); err == nil && len(entries) == 0 {
+			if err := fsys.Remove(step.Target); err != nil {
+				return err
+			}
+			delete(l.renderState().Dirs, step.Target)
+			utils.Record(string(ActionRmdir), step.Target, "")
+		}
+	case ActionLink:
+		if exists && info.Mode()&os.ModeSymlink != 0 && resolveLink(fsys, step.Target) == step.Source {
+			if err := fsys.Remove(step.Target); err != nil {
+				return err
+			}
+			utils.Record(string(ActionUnlink), step.Target, step.Source)
+		}
+	case ActionUnlink:
+		if !exists {
+			rel, err := filepath.Rel(filepath.Dir(step.Target), step.Source)
+			if err != nil {
+				rel = step.Source
+			}
+			if err := fsys.Symlink(rel, step.Target); err != nil {
+				return err
+			}
+			utils.Record(string(ActionLink), step.Target, step.Source)
+		}
+	case ActionRmdir:
+		if !exists {
+			if err := fsys.Mkdir(step.Target, 0755); err != nil {
+				return err
+			}
+			l.renderState().Dirs[step.Target] = true
+			utils.Record(string(ActionMkdir), step.Target, "")
+		}
+	case ActionRender, ActionDecrypt:
+		if exists && !step.Existed && info.Mode().IsRegular() {
+			if err := fsys.Remove(step.Target); err != nil {
+				return err
+			}
+			delete(l.renderState().Files, step.Target)
+			utils.Record(string(ActionRemove), step.Target, "")
+		}
+	case ActionRemove:
+		if exists && !l.isManaged(fsys, step.Target) {
+			// Never made, the original is still there.
+			return nil
+		}
+		return l.restore(fsys, j, i)
+	}
+	return nil
+}
+
+// restore puts back the file removed by the step at index i of j from the
+// snapshot the backup step before it copied the file into.
+func (l *Linker) restore(fsys vfs.FS, j *Journal, i int) error {
+	target := j.Steps[i].Target
+	dir := ""
+	for k := i - 1; k >= 0 && dir == ""; k-- {
+		if j.Steps[k].Type == ActionBackup && j.Steps[k].Target == target && j.Steps[k].Done {
+			dir = j.Steps[k].Snapshot
+		}
+	}
+	if dir == "" {
+		return fmt.Errorf("no backup to restore it from, apply again to put it back")
+	}
+
+	snapshot := l.snapshot
+	if snapshot == nil || snapshot.Dir != dir {
+		var err error
+		if snapshot, err = backup.OpenDir(fsys, dir); err != nil {
+			return err
+		}
+	}
+
+	rel, err := filepath.Rel(snapshot.Manifest.Root, target)
+	if err != nil {
+		return err
+	}
+	for _, entry := range snapshot.Match([]string{rel}) {
+		if entry.Path != rel {
+			continue
+		}
+		if err := snapshot.Restore(entry, func(link string) bool { return l.isManaged(fsys, link) }); err != nil {
+			return err
+		}
+		if j.Steps[i].Rendered {
+			if content, err := fsys.ReadFile(target); err == nil {
+				l.renderState().Record(target, content)
+			}
+		}
+		utils.Record("restore", target, snapshot.Manifest.ID)
+		return nil
+	}
+	return fmt.Errorf("not found in backup snapshot %s", snapshot.Manifest.ID)
+}
+
+// isManaged reports whether path is a symlink into the source directory.
+func (l *Linker) isManaged(fsys vfs.FS, path string) bool {
+	info, err := fsys.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	source, err := filepath.Abs(l.SourceDir)
+	return err == nil && IsWithin(source, resolveLink(fsys, path))
+}
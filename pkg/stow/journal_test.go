@@ -0,0 +1,175 @@
+package stow
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+// journaled returns the plan for packages and a journal l records in.
+func journaled(t *testing.T, l *Linker, packages ...string) *Plan {
+	t.Helper()
+	l.Replace = true
+	journal, err := StartJournal(l.FS, JournalFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Journal = journal
+
+	plan, err := l.Plan(packages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func assertFile(t *testing.T, m *vfs.Memory, path, want string) {
+	t.Helper()
+	info, err := m.Lstat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		t.Fatalf("%s is not a file: %v", path, err)
+	}
+	if data, _ := m.ReadFile(path); string(data) != want {
+		t.Errorf("%s = %q, want %q", path, data, want)
+	}
+}
+
+func assertMissing(t *testing.T, m *vfs.Memory, path string) {
+	t.Helper()
+	if _, err := m.Lstat(path); !os.IsNotExist(err) {
+		t.Errorf("%s was left behind: %v", path, err)
+	}
+}
+
+func TestRollbackAfterFailure(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/bash/.bashrc": "export EDITOR=nvim\n",
+		"/dotfiles/zsh/.zshrc":   "bindkey -v\n",
+		"/home/user/.bashrc":     "original\n",
+	})
+	plan := journaled(t, l, "bash", "zsh")
+
+	// Something takes the place of .zshrc after planning, so linking it
+	// fails once .bashrc has been replaced.
+	m.WriteFile("/home/user/.zshrc", []byte("appeared\n"), 0644)
+	if err := l.Execute(plan); err == nil {
+		t.Fatal("Execute succeeded with .zshrc in the way")
+	}
+
+	if err := l.Rollback(l.Journal); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, m, "/home/user/.bashrc", "original\n")
+	assertFile(t, m, "/home/user/.zshrc", "appeared\n")
+	assertMissing(t, m, JournalFile())
+}
+
+func TestRecoverAfterCrash(t *testing.T) {
+	files := map[string]string{
+		"/dotfiles/bash/.bashrc":                 "export EDITOR=nvim\n",
+		"/dotfiles/git/.config/git/config.tmpl":  "[user]\n\temail = {{ .Vars.email }}\n",
+		"/home/user/.bashrc":                     "original\n",
+		"/home/user/.config/other/settings.json": "{}\n",
+	}
+	l, m := memoryLinker(t, files)
+	if err := l.Execute(journaled(t, l, "bash", "git")); err != nil {
+		t.Fatal(err)
+	}
+
+	// dfmgr dies before finishing the journal; the next run finds it.
+	if _, err := StartJournal(m, JournalFile()); err == nil {
+		t.Fatal("StartJournal ignored the journal left behind")
+	}
+	journal, err := LoadJournal(m, JournalFile())
+	if err != nil || journal == nil {
+		t.Fatalf("LoadJournal = %v, %v", journal, err)
+	}
+
+	recovering := *l
+	recovering.Journal, recovering.snapshot, recovering.Rendered = nil, nil, nil
+	if err := recovering.Rollback(journal); err != nil {
+		t.Fatal(err)
+	}
+
+	assertFile(t, m, "/home/user/.bashrc", "original\n")
+	assertFile(t, m, "/home/user/.config/other/settings.json", "{}\n")
+	assertMissing(t, m, "/home/user/.config/git")
+	assertMissing(t, m, JournalFile())
+}
+
+func TestInterruptStopsBeforeNextStep(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/bash/.bashrc": "export EDITOR=nvim\n",
+	})
+	plan := journaled(t, l, "bash")
+
+	l.Journal.signals = make(chan os.Signal, 1)
+	l.Journal.signals <- syscall.SIGINT
+	if err := l.Execute(plan); !errors.Is(err, ErrInterrupted) {
+		t.Fatalf("Execute = %v, want ErrInterrupted", err)
+	}
+	assertMissing(t, m, "/home/user/.bashrc")
+	if len(l.Journal.Steps) != 0 {
+		t.Errorf("journal recorded %+v", l.Journal.Steps)
+	}
+}
+
+func TestRollbackRestoresRenderedFile(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/git/.gitconfig.tmpl": "[user]\n\temail = {{ .Vars.email }}\n",
+		"/dotfiles/zsh/.zshrc":          "bindkey -v\n",
+	})
+	apply(t, l, "git")
+
+	// The template became a plain file, so apply replaces the rendered
+	// file with a link, then fails on .zshrc.
+	m.Remove("/dotfiles/git/.gitconfig.tmpl")
+	m.WriteFile("/dotfiles/git/.gitconfig", []byte("[user]\n"), 0644)
+	plan := journaled(t, l, "git", "zsh")
+	m.WriteFile("/home/user/.zshrc", []byte("appeared\n"), 0644)
+	if err := l.Execute(plan); err == nil {
+		t.Fatal("Execute succeeded with .zshrc in the way")
+	}
+
+	if err := l.Rollback(l.Journal); err != nil {
+		t.Fatal(err)
+	}
+	assertFile(t, m, "/home/user/.gitconfig", "[user]\n\temail = me@example.com\n")
+	if l.renderState().Files["/home/user/.gitconfig"] == "" {
+		t.Error("restored .gitconfig is no longer known as rendered")
+	}
+}
+
+func TestRollbackUnstows(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/bash/.bashrc":               "export EDITOR=nvim\n",
+		"/dotfiles/nvim/.config/nvim/init.lua": "vim.o.number = true\n",
+	})
+	apply(t, l, "bash")
+	plan := journaled(t, l, "bash", "nvim")
+
+	// Make the links the way stow would, then fail.
+	steps := stowSteps(plan, []string{"bash", "nvim"})
+	if err := l.Journal.beginAll(steps); err != nil {
+		t.Fatal(err)
+	}
+	for _, step := range steps {
+		if step.Type == ActionLink {
+			m.Symlink(step.Source, step.Target)
+		}
+	}
+	if err := l.Journal.doneAll(len(steps)); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := l.Rollback(l.Journal); err != nil {
+		t.Fatal(err)
+	}
+	assertMissing(t, m, "/home/user/.config")
+	if !l.isManaged(m, "/home/user/.bashrc") {
+		t.Error("rollback removed the link of an earlier apply")
+	}
+}
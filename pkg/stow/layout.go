@@ -0,0 +1,180 @@
+package stow
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+// Migration moves an entry of a package synced with the old flat layout
+// to the home-relative path it should have had.
+type Migration struct {
+	Package string `json:"package"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+}
+
+// FindFlatLayout looks for entries that older versions of sync dropped into
+// packages by basename, e.g. Editor/nvim instead of Editor/.config/nvim, and
+// which would therefore be linked to ~/nvim. Only entries whose real home
+// location can be determined unambiguously are returned, and none holding
+// a dot path such as bin/.local/bin, which were laid out relative to home
+// on purpose.
+func FindFlatLayout(sourceDir, home string, packages []string) []Migration {
+	var migrations []Migration
+
+	for _, pkg := range packages {
+		pkgDir := filepath.Join(sourceDir, pkg)
+		entries, err := os.ReadDir(pkgDir)
+		if err != nil {
+			continue
+		}
+
+		for _, e := range entries {
+			name := e.Name()
+			if strings.HasPrefix(name, ".") || holdsDotPath(filepath.Join(pkgDir, name)) {
+				continue
+			}
+
+			rel, ok := config.FindByBasename(name)
+			if !ok {
+				candidate := filepath.Join(".config", name)
+				if _, err := os.Lstat(filepath.Join(home, candidate)); err != nil {
+					continue
+				}
+				rel = candidate
+			}
+
+			migrations = append(migrations, Migration{
+				Package: pkg,
+				From:    filepath.Join(pkgDir, name),
+				To:      filepath.Join(pkgDir, rel),
+			})
+		}
+	}
+
+	return migrations
+}
+
+// holdsDotPath reports whether anything below dir has a name starting with
+// a dot.
+func holdsDotPath(dir string) bool {
+	found := false
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return filepath.SkipAll
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			found = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return found
+}
+
+// Migrate moves the entry into place and removes the stale link the old
+// layout left behind in home.
+func (m Migration) Migrate(home string) error {
+	if _, err := os.Lstat(m.To); err == nil {
+		return &os.PathError{Op: "migrate", Path: m.To, Err: os.ErrExist}
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.To), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(m.From, m.To); err != nil {
+		return err
+	}
+
+	stale := filepath.Join(home, filepath.Base(m.From))
+	if info, err := os.Lstat(stale); err == nil && info.Mode()&os.ModeSymlink != 0 && resolveLink(vfs.OS{}, stale) == m.From {
+		return os.Remove(stale)
+	}
+	return nil
+}
+
+// FindTracked returns the packages other than pkg that already contain
+// relPath, which would make two packages compete for the same target.
+func FindTracked(sourceDir, relPath, pkg string, packages []string) []string {
+	var result []string
+	for _, other := range packages {
+		if other == pkg {
+			continue
+		}
+		if _, err := os.Lstat(filepath.Join(sourceDir, other, relPath)); err == nil {
+			result = append(result, other)
+		}
+	}
+	return result
+}
+
+// PackageName derives a package name from the application a home-relative
+// path belongs to: ".config/nvim/init.lua" is "nvim" and ".bashrc" is
+// "bashrc".
+func PackageName(relPath string) string {
+	parts := strings.Split(filepath.ToSlash(relPath), "/")
+	name := parts[0]
+	if (name == ".config" || name == ".local") && len(parts) > 1 {
+		name = parts[1]
+		if name == "share" && len(parts) > 2 {
+			name = parts[2]
+		}
+	}
+
+	name = strings.TrimPrefix(name, ".")
+	if ext := filepath.Ext(name); ext != "" && ext != name {
+		name = strings.TrimSuffix(name, ext)
+	}
+	if name == "" {
+		return "misc"
+	}
+	return name
+}
+
+// CreateParents creates the directories leading to relPath inside pkgDir
+// with the same permissions as their counterparts in home, so a private
+// directory such as ~/.ssh stays private in the repository. Their modes
+// are recorded in metadata unless it is nil.
+func CreateParents(pkgDir, home, relPath string, metadata *Metadata) error {
+	if err := utils.EnsureDirExists(pkgDir); err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(relPath)
+	if dir == "." {
+		return nil
+	}
+
+	current := pkgDir
+	for _, part := range strings.Split(dir, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		home = filepath.Join(home, part)
+
+		if _, err := os.Lstat(current); err == nil {
+			continue
+		}
+
+		mode := os.FileMode(0755)
+		if info, err := os.Stat(home); err == nil {
+			mode = info.Mode()
+		}
+		if err := os.Mkdir(current, 0755); err != nil {
+			return err
+		}
+		if err := os.Chmod(current, mode.Perm()); err != nil {
+			return err
+		}
+		if metadata != nil {
+			if err := metadata.RecordMode(config.CurrentConfig.LocalPath, current, mode); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
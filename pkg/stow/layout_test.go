@@ -0,0 +1,31 @@
+package stow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFindFlatLayoutSkipsHomeRelativeEntries(t *testing.T) {
+	source, home := t.TempDir(), t.TempDir()
+	for _, path := range []string{
+		filepath.Join(source, "Editor", "nvim", "init.lua"),
+		filepath.Join(source, "Tools", "bin", ".local", "bin", "script"),
+		filepath.Join(home, ".config", "nvim", "init.lua"),
+	} {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	migrations := FindFlatLayout(source, home, []string{"Editor", "Tools"})
+	if len(migrations) != 1 || migrations[0].Package != "Editor" {
+		t.Fatalf("migrations = %+v, want Editor/nvim only", migrations)
+	}
+	if want := filepath.Join(source, "Editor", ".config", "nvim"); migrations[0].To != want {
+		t.Errorf("nvim migrates to %s, want %s", migrations[0].To, want)
+	}
+}
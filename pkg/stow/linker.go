@@ -0,0 +1,808 @@
+package stow
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/cetincetindag/dfmgr/pkg/backup"
+	"github.com/cetincetindag/dfmgr/pkg/secret"
+	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+const (
+	BackendNative = "native"
+	BackendStow   = "stow"
+)
+
+type ActionType string
+
+const (
+	ActionMkdir   ActionType = "mkdir"
+	ActionLink    ActionType = "link"
+	ActionUnlink  ActionType = "unlink"
+	ActionBackup  ActionType = "backup"
+	ActionRemove  ActionType = "remove"
+	ActionRmdir   ActionType = "rmdir"
+	ActionRender  ActionType = "render"
+	ActionDecrypt ActionType = "decrypt"
+)
+
+// Action is a single filesystem change planned by the Linker.
+type Action struct {
+	Type    ActionType `json:"type"`
+	Package string     `json:"package"`
+	Target  string     `json:"target"`
+	Source  string     `json:"source,omitempty"`
+}
+
+// Conflict describes a target path the Linker refuses to touch.
+type Conflict struct {
+	Package string `json:"package"`
+	Target  string `json:"target"`
+	Source  string `json:"source"`
+	Reason  string `json:"reason"`
+}
+
+type Plan struct {
+	Actions   []Action   `json:"actions"`
+	Linked    []Action   `json:"linked"`
+	Conflicts []Conflict `json:"conflicts"`
+
+	// Skipped lists secrets this machine has no key for. Unlike conflicts
+	// they do not stop the rest of the plan.
+	Skipped []Conflict `json:"skipped"`
+
+	// Overrides lists files a package of a higher layer provides in place
+	// of a package of a lower one.
+	Overrides []Conflict `json:"overrides"`
+}
+
+// Linker is a pure Go replacement for GNU stow. Every package below
+// SourceDir is mirrored into TargetDir with relative symlinks. Directories
+// that do not exist in the target are folded into a single link unless
+// NoFolding is set, and folded links owned by SourceDir are unfolded again
+// when a second package needs to share the directory.
+//
+// With Replace set, files and foreign symlinks in the way are backed up into
+// a new snapshot below BackupDir and removed instead of being reported as
+// conflicts. Rendered files edited by hand since apply wrote them remain
+// conflicts unless ReplaceEdited is set too. Targets overrides TargetDir
+// for individual packages.
+//
+// Packages below one of Layers, listed from the lowest precedence to the
+// highest, are linked layer by layer, and a file provided by a higher layer
+// replaces the same file of a lower one. Two packages of the same layer
+// providing the same file are a conflict.
+//
+// Templates, files ending in TemplateSuffix or matching one of Templates,
+// are rendered with TemplateData into real files instead of being linked.
+// Secrets, files ending in secret.Suffix, are decrypted with Secrets.
+//
+// With a Journal, every change Execute makes is recorded before it is made,
+// so that Rollback can undo them.
+type Linker struct {
+	SourceDir string
+	TargetDir string
+	Targets   map[string]string
+	BackupDir string
+	NoFolding bool
+	Replace   bool
+	Ignore    []string
+	Layers    []string
+
+	// ReplaceEdited lets Replace overwrite rendered files edited by hand.
+	ReplaceEdited bool
+
+	// PackageIgnore adds ignore patterns for individual packages.
+	PackageIgnore map[string][]string
+
+	Templates    []string
+	TemplateData *TemplateData
+	Rendered     *RenderState
+	Secrets      *secret.Keyring
+
+	// FS is the filesystem packages are read from and linked into, the
+	// real one when nil.
+	FS vfs.FS
+
+	Journal *Journal
+
+	snapshot *backup.Snapshot
+}
+
+var defaultIgnore = []string{".git", ".DS_Store"}
+
+type entryKind int
+
+const (
+	entryNone entryKind = iota
+	entryFile
+	entryDir
+	entryLink
+)
+
+type entry struct {
+	kind   entryKind
+	source string
+	fresh  bool
+
+	// pkg is the package that provides an entry planned or found in place
+	// by this plan.
+	pkg string
+}
+
+type planner struct {
+	linker   *Linker
+	fs       vfs.FS
+	source   string
+	targets  map[string]string
+	target   string
+	plan     *Plan
+	virtual  map[string]entry
+	packages []string
+}
+
+func (l *Linker) newPlanner() (*planner, error) {
+	source, err := filepath.Abs(l.SourceDir)
+	if err != nil {
+		return nil, err
+	}
+	target, err := filepath.Abs(l.TargetDir)
+	if err != nil {
+		return nil, err
+	}
+	targets := make(map[string]string)
+	for pkg, dir := range l.Targets {
+		if targets[pkg], err = filepath.Abs(dir); err != nil {
+			return nil, err
+		}
+	}
+	return &planner{
+		linker:  l,
+		fs:      l.filesystem(),
+		source:  source,
+		targets: targets,
+		target:  target,
+		plan:    &Plan{Actions: []Action{}, Linked: []Action{}, Conflicts: []Conflict{}, Skipped: []Conflict{}, Overrides: []Conflict{}},
+		virtual: make(map[string]entry),
+	}, nil
+}
+
+// Plan computes the actions needed to link packages into the target
+// directory without modifying anything.
+func (l *Linker) Plan(packages []string) (*Plan, error) {
+	p, err := l.newPlanner()
+	if err != nil {
+		return nil, err
+	}
+
+	packages = append([]string{}, packages...)
+	sort.SliceStable(packages, func(i, j int) bool {
+		return l.LayerOf(packages[i]) < l.LayerOf(packages[j])
+	})
+	p.packages = packages
+
+	for _, pkg := range packages {
+		pkgDir := filepath.Join(p.source, pkg)
+		info, err := l.filesystem().Stat(pkgDir)
+		if err != nil {
+			return nil, fmt.Errorf("package %s: %w", pkg, err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("package %s is not a directory", pkg)
+		}
+		target := p.targetFor(pkg)
+		if !p.targetDir(pkg, target) {
+			continue
+		}
+		if err := p.linkDir(pkg, pkgDir, target); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.plan, nil
+}
+
+// PlanUnlink computes the actions needed to remove the links a previous
+// Plan created for packages. Links that point anywhere else are left alone,
+// and directories that only held the removed links are removed as well.
+func (l *Linker) PlanUnlink(packages []string) (*Plan, error) {
+	p, err := l.newPlanner()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, pkg := range packages {
+		pkgDir := filepath.Join(p.source, pkg)
+		if _, err := l.filesystem().Stat(pkgDir); err != nil {
+			return nil, fmt.Errorf("package %s: %w", pkg, err)
+		}
+		if err := p.unlinkDir(pkg, pkgDir, p.targetFor(pkg)); err != nil {
+			return nil, err
+		}
+	}
+
+	return p.plan, nil
+}
+
+// Err describes the conflicts of the plan, if any.
+func (plan *Plan) Err() error {
+	if len(plan.Conflicts) == 0 {
+		return nil
+	}
+	var lines []string
+	for _, c := range plan.Conflicts {
+		lines = append(lines, fmt.Sprintf("%s: %s", c.Target, c.Reason))
+	}
+	return fmt.Errorf("%d conflict(s) found:\n  %s", len(plan.Conflicts), strings.Join(lines, "\n  "))
+}
+
+// Execute applies a plan. Plans with conflicts are rejected as a whole, the
+// same way stow aborts before changing anything.
+func (l *Linker) Execute(plan *Plan) error {
+	if err := plan.Err(); err != nil {
+		return err
+	}
+
+	l.snapshot = nil
+	rendered := false
+	for _, action := range plan.Actions {
+		switch action.Type {
+		case ActionRender, ActionDecrypt, ActionMkdir, ActionRmdir:
+			rendered = true
+		case ActionRemove:
+			rendered = rendered || l.renderState().Files[action.Target] != ""
+		}
+		if err := l.journaled(action); err != nil {
+			if rendered {
+				l.renderState().Save()
+			}
+			return err
+		}
+		utils.Record(string(action.Type), action.Target, action.Source)
+	}
+
+	if rendered {
+		if err := l.renderState().Save(); err != nil {
+			return fmt.Errorf("failed to record rendered templates: %w", err)
+		}
+	}
+	return nil
+}
+
+// Snapshot returns the backup snapshot created by the last Execute, if any
+// files had to be backed up.
+func (l *Linker) Snapshot() *backup.Snapshot {
+	return l.snapshot
+}
+
+// journaled executes action, recording it in the journal first when there
+// is one.
+func (l *Linker) journaled(action Action) error {
+	if l.Journal == nil {
+		return l.executeAction(action)
+	}
+
+	step := Step{Type: action.Type, Package: action.Package, Target: action.Target, Source: action.Source}
+	if action.Type == ActionRender || action.Type == ActionDecrypt {
+		_, err := l.filesystem().Lstat(action.Target)
+		step.Existed = err == nil
+	}
+	if action.Type == ActionRemove {
+		step.Rendered = l.renderState().Files[action.Target] != ""
+	}
+	if err := l.Journal.begin(step); err != nil {
+		return err
+	}
+	if err := l.executeAction(action); err != nil {
+		return err
+	}
+
+	snapshot := ""
+	if action.Type == ActionBackup {
+		snapshot = l.snapshot.Dir
+	}
+	return l.Journal.done(snapshot)
+}
+
+func (l *Linker) executeAction(action Action) error {
+	fsys := l.filesystem()
+	switch action.Type {
+	case ActionBackup:
+		if l.snapshot == nil {
+			snapshot, err := backup.NewFS(fsys, l.BackupDir, l.TargetDir)
+			if err != nil {
+				return fmt.Errorf("failed to create backup snapshot: %w", err)
+			}
+			l.snapshot = snapshot
+		}
+		backupPath, err := l.snapshot.Add(action.Package, action.Target)
+		if err != nil {
+			return fmt.Errorf("failed to back up %s: %w", action.Target, err)
+		}
+		utils.Info("Backed up %s to: %s", action.Target, backupPath)
+	case ActionRemove:
+		info, err := fsys.Lstat(action.Target)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return fmt.Errorf("refusing to remove directory %s", action.Target)
+		}
+		if err := fsys.Remove(action.Target); err != nil {
+			return fmt.Errorf("failed to remove %s: %w", action.Target, err)
+		}
+		delete(l.renderState().Files, action.Target)
+	case ActionRender, ActionDecrypt:
+		return l.writeRendered(action.Source, action.Target)
+	case ActionMkdir:
+		err := fsys.Mkdir(action.Target, 0755)
+		if err != nil && !os.IsExist(err) {
+			return fmt.Errorf("failed to create directory %s: %w", action.Target, err)
+		}
+		if err == nil {
+			l.renderState().Dirs[action.Target] = true
+		}
+	case ActionLink:
+		rel, err := filepath.Rel(filepath.Dir(action.Target), action.Source)
+		if err != nil {
+			rel = action.Source
+		}
+		if err := fsys.Symlink(rel, action.Target); err != nil {
+			return fmt.Errorf("failed to link %s: %w", action.Target, err)
+		}
+	case ActionRmdir:
+		if err := fsys.Remove(action.Target); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove directory %s: %w", action.Target, err)
+		}
+		delete(l.renderState().Dirs, action.Target)
+	case ActionUnlink:
+		info, err := fsys.Lstat(action.Target)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode()&os.ModeSymlink == 0 {
+			return fmt.Errorf("refusing to remove %s: not a symlink", action.Target)
+		}
+		if err := fsys.Remove(action.Target); err != nil {
+			return fmt.Errorf("failed to unlink %s: %w", action.Target, err)
+		}
+	default:
+		return fmt.Errorf("unknown action %q", action.Type)
+	}
+	return nil
+}
+
+func (p *planner) targetFor(pkg string) string {
+	if target, ok := p.targets[pkg]; ok {
+		return target
+	}
+	return p.target
+}
+
+func (l *Linker) filesystem() vfs.FS {
+	return vfs.Or(l.FS)
+}
+
+// LayerOf returns the index in Layers of the layer pkg belongs to, or -1
+// for packages outside of every layer.
+func (l *Linker) LayerOf(pkg string) int {
+	for i := len(l.Layers) - 1; i >= 0; i-- {
+		if IsWithin(l.Layers[i], pkg) {
+			return i
+		}
+	}
+	return -1
+}
+
+// TargetFor returns the directory pkg is linked into.
+func (l *Linker) TargetFor(pkg string) string {
+	if target, ok := l.Targets[pkg]; ok {
+		return target
+	}
+	return l.TargetDir
+}
+
+func (p *planner) ignored(pkg, name string) bool {
+	return isIgnored(name, p.linker.Ignore, p.linker.PackageIgnore[pkg])
+}
+
+func isIgnored(name string, extra ...[]string) bool {
+	for _, patterns := range append([][]string{defaultIgnore}, extra...) {
+		for _, pattern := range patterns {
+			if matched, _ := filepath.Match(pattern, name); matched {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (p *planner) lookup(path string) entry {
+	if e, ok := p.virtual[path]; ok {
+		return e
+	}
+
+	// Anything below a directory created or replaced by this plan does not
+	// exist yet, whatever the real filesystem shows through an old link.
+	for dir := filepath.Dir(path); dir != filepath.Dir(dir); dir = filepath.Dir(dir) {
+		if e, ok := p.virtual[dir]; ok {
+			if e.kind == entryDir && !e.fresh {
+				break
+			}
+			return entry{kind: entryNone}
+		}
+	}
+
+	info, err := p.fs.Lstat(path)
+	if err != nil {
+		return entry{kind: entryNone}
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		return entry{kind: entryLink, source: resolveLink(p.fs, path)}
+	case info.IsDir():
+		return entry{kind: entryDir}
+	default:
+		return entry{kind: entryFile}
+	}
+}
+
+func (p *planner) add(action Action) {
+	p.plan.Actions = append(p.plan.Actions, action)
+	switch action.Type {
+	case ActionMkdir:
+		p.virtual[action.Target] = entry{kind: entryDir, fresh: true, pkg: action.Package}
+	case ActionLink:
+		p.virtual[action.Target] = entry{kind: entryLink, source: action.Source, pkg: action.Package}
+	case ActionRender, ActionDecrypt:
+		p.virtual[action.Target] = entry{kind: entryFile, pkg: action.Package}
+	case ActionUnlink, ActionRemove, ActionRmdir:
+		p.virtual[action.Target] = entry{kind: entryNone}
+		linked := p.plan.Linked[:0]
+		for _, l := range p.plan.Linked {
+			if l.Target != action.Target {
+				linked = append(linked, l)
+			}
+		}
+		p.plan.Linked = linked
+	}
+}
+
+// inPlace records that action is already done, so that later packages
+// know which package provides its target.
+func (p *planner) inPlace(action Action) {
+	p.plan.Linked = append(p.plan.Linked, action)
+	e := entry{kind: entryFile, pkg: action.Package}
+	if action.Type == ActionLink {
+		e = entry{kind: entryLink, source: action.Source, pkg: action.Package}
+	}
+	p.virtual[action.Target] = e
+}
+
+// claim settles which package provides dst when another package of this
+// plan already does. A package of a higher layer takes dst over and claim
+// returns what is left in its place; otherwise a conflict is recorded and
+// claim reports false.
+func (p *planner) claim(pkg, src, dst string, existing entry, srcIsDir bool) (entry, bool) {
+	if existing.pkg == "" || existing.pkg == pkg || existing.kind == entryDir {
+		return existing, true
+	}
+	if srcIsDir && existing.kind == entryLink && isDir(p.fs, existing.source) {
+		// Both are directories, which linkEntry merges.
+		return existing, true
+	}
+
+	if p.linker.LayerOf(existing.pkg) >= p.linker.LayerOf(pkg) {
+		p.conflict(pkg, dst, src, fmt.Sprintf("also provided by package %s", existing.pkg))
+		return existing, false
+	}
+
+	planned := false
+	actions := p.plan.Actions[:0]
+	for _, action := range p.plan.Actions {
+		if action.Target == dst && (action.Type == ActionLink || action.Type == ActionRender || action.Type == ActionDecrypt) {
+			planned = true
+			continue
+		}
+		actions = append(actions, action)
+	}
+	p.plan.Actions = actions
+
+	linked := p.plan.Linked[:0]
+	for _, action := range p.plan.Linked {
+		if action.Target != dst {
+			linked = append(linked, action)
+		}
+	}
+	p.plan.Linked = linked
+
+	p.plan.Overrides = append(p.plan.Overrides, Conflict{
+		Package: pkg,
+		Target:  dst,
+		Source:  src,
+		Reason:  fmt.Sprintf("overrides package %s", existing.pkg),
+	})
+
+	// Whatever the lower layer planned is dropped; what it found in place
+	// is dealt with like any other file in the way.
+	if planned {
+		p.virtual[dst] = entry{kind: entryNone}
+	} else {
+		delete(p.virtual, dst)
+	}
+	return p.lookup(dst), true
+}
+
+// providedAbove reports whether source is a file of a package of this plan
+// in a higher layer than pkg, which will keep its link in place.
+func (p *planner) providedAbove(pkg, source string) bool {
+	owner := p.packageOf(source, pkg)
+	if owner == pkg || p.linker.LayerOf(owner) <= p.linker.LayerOf(pkg) {
+		return false
+	}
+	_, err := p.fs.Lstat(source)
+	return err == nil
+}
+
+// packageOf returns the package of this plan that path belongs to, or pkg
+// if there is none.
+func (p *planner) packageOf(path, pkg string) string {
+	best := ""
+	for _, candidate := range p.packages {
+		if IsWithin(filepath.Join(p.source, candidate), path) && len(candidate) > len(best) {
+			best = candidate
+		}
+	}
+	if best == "" {
+		return pkg
+	}
+	return best
+}
+
+func (p *planner) conflict(pkg, target, source, reason string) {
+	p.plan.Conflicts = append(p.plan.Conflicts, Conflict{
+		Package: pkg,
+		Target:  target,
+		Source:  source,
+		Reason:  reason,
+	})
+}
+
+func (p *planner) owns(path string) bool {
+	return IsWithin(p.source, path)
+}
+
+// targetDir plans the creation of a package target below the home directory
+// that does not exist yet. It reports false, after recording a conflict, when
+// something other than a directory is in the way.
+func (p *planner) targetDir(pkg, dir string) bool {
+	var missing []string
+walk:
+	for ; dir != p.target && IsWithin(p.target, dir); dir = filepath.Dir(dir) {
+		switch e := p.lookup(dir); {
+		case e.kind == entryNone:
+			missing = append(missing, dir)
+		case e.kind == entryDir, e.kind == entryLink && isDir(p.fs, dir):
+			break walk
+		default:
+			p.conflict(pkg, dir, "", "package target is not a directory")
+			return false
+		}
+	}
+
+	for i := len(missing) - 1; i >= 0; i-- {
+		p.add(Action{Type: ActionMkdir, Package: pkg, Target: missing[i]})
+	}
+	return true
+}
+
+func (p *planner) linkDir(pkg, srcDir, dstDir string) error {
+	entries, err := p.fs.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if p.ignored(pkg, e.Name()) {
+			continue
+		}
+		src := filepath.Join(srcDir, e.Name())
+		if p.isTemplate(pkg, src, e) {
+			p.renderEntry(pkg, src, filepath.Join(dstDir, renderedName(e.Name())))
+			continue
+		}
+		if err := p.linkEntry(pkg, src, filepath.Join(dstDir, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *planner) isTemplate(pkg, src string, e os.DirEntry) bool {
+	if !e.Type().IsRegular() {
+		return false
+	}
+	rel, err := filepath.Rel(filepath.Join(p.source, pkg), src)
+	return err == nil && (p.linker.IsTemplate(rel) || p.linker.IsSecret(rel))
+}
+
+func (p *planner) linkEntry(pkg, src, dst string) error {
+	srcInfo, err := p.fs.Lstat(src)
+	if err != nil {
+		return err
+	}
+	srcIsDir := srcInfo.IsDir()
+
+	existing, ok := p.claim(pkg, src, dst, p.lookup(dst), srcIsDir)
+	if !ok {
+		return nil
+	}
+	switch existing.kind {
+	case entryNone:
+		if srcIsDir && (p.linker.NoFolding || p.containsTemplate(pkg, src)) {
+			p.add(Action{Type: ActionMkdir, Package: pkg, Target: dst})
+			return p.linkDir(pkg, src, dst)
+		}
+		p.add(Action{Type: ActionLink, Package: pkg, Target: dst, Source: src})
+
+	case entryLink:
+		if existing.source == src && srcIsDir && p.containsTemplate(pkg, src) {
+			// A template was added to a folded directory; unfold it so
+			// the rendered file is not written into the repository.
+			p.add(Action{Type: ActionUnlink, Package: pkg, Target: dst, Source: src})
+			p.add(Action{Type: ActionMkdir, Package: pkg, Target: dst})
+			return p.linkDir(pkg, src, dst)
+		}
+		if existing.source == src {
+			p.inPlace(Action{Type: ActionLink, Package: pkg, Target: dst, Source: src})
+			return nil
+		}
+		if srcIsDir && p.owns(existing.source) && isDir(p.fs, existing.source) {
+			// Another package folded this directory; split it into
+			// per-entry links so both packages can share it.
+			p.add(Action{Type: ActionUnlink, Package: pkg, Target: dst, Source: existing.source})
+			p.add(Action{Type: ActionMkdir, Package: pkg, Target: dst})
+			if err := p.linkDir(p.packageOf(existing.source, pkg), existing.source, dst); err != nil {
+				return err
+			}
+			return p.linkDir(pkg, src, dst)
+		}
+		if p.providedAbove(pkg, existing.source) {
+			return nil
+		}
+		if p.owns(existing.source) && !isDir(p.fs, existing.source) {
+			// A link into the repository left by an earlier apply, for
+			// a file that moved or is now provided by another layer.
+			p.add(Action{Type: ActionUnlink, Package: pkg, Target: dst, Source: existing.source})
+			p.add(Action{Type: ActionLink, Package: pkg, Target: dst, Source: src})
+			return nil
+		}
+		if p.linker.Replace && !isDir(p.fs, dst) {
+			p.replace(pkg, src, dst)
+			return nil
+		}
+		p.conflict(pkg, dst, src, fmt.Sprintf("existing symlink points to %s", existing.source))
+
+	case entryDir:
+		if srcIsDir {
+			return p.linkDir(pkg, src, dst)
+		}
+		p.conflict(pkg, dst, src, "existing directory is in the way")
+
+	case entryFile:
+		if p.renderedInPlace(dst) {
+			// A file rendered by an earlier apply, not edited since. It
+			// is backed up all the same for rollback to put it back.
+			p.add(Action{Type: ActionBackup, Package: pkg, Target: dst})
+			p.add(Action{Type: ActionRemove, Package: pkg, Target: dst})
+			p.add(Action{Type: ActionLink, Package: pkg, Target: dst, Source: src})
+			return nil
+		}
+		if p.linker.Replace {
+			p.replace(pkg, src, dst)
+			return nil
+		}
+		p.conflict(pkg, dst, src, "existing file is in the way")
+	}
+
+	return nil
+}
+
+func (p *planner) replace(pkg, src, dst string) {
+	p.add(Action{Type: ActionBackup, Package: pkg, Target: dst})
+	p.add(Action{Type: ActionRemove, Package: pkg, Target: dst})
+	p.add(Action{Type: ActionLink, Package: pkg, Target: dst, Source: src})
+}
+
+func (p *planner) unlinkDir(pkg, srcDir, dstDir string) error {
+	entries, err := p.fs.ReadDir(srcDir)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if p.ignored(pkg, e.Name()) {
+			continue
+		}
+		src := filepath.Join(srcDir, e.Name())
+		dst := filepath.Join(dstDir, e.Name())
+
+		if p.isTemplate(pkg, src, e) {
+			p.unrenderEntry(pkg, src, filepath.Join(dstDir, renderedName(e.Name())))
+			continue
+		}
+
+		existing := p.lookup(dst)
+		switch existing.kind {
+		case entryLink:
+			if existing.source == src {
+				p.add(Action{Type: ActionUnlink, Package: pkg, Target: dst, Source: src})
+			}
+		case entryDir:
+			if e.IsDir() {
+				planned := len(p.plan.Actions)
+				if err := p.unlinkDir(pkg, src, dst); err != nil {
+					return err
+				}
+				if len(p.plan.Actions) > planned && p.linker.renderState().Dirs[dst] && p.emptied(dst) {
+					p.add(Action{Type: ActionRmdir, Package: pkg, Target: dst})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// emptied reports whether dir will have no entries left once the plan so
+// far has been executed.
+func (p *planner) emptied(dir string) bool {
+	entries, err := p.fs.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, e := range entries {
+		if p.lookup(filepath.Join(dir, e.Name())).kind != entryNone {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveLink returns the absolute, cleaned destination of a symlink
+// without following any further links.
+func resolveLink(fsys vfs.FS, path string) string {
+	dest, err := fsys.Readlink(path)
+	if err != nil {
+		return ""
+	}
+	if !filepath.IsAbs(dest) {
+		dest = filepath.Join(filepath.Dir(path), dest)
+	}
+	return filepath.Clean(dest)
+}
+
+// IsWithin reports whether path is root itself or lies below it.
+func IsWithin(root, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func isDir(fsys vfs.FS, path string) bool {
+	info, err := fsys.Stat(path)
+	return err == nil && info.IsDir()
+}
@@ -0,0 +1,295 @@
+package stow
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+// memoryLinker returns a linker from /dotfiles into /home/user on an
+// in-memory filesystem holding files, keyed by absolute path.
+func memoryLinker(t *testing.T, files map[string]string) (*Linker, *vfs.Memory) {
+	t.Helper()
+	m := vfs.NewMemory()
+	for _, dir := range []string{"/dotfiles", "/home/user"} {
+		if err := m.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for path, content := range files {
+		if err := m.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	home := config.HomeDir
+	config.HomeDir = "/home/user"
+	t.Cleanup(func() { config.HomeDir = home })
+
+	return &Linker{
+		SourceDir:    "/dotfiles",
+		TargetDir:    "/home/user",
+		BackupDir:    "/home/user/.dfmgr_backup",
+		TemplateData: &TemplateData{Home: "/home/user", Vars: map[string]string{"email": "me@example.com"}},
+		FS:           m,
+	}, m
+}
+
+func apply(t *testing.T, l *Linker, packages ...string) *Plan {
+	t.Helper()
+	plan, err := l.Plan(packages)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Execute(plan); err != nil {
+		t.Fatal(err)
+	}
+	return plan
+}
+
+func TestApplyAndUnapply(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/bash/.bashrc":                 "export EDITOR=nvim\n",
+		"/dotfiles/nvim/.config/nvim/init.lua":   "-- init\n",
+		"/home/user/.config/other/settings.json": "{}\n",
+	})
+
+	apply(t, l, "bash", "nvim")
+
+	// .config exists, so only nvim below it is folded into a link.
+	for target, want := range map[string]string{
+		"/home/user/.bashrc":      "../../dotfiles/bash/.bashrc",
+		"/home/user/.config/nvim": "../../../dotfiles/nvim/.config/nvim",
+	} {
+		if link, err := m.Readlink(target); err != nil || link != want {
+			t.Errorf("Readlink(%s) = %q, %v, want %q", target, link, err, want)
+		}
+	}
+
+	status, err := l.Status([]string{"bash", "nvim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status) != 2 {
+		t.Fatalf("Status returned %d files, want 2", len(status))
+	}
+	for _, s := range status {
+		if s.State != StateLinked {
+			t.Errorf("%s is %s, want %s", s.Target, s.State, StateLinked)
+		}
+	}
+
+	// Applying again has nothing left to do.
+	if plan, err := l.Plan([]string{"bash", "nvim"}); err != nil || len(plan.Actions) != 0 {
+		t.Errorf("second Plan = %+v, %v, want no actions", plan, err)
+	}
+
+	plan, err := l.PlanUnlink([]string{"bash", "nvim"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Execute(plan); err != nil {
+		t.Fatal(err)
+	}
+	for _, target := range []string{"/home/user/.bashrc", "/home/user/.config/nvim"} {
+		if _, err := m.Lstat(target); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after unapply: %v", target, err)
+		}
+	}
+	if _, err := m.Stat("/home/user/.config/other/settings.json"); err != nil {
+		t.Errorf("unrelated file removed: %v", err)
+	}
+}
+
+func TestUnapplyRemovesOnlyCreatedDirectories(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/nvim/.config/nvim/init.lua": "-- init\n",
+		"/dotfiles/bin/.local/bin/script":      "#!/bin/sh\n",
+	})
+	l.NoFolding = true
+	if err := m.MkdirAll("/home/user/.config", 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	apply(t, l, "nvim", "bin")
+	plan, err := l.PlanUnlink([]string{"nvim", "bin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Execute(plan); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.Stat("/home/user/.config"); err != nil {
+		t.Errorf("pre-existing .config removed by unapply: %v", err)
+	}
+	for _, dir := range []string{"/home/user/.config/nvim", "/home/user/.local/bin", "/home/user/.local"} {
+		if _, err := m.Lstat(dir); !os.IsNotExist(err) {
+			t.Errorf("%s created by apply still exists after unapply: %v", dir, err)
+		}
+	}
+}
+
+func TestApplyUnfoldsSharedDirectory(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/fish/.config/fish/config.fish":    "set -x EDITOR nvim\n",
+		"/dotfiles/fish-work/.config/fish/work.fish": "set -x PROXY on\n",
+	})
+
+	apply(t, l, "fish")
+	if _, err := m.Readlink("/home/user/.config"); err != nil {
+		t.Fatalf(".config was not folded: %v", err)
+	}
+
+	apply(t, l, "fish", "fish-work")
+	info, err := m.Lstat("/home/user/.config/fish")
+	if err != nil || !info.IsDir() {
+		t.Fatalf("fish was not unfolded into a directory: %v, %v", info, err)
+	}
+	for _, name := range []string{"config.fish", "work.fish"} {
+		if _, err := m.Stat(filepath.Join("/home/user/.config/fish", name)); err != nil {
+			t.Errorf("%s not reachable: %v", name, err)
+		}
+	}
+}
+
+func TestApplyConflict(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/bash/.bashrc": "export EDITOR=nvim\n",
+		"/home/user/.bashrc":     "export EDITOR=vi\n",
+	})
+
+	plan, err := l.Plan([]string{"bash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].Target != "/home/user/.bashrc" {
+		t.Fatalf("Conflicts = %+v, want .bashrc", plan.Conflicts)
+	}
+	if err := l.Execute(plan); err == nil {
+		t.Fatal("Execute with conflicts succeeded")
+	}
+	if data, _ := m.ReadFile("/home/user/.bashrc"); string(data) != "export EDITOR=vi\n" {
+		t.Errorf(".bashrc changed to %q", data)
+	}
+}
+
+func TestApplyReplaceBacksUp(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/bash/.bashrc": "export EDITOR=nvim\n",
+		"/home/user/.bashrc":     "export EDITOR=vi\n",
+	})
+	l.Replace = true
+
+	apply(t, l, "bash")
+
+	if data, err := m.ReadFile("/home/user/.bashrc"); err != nil || string(data) != "export EDITOR=nvim\n" {
+		t.Errorf(".bashrc = %q, %v, want the repository copy", data, err)
+	}
+
+	snapshot := l.Snapshot()
+	if snapshot == nil || len(snapshot.Manifest.Entries) != 1 {
+		t.Fatalf("Snapshot = %+v, want one entry", snapshot)
+	}
+	entry := snapshot.Manifest.Entries[0]
+	if entry.Path != ".bashrc" || entry.Package != "bash" {
+		t.Errorf("entry = %+v", entry)
+	}
+
+	if err := snapshot.Restore(entry, func(string) bool { return true }); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := m.ReadFile("/home/user/.bashrc"); err != nil || string(data) != "export EDITOR=vi\n" {
+		t.Errorf("restored .bashrc = %q, %v", data, err)
+	}
+}
+
+func TestApplyRendersTemplates(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/git/.gitconfig.tmpl": "[user]\n\temail = {{ .Vars.email }}\n",
+	})
+
+	apply(t, l, "git")
+
+	data, err := m.ReadFile("/home/user/.gitconfig")
+	if err != nil || string(data) != "[user]\n\temail = me@example.com\n" {
+		t.Fatalf(".gitconfig = %q, %v", data, err)
+	}
+	if _, err := m.Stat(RenderStateFile()); err != nil {
+		t.Errorf("render state not saved: %v", err)
+	}
+
+	status, err := l.Status([]string{"git"})
+	if err != nil || len(status) != 1 || status[0].State != StateLinked {
+		t.Fatalf("Status = %+v, %v", status, err)
+	}
+
+	m.WriteFile("/home/user/.gitconfig", []byte("edited\n"), 0644)
+	if status, _ := l.Status([]string{"git"}); status[0].State != StateEdited {
+		t.Errorf("edited file is %s, want %s", status[0].State, StateEdited)
+	}
+}
+
+func TestApplyKeepsEditedRenderedFile(t *testing.T) {
+	l, m := memoryLinker(t, map[string]string{
+		"/dotfiles/git/.gitconfig.tmpl": "[user]\n\temail = {{ .Vars.email }}\n",
+	})
+	l.Replace = true
+	apply(t, l, "git")
+
+	m.WriteFile("/home/user/.gitconfig", []byte("edited\n"), 0644)
+	l.TemplateData.Vars["email"] = "new@example.com"
+	plan, err := l.Plan([]string{"git"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(plan.Conflicts) != 1 || plan.Conflicts[0].Target != "/home/user/.gitconfig" {
+		t.Fatalf("Conflicts = %+v, want .gitconfig", plan.Conflicts)
+	}
+
+	l.ReplaceEdited = true
+	apply(t, l, "git")
+	if data, _ := m.ReadFile("/home/user/.gitconfig"); string(data) != "[user]\n\temail = new@example.com\n" {
+		t.Errorf(".gitconfig = %q, want it rendered again", data)
+	}
+	if l.Snapshot() == nil {
+		t.Error("edited file was not backed up")
+	}
+}
+
+// TestApplyOnDisk links into a temporary home on the real filesystem.
+func TestApplyOnDisk(t *testing.T) {
+	source, home := t.TempDir(), t.TempDir()
+	if err := os.MkdirAll(filepath.Join(source, "bash"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(source, "bash", ".bashrc"), []byte("export EDITOR=nvim\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".bashrc"), []byte("export EDITOR=vi\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	l := &Linker{SourceDir: source, TargetDir: home, BackupDir: filepath.Join(home, ".dfmgr_backup"), Replace: true}
+	apply(t, l, "bash")
+
+	resolved, err := filepath.EvalSymlinks(filepath.Join(home, ".bashrc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	realSource, _ := filepath.EvalSymlinks(filepath.Join(source, "bash", ".bashrc"))
+	if resolved != realSource {
+		t.Errorf(".bashrc resolves to %s, want %s", resolved, realSource)
+	}
+
+	backedUp := filepath.Join(l.Snapshot().Dir, "files", ".bashrc")
+	if data, err := os.ReadFile(backedUp); err != nil || string(data) != "export EDITOR=vi\n" {
+		t.Errorf("backup = %q, %v", data, err)
+	}
+}
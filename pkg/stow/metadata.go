@@ -0,0 +1,156 @@
+package stow
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// MetadataFile lives at the root of the dotfiles repository and records
+// what git cannot store, currently permission bits other than 0644/0755.
+const MetadataFile = ".dfmgr-meta.json"
+
+type Metadata struct {
+	Version int               `json:"version"`
+	Modes   map[string]string `json:"modes"`
+
+	// FlatLayout lists the entries, relative to the repository, that sync
+	// found in the old flat layout and was told to leave where they are.
+	FlatLayout []string `json:"flat_layout,omitempty"`
+
+	// changed is set when RecordMode recorded a new mode or dropped one.
+	changed bool
+}
+
+func LoadMetadata(repoPath string) (*Metadata, error) {
+	m := &Metadata{Version: 1, Modes: make(map[string]string)}
+
+	data, err := os.ReadFile(filepath.Join(repoPath, MetadataFile))
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", MetadataFile, err)
+	}
+	if m.Modes == nil {
+		m.Modes = make(map[string]string)
+	}
+	return m, nil
+}
+
+func (m *Metadata) Save(repoPath string) error {
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(repoPath, MetadataFile), append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	m.changed = false
+	return nil
+}
+
+// Changed reports whether RecordMode changed a mode since m was loaded or
+// last saved.
+func (m *Metadata) Changed() bool {
+	return m.changed
+}
+
+// RecordMode remembers the permissions of path, relative to the repository.
+// Modes git already preserves are not recorded.
+func (m *Metadata) RecordMode(repoPath, path string, mode os.FileMode) error {
+	rel, err := filepath.Rel(repoPath, path)
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+
+	perm := mode.Perm()
+	standard := perm == 0644 || perm == 0755
+	if mode&(os.ModeSetuid|os.ModeSetgid|os.ModeSticky) == 0 && standard {
+		if _, ok := m.Modes[rel]; ok {
+			delete(m.Modes, rel)
+			m.changed = true
+		}
+		return nil
+	}
+
+	value := fmt.Sprintf("%04o", uint32(perm)|specialBits(mode))
+	if m.Modes[rel] != value {
+		m.Modes[rel] = value
+		m.changed = true
+	}
+	return nil
+}
+
+// Enforce applies every recorded mode to the repository copy, which is what
+// the symlinks in the home directory resolve to. Missing paths are skipped.
+func (m *Metadata) Enforce(repoPath string) []error {
+	var paths []string
+	for rel := range m.Modes {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	var errs []error
+	for _, rel := range paths {
+		value, err := strconv.ParseUint(m.Modes[rel], 8, 32)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: invalid mode %q", rel, m.Modes[rel]))
+			continue
+		}
+
+		path := filepath.Join(repoPath, filepath.FromSlash(rel))
+		info, err := os.Lstat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			continue
+		}
+
+		if err := os.Chmod(path, fileMode(uint32(value))); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errs
+}
+
+func specialBits(mode os.FileMode) uint32 {
+	var bits uint32
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return bits
+}
+
+func fileMode(value uint32) os.FileMode {
+	mode := os.FileMode(value & 0777)
+	if value&04000 != 0 {
+		mode |= os.ModeSetuid
+	}
+	if value&02000 != 0 {
+		mode |= os.ModeSetgid
+	}
+	if value&01000 != 0 {
+		mode |= os.ModeSticky
+	}
+	return mode
+}
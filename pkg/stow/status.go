@@ -0,0 +1,184 @@
+package stow
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+type FileState string
+
+const (
+	StateLinked    FileState = "linked"
+	StateMissing   FileState = "missing"
+	StateConflict  FileState = "conflict"
+	StateElsewhere FileState = "elsewhere"
+	StateBroken    FileState = "broken"
+
+	// Rendered templates are either linked (up to date), outdated (the
+	// template or its data changed since apply) or edited by hand.
+	StateOutdated FileState = "outdated"
+	StateEdited   FileState = "edited"
+)
+
+type FileStatus struct {
+	Package  string    `json:"package"`
+	Source   string    `json:"source"`
+	Target   string    `json:"target"`
+	State    FileState `json:"state"`
+	Detail   string    `json:"detail,omitempty"`
+	Template bool      `json:"template,omitempty"`
+	Secret   bool      `json:"secret,omitempty"`
+}
+
+// Status compares every file of packages with what is found at the
+// corresponding path in the package's target. Files count as linked whether
+// the link is on the file itself or on a folded parent directory. Files
+// overridden by a package of a higher layer are left out.
+func (l *Linker) Status(packages []string) ([]FileStatus, error) {
+	var result []FileStatus
+
+	packages = append([]string{}, packages...)
+	sort.SliceStable(packages, func(i, j int) bool {
+		return l.LayerOf(packages[i]) > l.LayerOf(packages[j])
+	})
+	provided := make(map[string]string)
+
+	for _, pkg := range packages {
+		pkgDir := filepath.Join(l.SourceDir, pkg)
+		targetDir := l.TargetFor(pkg)
+
+		err := vfs.WalkDir(l.filesystem(), pkgDir, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if path != pkgDir && isIgnored(d.Name(), l.Ignore, l.PackageIgnore[pkg]) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				return nil
+			}
+
+			rel, err := filepath.Rel(pkgDir, path)
+			if err != nil {
+				return err
+			}
+
+			target := filepath.Join(targetDir, rel)
+			template := d.Type().IsRegular() && (l.IsTemplate(rel) || l.IsSecret(rel))
+			if template {
+				target = filepath.Join(targetDir, renderedName(rel))
+			}
+			if other, ok := provided[target]; ok && l.LayerOf(other) > l.LayerOf(pkg) {
+				return nil
+			}
+			provided[target] = pkg
+
+			var status FileStatus
+			if template {
+				status = l.templateStatus(path, target)
+			} else {
+				status = l.fileStatus(path, target)
+			}
+			status.Package = pkg
+			result = append(result, status)
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return result, nil
+}
+
+func (l *Linker) fileStatus(source, target string) FileStatus {
+	fsys := l.filesystem()
+	status := FileStatus{Source: source, Target: target}
+
+	realSource, err := fsys.EvalSymlinks(source)
+	if err != nil {
+		realSource = source
+	}
+
+	info, err := fsys.Lstat(target)
+	if err != nil {
+		if os.IsNotExist(err) {
+			status.State = StateMissing
+		} else {
+			status.State = StateBroken
+			status.Detail = err.Error()
+		}
+		return status
+	}
+
+	resolved, err := fsys.EvalSymlinks(target)
+	switch {
+	case err != nil:
+		status.State = StateBroken
+		status.Detail = "link points to " + resolveLink(fsys, target)
+	case resolved == realSource:
+		status.State = StateLinked
+	case info.Mode()&os.ModeSymlink != 0:
+		status.State = StateElsewhere
+		status.Detail = "link points to " + resolveLink(fsys, target)
+	default:
+		status.State = StateConflict
+		status.Detail = "real file in place"
+	}
+	return status
+}
+
+func (l *Linker) templateStatus(source, target string) FileStatus {
+	fsys := l.filesystem()
+	status := FileStatus{Source: source, Target: target, Template: !l.IsSecret(source), Secret: l.IsSecret(source)}
+
+	rendered, err := l.Render(source)
+	if err != nil {
+		status.State = StateBroken
+		status.Detail = "cannot render: " + err.Error()
+		return status
+	}
+
+	info, err := fsys.Lstat(target)
+	if os.IsNotExist(err) {
+		status.State = StateMissing
+		return status
+	}
+	if err != nil {
+		status.State = StateBroken
+		status.Detail = err.Error()
+		return status
+	}
+	if !info.Mode().IsRegular() {
+		status.State = StateConflict
+		status.Detail = "not a regular file"
+		return status
+	}
+
+	current, err := fsys.ReadFile(target)
+	switch {
+	case err != nil:
+		status.State = StateBroken
+		status.Detail = err.Error()
+	case bytes.Equal(current, rendered):
+		status.State = StateLinked
+		status.Detail = "rendered"
+	case !l.renderState().Edited(target, current):
+		status.State = StateOutdated
+		status.Detail = "template changed since the last apply"
+	case l.renderState().Files[target] != "":
+		status.State = StateEdited
+		status.Detail = "rendered file was edited by hand"
+	default:
+		status.State = StateConflict
+		status.Detail = "real file in place"
+	}
+	return status
+}
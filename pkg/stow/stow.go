@@ -7,8 +7,12 @@ import (
 	"path/filepath"
 	"strings"
 
+	"github.com/cetincetindag/dfmgr/pkg/backup"
 	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/manifest"
+	"github.com/cetincetindag/dfmgr/pkg/secret"
 	"github.com/cetincetindag/dfmgr/pkg/utils"
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
 )
 
 func IsStowInstalled() bool {
@@ -29,10 +33,44 @@ func StowPackages(sourcePath, targetPath string, packages []string) error {
 	}, packages...)
 	
 	cmd := exec.Command("stow", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	return utils.RunCommand(cmd)
+}
+
+// LinkPackages links packages using the backend selected in the config.
+// The native linker is the default so GNU stow is only needed on request.
+func LinkPackages(sourcePath, targetPath string, packages []string) error {
+	if config.CurrentConfig.LinkBackend == BackendStow {
+		if err := CheckStowable(&Linker{SourceDir: sourcePath, TargetDir: targetPath}, packages); err != nil {
+			return err
+		}
+		return StowPackages(sourcePath, targetPath, packages)
+	}
+
+	utils.Info("Symlinking packages: %s", strings.Join(packages, ", "))
+
+	linker := &Linker{SourceDir: sourcePath, TargetDir: targetPath}
+	plan, err := linker.Plan(packages)
+	if err != nil {
+		return err
+	}
+
+	return linker.Execute(plan)
+}
+
+func UnlinkPackages(sourcePath, targetPath string, packages []string) error {
+	if config.CurrentConfig.LinkBackend == BackendStow {
+		return UnstowPackages(sourcePath, targetPath, packages)
+	}
+
+	utils.Info("Removing symlinks: %s", strings.Join(packages, ", "))
+
+	linker := &Linker{SourceDir: sourcePath, TargetDir: targetPath}
+	plan, err := linker.PlanUnlink(packages)
+	if err != nil {
+		return err
+	}
+
+	return linker.Execute(plan)
 }
 
 func UnstowPackages(sourcePath, targetPath string, packages []string) error {
@@ -50,18 +88,68 @@ func UnstowPackages(sourcePath, targetPath string, packages []string) error {
 	}, packages...)
 	
 	cmd := exec.Command("stow", args...)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	
-	return cmd.Run()
+	return utils.RunCommand(cmd)
+}
+
+// CheckStowable refuses packages holding templates or secrets, which GNU
+// stow would link as they are instead of rendering them.
+func CheckStowable(linker *Linker, packages []string) error {
+	files := linker.TemplateFiles(packages)
+	if len(files) == 0 {
+		return nil
+	}
+	return fmt.Errorf("GNU stow would link %d template(s) and secret(s) as they are, such as %s; set link_backend to %q to render them", len(files), files[0], BackendNative)
+}
+
+// StowJournaled runs StowPackages, recording in journal first the links and
+// directories stow is about to create, as plan, the native linker's plan
+// for the same packages, has them. Rollback removes those again, while the
+// links of an earlier apply, which the plan leaves in place, stay.
+func StowJournaled(sourcePath, targetPath string, packages []string, plan *Plan, journal *Journal) error {
+	steps := stowSteps(plan, packages)
+	if err := journal.beginAll(steps); err != nil {
+		return err
+	}
+	if err := StowPackages(sourcePath, targetPath, packages); err != nil {
+		return err
+	}
+	return journal.doneAll(len(steps))
 }
 
-func BackupAndRemoveConflicts(sourcePath, targetPath, backupDir string, packages []string) error {
+// stowSteps returns the steps of plan that stow makes for packages.
+func stowSteps(plan *Plan, packages []string) []Step {
+	selected := make(map[string]bool)
+	for _, pkg := range packages {
+		selected[pkg] = true
+	}
+
+	var steps []Step
+	for _, action := range plan.Actions {
+		switch action.Type {
+		case ActionMkdir, ActionLink, ActionUnlink:
+			if selected[action.Package] {
+				steps = append(steps, Step{Type: action.Type, Package: action.Package, Target: action.Target, Source: action.Source})
+			}
+		}
+	}
+	return steps
+}
+
+// BackupAndRemoveConflicts backs up and removes the files in the way of
+// stowing packages, recording each step in journal unless it is nil.
+func BackupAndRemoveConflicts(sourcePath, targetPath, backupDir string, packages []string, journal *Journal) error {
 	if err := utils.EnsureDirExists(backupDir); err != nil {
 		return err
 	}
 
-	home := os.Getenv("HOME")
+	home := config.Home()
+	realSource, err := filepath.EvalSymlinks(sourcePath)
+	if err != nil {
+		realSource = sourcePath
+	}
+	
+	var snapshot *backup.Snapshot
+	
 	for _, pkg := range packages {
 		pkgPath := filepath.Join(sourcePath, pkg)
 		
@@ -81,6 +169,12 @@ func BackupAndRemoveConflicts(sourcePath, targetPath, backupDir string, packages
 			
 			targetFilePath := filepath.Join(targetPath, relPath)
 			
+			// Files reached through links into the repository are already
+			// ours; removing them would delete the repository copy.
+			if resolved, err := filepath.EvalSymlinks(targetFilePath); err == nil && IsWithin(realSource, resolved) {
+				return nil
+			}
+			
 			if _, err := os.Stat(targetFilePath); err == nil {
 				relToHome, err := filepath.Rel(home, targetFilePath)
 				if err != nil {
@@ -89,19 +183,36 @@ func BackupAndRemoveConflicts(sourcePath, targetPath, backupDir string, packages
 				
 				utils.Warning("Found existing file: %s", relToHome)
 				
-				backupSubDir := filepath.Join(backupDir, pkg)
-				backupPath, err := utils.BackupFile(targetFilePath, backupSubDir)
+				if snapshot == nil {
+					if snapshot, err = backup.New(backupDir, targetPath); err != nil {
+						return err
+					}
+				}
+				
+				if err := journal.begin(Step{Type: ActionBackup, Package: pkg, Target: targetFilePath}); err != nil {
+					return err
+				}
+				backupPath, err := snapshot.Add(pkg, targetFilePath)
 				if err != nil {
 					return err
 				}
+				if err := journal.done(snapshot.Dir); err != nil {
+					return err
+				}
 				
-				if backupPath != "" {
-					utils.Info("Backed up to: %s", backupPath)
-					if err := os.Remove(targetFilePath); err != nil {
-						return err
-					}
-					utils.Info("Removed: %s", targetFilePath)
+				utils.Info("Backed up to: %s", backupPath)
+				utils.Record(string(ActionBackup), targetFilePath, backupPath)
+				if err := journal.begin(Step{Type: ActionRemove, Package: pkg, Target: targetFilePath}); err != nil {
+					return err
 				}
+				if err := os.Remove(targetFilePath); err != nil {
+					return err
+				}
+				if err := journal.done(""); err != nil {
+					return err
+				}
+				utils.Info("Removed: %s", targetFilePath)
+				utils.Record(string(ActionRemove), targetFilePath, "")
 			}
 			
 			return nil
@@ -115,53 +226,158 @@ func BackupAndRemoveConflicts(sourcePath, targetPath, backupDir string, packages
 	return nil
 }
 
-func ApplyDotfiles(interactive bool) error {
+// ApplyLinker returns the Linker apply uses: NewLinker, backing up and
+// replacing the files in the way of links.
+func ApplyLinker() (*Linker, error) {
+	linker, err := NewLinker()
+	if err != nil {
+		return nil, err
+	}
+	linker.BackupDir = backup.DefaultDir()
+	linker.Replace = true
+	return linker, nil
+}
+
+// NewLinker returns a Linker for the configured dotfiles repository and
+// home directory, with package targets and ignore patterns taken from the
+// repository manifest when there is one.
+func NewLinker() (*Linker, error) {
 	localPath := config.CurrentConfig.LocalPath
-	home := os.Getenv("HOME")
+	home := config.Home()
 	
-	if !utils.IsGitRepo(localPath) {
-		return fmt.Errorf("no dotfiles repository found at %s", localPath)
+	rendered, err := LoadRenderState(RenderStateFile())
+	if err != nil {
+		return nil, err
 	}
 	
-	packages := []string{}
+	keyring, err := secret.LoadKeyring()
+	if err != nil {
+		return nil, err
+	}
 	
-	entries, err := os.ReadDir(localPath)
+	linker := &Linker{
+		SourceDir:    localPath,
+		TargetDir:    home,
+		Layers:       config.Layers(),
+		Templates:    config.CurrentConfig.Templates,
+		TemplateData: NewTemplateData(),
+		Rendered:     rendered,
+		Secrets:      keyring,
+	}
+	
+	m, err := manifest.Load(localPath)
+	if err != nil || m == nil {
+		return linker, err
+	}
+	
+	linker.Ignore = m.Ignore
+	linker.Targets = make(map[string]string)
+	linker.PackageIgnore = make(map[string][]string)
+	for _, name := range m.Names() {
+		pkg := m.Packages[name]
+		path := pkg.PathOf(name)
+		linker.Targets[path] = pkg.TargetDir(home)
+		linker.PackageIgnore[path] = pkg.Ignore
+	}
+	return linker, nil
+}
+
+// GroupByTarget groups packages by the directory they are linked into, for
+// backends such as stow that take a single target.
+func GroupByTarget(linker *Linker, packages []string) map[string][]string {
+	groups := make(map[string][]string)
+	for _, pkg := range packages {
+		target := linker.TargetFor(pkg)
+		groups[target] = append(groups[target], pkg)
+	}
+	return groups
+}
+
+// MissingTools returns the tools required by the repository manifest, or
+// by any of packages, that cannot be found in PATH.
+func MissingTools(packages []string) []string {
+	m, err := manifest.Load(config.CurrentConfig.LocalPath)
+	if err != nil || m == nil {
+		return nil
+	}
+	
+	required := append([]string{}, m.Requires...)
+	for _, name := range m.Names() {
+		pkg := m.Packages[name]
+		for _, selected := range packages {
+			if pkg.PathOf(name) == selected {
+				required = append(required, pkg.Requires...)
+			}
+		}
+	}
+	
+	var missing []string
+	seen := make(map[string]bool)
+	for _, tool := range required {
+		if !seen[tool] && !utils.IsCommandAvailable(tool) {
+			missing = append(missing, tool)
+		}
+		seen[tool] = true
+	}
+	return missing
+}
+
+// SelectPackages lists the packages in the dotfiles repository, prompting
+// for a subset when interactive is set.
+func SelectPackages(interactive bool) ([]string, error) {
+	localPath := config.CurrentConfig.LocalPath
+	
+	if !utils.IsGitRepo(localPath) {
+		return nil, fmt.Errorf("no dotfiles repository found at %s", localPath)
+	}
+	
+	m, err := manifest.Load(localPath)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	
-	for _, entry := range entries {
-		if entry.IsDir() && entry.Name() != ".git" {
-			if config.CurrentConfig.MultiOS {
-				osFolder := config.GetOSFolder()
-				if osFolder != "" && entry.Name() == osFolder {
-					osDir := filepath.Join(localPath, osFolder)
-					osEntries, err := os.ReadDir(osDir)
-					if err != nil {
-						return err
-					}
-					
-					for _, osEntry := range osEntries {
-						if osEntry.IsDir() {
-							packages = append(packages, filepath.Join(osFolder, osEntry.Name()))
-						}
-					}
-				}
-			} else {
-				packages = append(packages, entry.Name())
+	if m != nil {
+		if err := manifest.Join(m.Validate(localPath)); err != nil {
+			return nil, err
+		}
+	}
+	
+	var packages []string
+	if m != nil && len(m.Packages) > 0 {
+		packages = manifestPackages(m)
+	} else {
+		packages, err = discoverPackages(localPath)
+		if err != nil {
+			return nil, err
+		}
+	}
+	
+	selection, err := PackageSelection(m)
+	if err != nil {
+		return nil, err
+	}
+	if selection.Filtered {
+		selected := []string{}
+		for _, pkg := range packages {
+			if selection.Matches(pkg) {
+				selected = append(selected, pkg)
 			}
 		}
+		packages = selected
 	}
 	
 	if interactive && len(packages) > 0 {
+		if !utils.Interactive() {
+			return nil, fmt.Errorf("selecting packages needs a terminal, choose them with a profile instead (dfmgr profile use)")
+		}
 		selectedPackages := []string{}
 		
 		utils.Info("Available packages:")
 		for i, pkg := range packages {
-			fmt.Printf("[%d] %s\n", i+1, pkg)
+			fmt.Fprintf(utils.PromptOutput, "[%d] %s\n", i+1, pkg)
 		}
 		
-		fmt.Print("Enter package numbers to apply (comma separated, or 'all' for all packages): ")
+		fmt.Fprint(utils.PromptOutput, "Enter package numbers to apply (comma separated, or 'all' for all packages): ")
 		var input string
 		fmt.Scanln(&input)
 		
@@ -181,15 +397,170 @@ func ApplyDotfiles(interactive bool) error {
 		packages = selectedPackages
 	}
 	
-	if len(packages) == 0 {
-		utils.Warning("No packages to apply")
-		return nil
+	return packages, nil
+}
+
+// AllPackages lists every package in the dotfiles repository, whatever the
+// profile and host rules of this machine select, as removing links needs:
+// a package left out of the profile since apply is still linked. Those
+// that apply to this machine come first, so that MatchPackages resolves
+// "nvim" to the OS folder of this machine.
+func AllPackages() ([]string, error) {
+	localPath := config.CurrentConfig.LocalPath
+	
+	if !utils.IsGitRepo(localPath) {
+		return nil, fmt.Errorf("no dotfiles repository found at %s", localPath)
 	}
 	
-	backupDir := filepath.Join(home, ".dfmgr_backup")
-	if err := BackupAndRemoveConflicts(localPath, home, backupDir, packages); err != nil {
-		return err
+	m, err := manifest.Load(localPath)
+	if err != nil {
+		return nil, err
+	}
+	if m == nil || len(m.Packages) == 0 {
+		return discoverPackages(localPath)
+	}
+	
+	packages := manifestPackages(m)
+	seen := make(map[string]bool)
+	for _, pkg := range packages {
+		seen[pkg] = true
+	}
+	for _, name := range m.Names() {
+		if path := m.Packages[name].PathOf(name); !seen[path] {
+			packages = append(packages, path)
+			seen[path] = true
+		}
+	}
+	return packages, nil
+}
+
+// discoverPackages finds packages by directory convention: every top-level
+// directory, or every directory inside the layers of this machine (common/,
+// the OS folder and hosts/<hostname>/) with MultiOS.
+func discoverPackages(localPath string) ([]string, error) {
+	packages := []string{}
+	
+	if config.CurrentConfig.MultiOS {
+		for _, layer := range config.Layers() {
+			layerEntries, err := os.ReadDir(filepath.Join(localPath, layer))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			
+			for _, layerEntry := range layerEntries {
+				if layerEntry.IsDir() {
+					packages = append(packages, filepath.Join(layer, layerEntry.Name()))
+				}
+			}
+		}
+		return packages, nil
 	}
 	
-	return StowPackages(localPath, home, packages)
-} 
\ No newline at end of file
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		return nil, err
+	}
+	
+	for _, entry := range entries {
+		if entry.IsDir() && entry.Name() != ".git" {
+			packages = append(packages, entry.Name())
+		}
+	}
+	
+	return packages, nil
+}
+
+// MatchPackages resolves package names against the available packages.
+// OS-specific packages can be named without their OS folder, so "nvim"
+// selects "linux/nvim".
+func MatchPackages(available, names []string) ([]string, error) {
+	var result []string
+	for _, name := range names {
+		found := false
+		for _, pkg := range available {
+			if pkg == name || filepath.Base(pkg) == name {
+				result = append(result, pkg)
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown package: %s", name)
+		}
+	}
+	return result, nil
+}
+
+// PackageSelection resolves the active profile and host rules of this
+// machine.
+func PackageSelection(m *manifest.Manifest) (*config.Selection, error) {
+	profiles, rules := Profiles(m)
+	hostname, _ := os.Hostname()
+	return config.Select(profiles, rules, config.CurrentConfig.Profile, hostname)
+}
+
+// Profiles returns the profiles and host rules defined for this machine.
+// Those from the dfmgr configuration take precedence over those of the
+// repository manifest m, which may be nil.
+func Profiles(m *manifest.Manifest) (map[string]config.Profile, []config.HostRule) {
+	profiles := make(map[string]config.Profile)
+	var rules []config.HostRule
+	rules = append(rules, config.CurrentConfig.HostRules...)
+	if m != nil {
+		for name, profile := range m.Profiles {
+			profiles[name] = profile
+		}
+		rules = append(rules, m.HostRules...)
+	}
+	for name, profile := range config.CurrentConfig.Profiles {
+		profiles[name] = profile
+	}
+	return profiles, rules
+}
+
+// manifestPackages returns the paths of the manifest packages meant for
+// this machine.
+func manifestPackages(m *manifest.Manifest) []string {
+	hostname, _ := os.Hostname()
+	osNames := config.CurrentFacts().Names()
+	if folder, ok := config.CurrentConfig.OSSeparation[config.GetCurrentOS()]; ok {
+		osNames = append(osNames, folder)
+	}
+	osNames = append(osNames, config.OSFolders()...)
+	
+	packages := []string{}
+	for _, name := range m.Names() {
+		pkg := m.Packages[name]
+		if pkg.AppliesTo(osNames, hostname) {
+			packages = append(packages, pkg.PathOf(name))
+		}
+	}
+	return packages
+}
+
+// IsManagedLink reports whether path is a symlink into the dotfiles
+// repository, i.e. one dfmgr created and may remove.
+func IsManagedLink(path string) bool {
+	info, err := os.Lstat(path)
+	if err != nil || info.Mode()&os.ModeSymlink == 0 {
+		return false
+	}
+	return IsWithin(config.CurrentConfig.LocalPath, resolveLink(vfs.OS{}, path))
+}
+
+// EnforceModes re-applies the permissions recorded in the repository
+// metadata, which a fresh git checkout does not preserve.
+func EnforceModes(localPath string) {
+	metadata, err := LoadMetadata(localPath)
+	if err != nil {
+		utils.Warning("Failed to load %s: %s", MetadataFile, err)
+		return
+	}
+	
+	for _, err := range metadata.Enforce(localPath) {
+		utils.Warning("Failed to restore permissions: %s", err)
+	}
+}
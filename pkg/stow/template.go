@@ -0,0 +1,390 @@
+package stow
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/cetincetindag/dfmgr/pkg/config"
+	"github.com/cetincetindag/dfmgr/pkg/secret"
+	"github.com/cetincetindag/dfmgr/pkg/vfs"
+)
+
+// TemplateSuffix marks files that apply renders instead of linking. The
+// rendered file is written without the suffix.
+const TemplateSuffix = ".tmpl"
+
+// TemplateData is what templates see as their dot, e.g. {{ .Hostname }} or
+// {{ .Vars.email }}.
+type TemplateData struct {
+	OS             string
+	Hostname       string
+	Username       string
+	Home           string
+	GithubUsername string
+	Vars           map[string]string
+
+	// Facts holds the architecture, Linux distribution, WSL and container
+	// details, e.g. {{ if eq .Facts.Distro "arch" }}.
+	Facts config.Facts
+}
+
+func NewTemplateData() *TemplateData {
+	hostname, _ := os.Hostname()
+	username := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		username = u.Username
+	}
+
+	vars := config.CurrentConfig.Variables
+	if vars == nil {
+		vars = make(map[string]string)
+	}
+
+	return &TemplateData{
+		OS:             config.GetCurrentOS(),
+		Hostname:       hostname,
+		Username:       username,
+		Home:           config.Home(),
+		GithubUsername: config.CurrentConfig.GithubUsername,
+		Vars:           vars,
+		Facts:          config.CurrentFacts(),
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	"env":   os.Getenv,
+	"lower": strings.ToLower,
+	"upper": strings.ToUpper,
+}
+
+// RenderTemplate executes the template at path. Referencing a variable that
+// is not defined is an error rather than an empty string.
+func RenderTemplate(path string, data *TemplateData) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return executeTemplate(path, content, data)
+}
+
+func executeTemplate(path string, content []byte, data *TemplateData) ([]byte, error) {
+	t, err := template.New(filepath.Base(path)).Funcs(templateFuncs).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// RenderState remembers a hash of every file apply rendered, so a rendered
+// file that was edited by hand can be told apart from one that is merely
+// out of date. It also remembers the directories apply created, the only
+// ones unapply removes once they are empty.
+type RenderState struct {
+	Version int               `json:"version"`
+	Files   map[string]string `json:"files"`
+	Dirs    map[string]bool   `json:"dirs,omitempty"`
+
+	path string
+	fs   vfs.FS
+}
+
+// RenderStateFile is kept per machine, next to the dfmgr configuration.
+func RenderStateFile() string {
+	return filepath.Join(config.Home(), ".dfmgr_rendered.json")
+}
+
+func LoadRenderState(path string) (*RenderState, error) {
+	s := &RenderState{Version: 1, Files: make(map[string]string), Dirs: make(map[string]bool), path: path}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", path, err)
+	}
+	if s.Files == nil {
+		s.Files = make(map[string]string)
+	}
+	if s.Dirs == nil {
+		s.Dirs = make(map[string]bool)
+	}
+	return s, nil
+}
+
+func (s *RenderState) Save() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	return vfs.Or(s.fs).WriteFile(s.path, append(data, '\n'), 0644)
+}
+
+// Edited reports whether the file at target differs from what apply last
+// rendered there. Files apply never rendered count as edited.
+func (s *RenderState) Edited(target string, current []byte) bool {
+	recorded, ok := s.Files[target]
+	return !ok || recorded != hashContent(current)
+}
+
+// Record remembers content as what was rendered to target.
+func (s *RenderState) Record(target string, content []byte) {
+	s.Files[target] = hashContent(content)
+}
+
+func hashContent(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// IsTemplate reports whether the package file at rel, relative to the
+// package directory, is rendered rather than linked: either it has the
+// template suffix or it matches one of the configured patterns.
+func (l *Linker) IsTemplate(rel string) bool {
+	if strings.HasSuffix(rel, TemplateSuffix) {
+		return true
+	}
+	for _, pattern := range l.Templates {
+		if matched, _ := filepath.Match(pattern, filepath.ToSlash(rel)); matched {
+			return true
+		}
+		if matched, _ := filepath.Match(pattern, filepath.Base(rel)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// IsSecret reports whether the package file at rel is encrypted and is
+// decrypted rather than linked.
+func (l *Linker) IsSecret(rel string) bool {
+	return strings.HasSuffix(rel, secret.Suffix)
+}
+
+// Render returns the content apply writes for the package file src: the
+// rendered template, or the decrypted secret.
+func (l *Linker) Render(src string) ([]byte, error) {
+	data, err := l.filesystem().ReadFile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	if l.IsSecret(src) {
+		if l.Secrets == nil {
+			return nil, secret.ErrNoKey
+		}
+		return l.Secrets.Decrypt(data)
+	}
+
+	if l.TemplateData == nil {
+		l.TemplateData = NewTemplateData()
+	}
+	return executeTemplate(src, data, l.TemplateData)
+}
+
+func (l *Linker) renderState() *RenderState {
+	if l.Rendered == nil {
+		l.Rendered = &RenderState{Version: 1, Files: make(map[string]string), Dirs: make(map[string]bool), path: RenderStateFile(), fs: l.FS}
+	}
+	return l.Rendered
+}
+
+// writeRendered renders src to target through a temporary file and records
+// the result. Rendered templates keep the permissions of the template,
+// decrypted secrets are only readable by the owner.
+func (l *Linker) writeRendered(src, target string) error {
+	content, err := l.Render(src)
+	if err != nil {
+		return fmt.Errorf("failed to render %s: %w", src, err)
+	}
+
+	fsys := l.filesystem()
+	info, err := fsys.Stat(src)
+	if err != nil {
+		return err
+	}
+	mode := info.Mode().Perm()
+	if l.IsSecret(src) {
+		mode = 0600
+	}
+
+	tmp := filepath.Join(filepath.Dir(target), "."+filepath.Base(target)+".dfmgr-render")
+	fsys.Remove(tmp)
+	if err := fsys.WriteFile(tmp, content, 0600); err != nil {
+		return err
+	}
+	defer fsys.Remove(tmp)
+
+	if err := fsys.Chmod(tmp, mode); err != nil {
+		return err
+	}
+	if err := fsys.Rename(tmp, target); err != nil {
+		return fmt.Errorf("failed to write %s: %w", target, err)
+	}
+
+	l.renderState().Record(target, content)
+	return nil
+}
+
+// TemplateFiles returns the templates and secrets of packages, as paths
+// relative to the source directory.
+func (l *Linker) TemplateFiles(packages []string) []string {
+	fsys := l.filesystem()
+	var files []string
+	for _, pkg := range packages {
+		pkgDir := filepath.Join(l.SourceDir, pkg)
+		vfs.WalkDir(fsys, pkgDir, func(path string, d os.DirEntry, err error) error {
+			if err != nil {
+				return filepath.SkipDir
+			}
+			if path != pkgDir && isIgnored(d.Name(), l.Ignore, l.PackageIgnore[pkg]) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if rel, err := filepath.Rel(pkgDir, path); err == nil && d.Type().IsRegular() && (l.IsTemplate(rel) || l.IsSecret(rel)) {
+				files = append(files, filepath.Join(pkg, rel))
+			}
+			return nil
+		})
+	}
+	return files
+}
+
+// containsTemplate reports whether any file below the package directory dir
+// is a template or secret. Such directories cannot be folded into a single
+// link, or the rendered files would end up inside the repository.
+func (p *planner) containsTemplate(pkg, dir string) bool {
+	pkgDir := filepath.Join(p.source, pkg)
+	found := false
+	vfs.WalkDir(p.fs, dir, func(path string, d os.DirEntry, err error) error {
+		if err != nil || found {
+			return filepath.SkipDir
+		}
+		if path != dir && p.ignored(pkg, d.Name()) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.Type().IsRegular() {
+			if rel, err := filepath.Rel(pkgDir, path); err == nil && (p.linker.IsTemplate(rel) || p.linker.IsSecret(rel)) {
+				found = true
+			}
+		}
+		return nil
+	})
+	return found
+}
+
+// renderEntry plans the rendering of the template src to dst. Files apply
+// rendered before are overwritten when they were not edited by hand, and
+// are conflicts when they were unless ReplaceEdited is set; any other file
+// in the way is treated like a conflicting file when linking.
+func (p *planner) renderEntry(pkg, src, dst string) {
+	action := Action{Type: ActionRender, Package: pkg, Target: dst, Source: src}
+	if p.linker.IsSecret(src) {
+		action.Type = ActionDecrypt
+	}
+
+	content, err := p.linker.Render(src)
+	if errors.Is(err, secret.ErrNoKey) {
+		p.plan.Skipped = append(p.plan.Skipped, Conflict{Package: pkg, Target: dst, Source: src, Reason: err.Error()})
+		return
+	}
+	if err != nil {
+		p.conflict(pkg, dst, src, fmt.Sprintf("cannot render: %s", err))
+		return
+	}
+
+	existing, ok := p.claim(pkg, src, dst, p.lookup(dst), false)
+	if !ok {
+		return
+	}
+	switch existing.kind {
+	case entryNone:
+		p.add(action)
+		return
+	case entryFile:
+		current, err := p.fs.ReadFile(dst)
+		if err == nil && bytes.Equal(current, content) {
+			p.inPlace(action)
+			return
+		}
+		if err == nil && !p.linker.renderState().Edited(dst, current) {
+			p.add(action)
+			return
+		}
+		if p.linker.renderState().Files[dst] != "" && !p.linker.ReplaceEdited {
+			p.conflict(pkg, dst, src, "rendered file was edited by hand, apply with --overwrite-edited to replace it")
+			return
+		}
+	case entryLink:
+		if p.providedAbove(pkg, existing.source) {
+			return
+		}
+		if p.owns(existing.source) {
+			p.add(Action{Type: ActionUnlink, Package: pkg, Target: dst, Source: existing.source})
+			p.add(action)
+			return
+		}
+	case entryDir:
+		p.conflict(pkg, dst, src, "existing directory is in the way")
+		return
+	}
+
+	if !p.linker.Replace {
+		p.conflict(pkg, dst, src, "existing file is in the way")
+		return
+	}
+	p.add(Action{Type: ActionBackup, Package: pkg, Target: dst})
+	p.add(Action{Type: ActionRemove, Package: pkg, Target: dst})
+	p.add(action)
+}
+
+// renderedInPlace reports whether dst is a file apply rendered that was not
+// edited by hand since.
+func (p *planner) renderedInPlace(dst string) bool {
+	if p.linker.renderState().Files[dst] == "" {
+		return false
+	}
+	current, err := p.fs.ReadFile(dst)
+	return err == nil && !p.linker.renderState().Edited(dst, current)
+}
+
+// unrenderEntry plans the removal of a rendered file, unless it was edited
+// by hand since apply wrote it.
+func (p *planner) unrenderEntry(pkg, src, dst string) {
+	if p.lookup(dst).kind != entryFile {
+		return
+	}
+	current, err := p.fs.ReadFile(dst)
+	if err != nil || p.linker.renderState().Edited(dst, current) {
+		return
+	}
+	p.add(Action{Type: ActionRemove, Package: pkg, Target: dst, Source: src})
+}
+
+// renderedName returns the name a template or secret is written to.
+func renderedName(name string) string {
+	return strings.TrimSuffix(strings.TrimSuffix(name, secret.Suffix), TemplateSuffix)
+}
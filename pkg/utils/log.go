@@ -0,0 +1,229 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Level is how much the logger reports, from errors only to the output of
+// every command dfmgr runs.
+type Level int
+
+const (
+	LevelError Level = iota
+	LevelWarning
+	LevelInfo
+	LevelDebug
+	LevelTrace
+)
+
+// Message is a line logged while running a command.
+type Message struct {
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+// Action is a change dfmgr made, such as a link it created or a commit it
+// pushed.
+type Action struct {
+	Action string `json:"action"`
+	Target string `json:"target"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// CommandOutput is the captured output of an external command, such as git
+// or stow.
+type CommandOutput struct {
+	Command string `json:"command"`
+	Output  string `json:"output"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Result is the document printed with --output json once a command is
+// done, describing everything it did.
+type Result struct {
+	Command  string          `json:"command"`
+	Success  bool            `json:"success"`
+	Actions  []Action        `json:"actions"`
+	Warnings []string        `json:"warnings"`
+	Errors   []string        `json:"errors"`
+	Messages []Message       `json:"messages"`
+	Commands []CommandOutput `json:"commands"`
+	Data     interface{}     `json:"data,omitempty"`
+}
+
+// Logger prints messages at or below Level to Writer. With JSON set it
+// prints nothing and collects the messages, actions and command output
+// into a Result instead, written to stdout by Flush.
+type Logger struct {
+	Level  Level
+	JSON   bool
+	Writer io.Writer
+
+	mu      sync.Mutex
+	result  Result
+	flushed bool
+}
+
+// Log is the logger behind Info, Success, Warning, Error and the other
+// package functions.
+var Log = &Logger{Level: LevelInfo, Writer: os.Stderr}
+
+func (l *Logger) logf(level Level, name, tag string, format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch level {
+	case LevelError:
+		l.result.Errors = append(l.result.Errors, message)
+	case LevelWarning:
+		l.result.Warnings = append(l.result.Warnings, message)
+	case LevelInfo:
+		l.result.Messages = append(l.result.Messages, Message{Level: name, Message: message})
+	}
+
+	if !l.JSON && level <= l.Level {
+		fmt.Fprintf(l.Writer, "%s %s\n", tag, message)
+	}
+}
+
+// Begin starts the result of command.
+func (l *Logger) Begin(command string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.result.Command = command
+}
+
+// Record notes an action in the result, and prints it at LevelDebug.
+func (l *Logger) Record(action, target, detail string) {
+	l.mu.Lock()
+	l.result.Actions = append(l.result.Actions, Action{Action: action, Target: target, Detail: detail})
+	l.mu.Unlock()
+
+	if detail != "" {
+		l.logf(LevelDebug, "debug", DebugColor("[DEBUG]"), "%s %s (%s)", action, target, detail)
+	} else {
+		l.logf(LevelDebug, "debug", DebugColor("[DEBUG]"), "%s %s", action, target)
+	}
+}
+
+// Actions returns the actions recorded so far.
+func (l *Logger) Actions() []Action {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]Action{}, l.result.Actions...)
+}
+
+// SetData attaches the data a command reports, such as a plan or a status,
+// to the result.
+func (l *Logger) SetData(data interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.result.Data = data
+}
+
+// Run runs cmd with its output captured instead of streamed, attaching it
+// to the result. The output is printed at LevelTrace, and becomes part of
+// the error when cmd fails.
+func (l *Logger) Run(cmd *exec.Cmd) error {
+	args := make([]string, len(cmd.Args))
+	for i, arg := range cmd.Args {
+		args[i] = redact(arg)
+	}
+	line := strings.Join(args, " ")
+	l.logf(LevelTrace, "trace", DebugColor("[TRACE]"), "running %s", line)
+
+	output, err := DefaultRunner.CombinedOutput(cmd)
+
+	captured := CommandOutput{Command: line, Output: string(output)}
+	if err != nil {
+		captured.Error = err.Error()
+	}
+	l.mu.Lock()
+	l.result.Commands = append(l.result.Commands, captured)
+	l.mu.Unlock()
+
+	text := strings.TrimSpace(string(output))
+	if text != "" {
+		l.logf(LevelTrace, "trace", DebugColor("[TRACE]"), "%s", text)
+	}
+	if err != nil && text != "" {
+		return fmt.Errorf("%w: %s", err, text)
+	}
+	return err
+}
+
+// redact hides credentials passed on the command line, such as the token
+// in an http.extraHeader option.
+func redact(arg string) string {
+	if i := strings.Index(arg, "Authorization:"); i >= 0 {
+		return arg[:i] + "Authorization: <redacted>"
+	}
+	return arg
+}
+
+// Flush writes the result to stdout with JSON set. Only the first call
+// writes anything.
+func (l *Logger) Flush(success bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if !l.JSON || l.flushed {
+		return
+	}
+	l.flushed = true
+	l.result.Success = success && len(l.result.Errors) == 0
+
+	// Empty lists rather than null keep the document easy to consume.
+	if l.result.Actions == nil {
+		l.result.Actions = []Action{}
+	}
+	if l.result.Warnings == nil {
+		l.result.Warnings = []string{}
+	}
+	if l.result.Errors == nil {
+		l.result.Errors = []string{}
+	}
+	if l.result.Messages == nil {
+		l.result.Messages = []Message{}
+	}
+	if l.result.Commands == nil {
+		l.result.Commands = []CommandOutput{}
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	encoder.Encode(l.result)
+}
+
+// Record notes an action dfmgr took in the result.
+func Record(action, target, detail string) {
+	Log.Record(action, target, detail)
+}
+
+// RunCommand runs cmd with its output captured by the logger.
+func RunCommand(cmd *exec.Cmd) error {
+	return Log.Run(cmd)
+}
+
+// JSONOutput reports whether commands report through a result document
+// rather than printing to stdout.
+func JSONOutput() bool {
+	return Log.JSON
+}
+
+// SetData attaches the data a command reports to the result.
+func SetData(data interface{}) {
+	Log.SetData(data)
+}
+
+// Exit flushes the result and exits with code, for commands that fail.
+func Exit(code int) {
+	Log.Flush(code == 0)
+	os.Exit(code)
+}
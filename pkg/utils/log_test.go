@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+type fakeRunner struct {
+	output string
+	err    error
+}
+
+func (r fakeRunner) CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	return []byte(r.output), r.err
+}
+
+func (r fakeRunner) Output(cmd *exec.Cmd) ([]byte, error) {
+	return []byte(r.output), r.err
+}
+
+func useRunner(t *testing.T, r Runner) {
+	t.Helper()
+	runner := DefaultRunner
+	DefaultRunner = r
+	t.Cleanup(func() { DefaultRunner = runner })
+}
+
+func TestRunCapturesOutput(t *testing.T) {
+	useRunner(t, fakeRunner{output: "Cloning into 'dotfiles'...\n"})
+	var out bytes.Buffer
+	l := &Logger{Level: LevelTrace, Writer: &out}
+
+	cmd := exec.Command("git", "-c", "http.extraHeader=Authorization: Basic c2VjcmV0", "clone", "url")
+	if err := l.Run(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(l.result.Commands) != 1 {
+		t.Fatalf("captured %d commands, want 1", len(l.result.Commands))
+	}
+	captured := l.result.Commands[0]
+	if strings.Contains(captured.Command, "c2VjcmV0") || !strings.Contains(captured.Command, "Authorization: <redacted>") {
+		t.Errorf("credentials not redacted: %q", captured.Command)
+	}
+	if captured.Output != "Cloning into 'dotfiles'...\n" {
+		t.Errorf("Output = %q", captured.Output)
+	}
+	if !strings.Contains(out.String(), "Cloning into 'dotfiles'...") {
+		t.Errorf("output not traced: %q", out.String())
+	}
+}
+
+func TestRunErrorIncludesOutput(t *testing.T) {
+	failure := errors.New("exit status 128")
+	useRunner(t, fakeRunner{output: "fatal: repository not found\n", err: failure})
+	l := &Logger{Level: LevelError, Writer: &bytes.Buffer{}}
+
+	err := l.Run(exec.Command("git", "clone", "url"))
+	if !errors.Is(err, failure) || !strings.Contains(err.Error(), "repository not found") {
+		t.Errorf("Run = %v", err)
+	}
+	if l.result.Commands[0].Error != "exit status 128" {
+		t.Errorf("Error = %q", l.result.Commands[0].Error)
+	}
+}
+
+func TestExecuteCommandUsesRunner(t *testing.T) {
+	useRunner(t, fakeRunner{output: "stow (GNU Stow) version 2.3.1\n"})
+	output, err := ExecuteCommand("stow", "--version")
+	if err != nil || output != "stow (GNU Stow) version 2.3.1\n" {
+		t.Errorf("ExecuteCommand = %q, %v", output, err)
+	}
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/manifoldco/promptui"
+	"github.com/mattn/go-isatty"
+)
+
+var (
+	// AssumeYes answers every confirmation with yes and never prompts. It
+	// is set by the global --yes flag.
+	AssumeYes bool
+	// NonInteractive never prompts: values come from flags or defaults,
+	// and confirmations fail unless AssumeYes is set. It is set by the
+	// global --non-interactive flag.
+	NonInteractive bool
+
+	// PromptOutput receives prompts and menus. It is stderr, so that
+	// stdout only holds the output of the command, such as the result
+	// document of --output json.
+	PromptOutput io.WriteCloser = os.Stderr
+)
+
+// Interactive reports whether dfmgr may prompt, which needs a terminal on
+// stdin and neither --yes, --non-interactive nor --output json.
+func Interactive() bool {
+	if AssumeYes || NonInteractive || Log.JSON {
+		return false
+	}
+	return isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+}
+
+// Confirm asks a yes/no question. Without a terminal the answer is yes with
+// --yes, and an error otherwise so that scripts opt in explicitly.
+func Confirm(label string) (bool, error) {
+	if !Interactive() {
+		if AssumeYes {
+			return true, nil
+		}
+		return false, fmt.Errorf("cannot confirm %q without a terminal, pass --yes", label)
+	}
+
+	prompt := promptui.Prompt{
+		Label:     label,
+		IsConfirm: true,
+		Stdout:    PromptOutput,
+	}
+	result, err := prompt.Run()
+	return err == nil && strings.ToLower(result) == "y", nil
+}
+
+// Prompt asks for a value, suggesting def. Without a terminal def is used,
+// and an empty def is an error naming flag, the way to pass the value.
+func Prompt(label, def, flag string, validate func(string) error) (string, error) {
+	if !Interactive() {
+		if def == "" {
+			return "", fmt.Errorf("cannot answer %q without a terminal, pass %s", label, flag)
+		}
+		if validate != nil {
+			if err := validate(def); err != nil {
+				return "", fmt.Errorf("invalid %s: %w", flag, err)
+			}
+		}
+		return def, nil
+	}
+
+	prompt := promptui.Prompt{
+		Label:    label,
+		Default:  def,
+		Validate: validate,
+		Stdout:   PromptOutput,
+	}
+	return prompt.Run()
+}
+
+// Select asks to choose one of items and returns its index. Without a
+// terminal def is used, and a negative def is an error naming flag.
+func Select(label string, items []string, def int, flag string) (int, error) {
+	if !Interactive() {
+		if def < 0 {
+			return -1, fmt.Errorf("cannot answer %q without a terminal, pass %s", label, flag)
+		}
+		return def, nil
+	}
+
+	prompt := promptui.Select{
+		Label:  label,
+		Items:  items,
+		Stdout: PromptOutput,
+	}
+	if def > 0 {
+		prompt.CursorPos = def
+	}
+	index, _, err := prompt.Run()
+	return index, err
+}
@@ -0,0 +1,19 @@
+package utils
+
+import "testing"
+
+func TestJSONOutputNeverPrompts(t *testing.T) {
+	json := Log.JSON
+	Log.JSON = true
+	t.Cleanup(func() { Log.JSON = json })
+
+	if Interactive() {
+		t.Fatal("Interactive with --output json")
+	}
+	if value, err := Prompt("Name", "dotfiles", "--name", nil); err != nil || value != "dotfiles" {
+		t.Errorf("Prompt = %q, %v, want the default", value, err)
+	}
+	if _, err := Confirm("Overwrite"); err == nil {
+		t.Error("Confirm answered without --yes")
+	}
+}
@@ -0,0 +1,32 @@
+package utils
+
+import "os/exec"
+
+// Runner runs the external commands dfmgr depends on, such as git, stow and
+// gh. Tests replace DefaultRunner to record or fake them.
+type Runner interface {
+	// CombinedOutput runs cmd and returns its standard output and standard
+	// error together.
+	CombinedOutput(cmd *exec.Cmd) ([]byte, error)
+	// Output runs cmd and returns its standard output.
+	Output(cmd *exec.Cmd) ([]byte, error)
+}
+
+// ExecRunner runs commands for real with os/exec.
+type ExecRunner struct{}
+
+func (ExecRunner) CombinedOutput(cmd *exec.Cmd) ([]byte, error) {
+	return cmd.CombinedOutput()
+}
+
+func (ExecRunner) Output(cmd *exec.Cmd) ([]byte, error) {
+	return cmd.Output()
+}
+
+// DefaultRunner runs every external command of dfmgr.
+var DefaultRunner Runner = ExecRunner{}
+
+// OutputOf runs cmd with DefaultRunner and returns its standard output.
+func OutputOf(cmd *exec.Cmd) ([]byte, error) {
+	return DefaultRunner.Output(cmd)
+}
@@ -1,12 +1,12 @@
 package utils
 
 import (
-	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/cetincetindag/dfmgr/pkg/config"
 	"github.com/fatih/color"
 )
 
@@ -15,27 +15,32 @@ var (
 	SuccessColor = color.New(color.FgGreen).SprintFunc()
 	WarningColor = color.New(color.FgYellow).SprintFunc()
 	ErrorColor   = color.New(color.FgRed).SprintFunc()
+	DebugColor   = color.New(color.FgHiBlack).SprintFunc()
 )
 
 func Info(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%s %s\n", InfoColor("[INFO]"), fmt.Sprintf(format, args...))
+	Log.logf(LevelInfo, "info", InfoColor("[INFO]"), format, args...)
 }
 
 func Success(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%s %s\n", SuccessColor("[SUCCESS]"), fmt.Sprintf(format, args...))
+	Log.logf(LevelInfo, "success", SuccessColor("[SUCCESS]"), format, args...)
 }
 
 func Warning(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%s %s\n", WarningColor("[WARNING]"), fmt.Sprintf(format, args...))
+	Log.logf(LevelWarning, "warning", WarningColor("[WARNING]"), format, args...)
 }
 
 func Error(format string, args ...interface{}) {
-	fmt.Fprintf(os.Stderr, "%s %s\n", ErrorColor("[ERROR]"), fmt.Sprintf(format, args...))
+	Log.logf(LevelError, "error", ErrorColor("[ERROR]"), format, args...)
+}
+
+// Debug prints details shown with -v.
+func Debug(format string, args ...interface{}) {
+	Log.logf(LevelDebug, "debug", DebugColor("[DEBUG]"), format, args...)
 }
 
 func ExecuteCommand(name string, args ...string) (string, error) {
-	cmd := exec.Command(name, args...)
-	output, err := cmd.CombinedOutput()
+	output, err := DefaultRunner.CombinedOutput(exec.Command(name, args...))
 	return string(output), err
 }
 
@@ -55,7 +60,7 @@ func IsGitRepo(path string) bool {
 
 func FindConfigFiles(patterns []string) (map[string]string, error) {
 	results := make(map[string]string)
-	home := os.Getenv("HOME")
+	home := config.Home()
 
 	for _, pattern := range patterns {
 		if !strings.HasPrefix(pattern, "/") {
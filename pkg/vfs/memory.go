@@ -0,0 +1,381 @@
+package vfs
+
+import (
+	"io/fs"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// maxLinks bounds how many symlinks are followed while resolving a path,
+// like the ELOOP limit of the kernel.
+const maxLinks = 40
+
+// Memory is an in-memory filesystem with directories, regular files and
+// symlinks, for tests that must not touch the disk.
+type Memory struct {
+	mu    sync.Mutex
+	nodes map[string]*node
+}
+
+type node struct {
+	mode    fs.FileMode
+	data    []byte
+	link    string
+	modTime time.Time
+}
+
+// NewMemory returns an empty filesystem holding only the root directory.
+func NewMemory() *Memory {
+	return &Memory{nodes: map[string]*node{
+		"/": {mode: fs.ModeDir | 0755, modTime: time.Now()},
+	}}
+}
+
+func pathError(op, name string, err error) error {
+	return &fs.PathError{Op: op, Path: name, Err: err}
+}
+
+// resolve returns the path name refers to once the symlinks among its
+// parents, and the last element too with follow set, are followed. The
+// returned path itself need not exist.
+func (m *Memory) resolve(op, name string, follow bool, depth int) (string, error) {
+	if depth > maxLinks {
+		return "", pathError(op, name, syscall.ELOOP)
+	}
+	if !filepath.IsAbs(name) {
+		return "", pathError(op, name, fs.ErrInvalid)
+	}
+
+	current := "/"
+	parts := strings.Split(strings.TrimPrefix(filepath.Clean(name), "/"), "/")
+	for i, part := range parts {
+		if part == "" {
+			continue
+		}
+		if n := m.nodes[current]; n == nil {
+			return "", pathError(op, name, fs.ErrNotExist)
+		} else if !n.mode.IsDir() {
+			return "", pathError(op, name, syscall.ENOTDIR)
+		}
+
+		next := filepath.Join(current, part)
+		n := m.nodes[next]
+		last := i == len(parts)-1
+		if n == nil || n.mode&fs.ModeSymlink == 0 || (last && !follow) {
+			current = next
+			continue
+		}
+
+		target := n.link
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(current, target)
+		}
+		resolved, err := m.resolve(op, target, true, depth+1)
+		if err != nil {
+			return "", err
+		}
+		current = resolved
+	}
+	return current, nil
+}
+
+// parent makes sure the directory path is to be created in exists.
+func (m *Memory) parent(op, name, path string) error {
+	dir := m.nodes[filepath.Dir(path)]
+	if dir == nil {
+		return pathError(op, name, fs.ErrNotExist)
+	}
+	if !dir.mode.IsDir() {
+		return pathError(op, name, syscall.ENOTDIR)
+	}
+	return nil
+}
+
+func (m *Memory) lookup(op, name string, follow bool) (string, *node, error) {
+	path, err := m.resolve(op, name, follow, 0)
+	if err != nil {
+		return "", nil, err
+	}
+	n := m.nodes[path]
+	if n == nil {
+		return "", nil, pathError(op, name, fs.ErrNotExist)
+	}
+	return path, n, nil
+}
+
+func (m *Memory) Stat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, n, err := m.lookup("stat", name, true)
+	if err != nil {
+		return nil, err
+	}
+	return info(path, n), nil
+}
+
+func (m *Memory) Lstat(name string) (fs.FileInfo, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, n, err := m.lookup("lstat", name, false)
+	if err != nil {
+		return nil, err
+	}
+	return info(path, n), nil
+}
+
+func (m *Memory) ReadDir(name string) ([]fs.DirEntry, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, n, err := m.lookup("readdir", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if !n.mode.IsDir() {
+		return nil, pathError("readdir", name, syscall.ENOTDIR)
+	}
+
+	var entries []fs.DirEntry
+	for _, child := range m.children(path) {
+		entries = append(entries, fs.FileInfoToDirEntry(info(child, m.nodes[child])))
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Name() < entries[j].Name()
+	})
+	return entries, nil
+}
+
+func (m *Memory) children(dir string) []string {
+	var children []string
+	for path := range m.nodes {
+		if path != dir && filepath.Dir(path) == dir {
+			children = append(children, path)
+		}
+	}
+	return children
+}
+
+func (m *Memory) ReadFile(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("open", name, true)
+	if err != nil {
+		return nil, err
+	}
+	if n.mode.IsDir() {
+		return nil, pathError("read", name, syscall.EISDIR)
+	}
+	return append([]byte{}, n.data...), nil
+}
+
+// WriteFile creates or truncates name. Like os.WriteFile, perm only applies
+// to new files.
+func (m *Memory) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, err := m.resolve("open", name, true, 0)
+	if err != nil {
+		return err
+	}
+	if n := m.nodes[path]; n != nil {
+		if n.mode.IsDir() {
+			return pathError("open", name, syscall.EISDIR)
+		}
+		n.data = append([]byte{}, data...)
+		n.modTime = time.Now()
+		return nil
+	}
+	if err := m.parent("open", name, path); err != nil {
+		return err
+	}
+	m.nodes[path] = &node{mode: perm.Perm(), data: append([]byte{}, data...), modTime: time.Now()}
+	return nil
+}
+
+func (m *Memory) Mkdir(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.mkdir(name, perm)
+}
+
+func (m *Memory) mkdir(name string, perm fs.FileMode) error {
+	path, err := m.resolve("mkdir", name, false, 0)
+	if err != nil {
+		return err
+	}
+	if m.nodes[path] != nil {
+		return pathError("mkdir", name, fs.ErrExist)
+	}
+	if err := m.parent("mkdir", name, path); err != nil {
+		return err
+	}
+	m.nodes[path] = &node{mode: fs.ModeDir | perm.Perm(), modTime: time.Now()}
+	return nil
+}
+
+func (m *Memory) MkdirAll(name string, perm fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	dir := "/"
+	for _, part := range strings.Split(strings.TrimPrefix(filepath.Clean(name), "/"), "/") {
+		if part == "" {
+			continue
+		}
+		dir = filepath.Join(dir, part)
+		path, err := m.resolve("mkdir", dir, true, 0)
+		if err != nil {
+			return err
+		}
+		if n := m.nodes[path]; n != nil {
+			if !n.mode.IsDir() {
+				return pathError("mkdir", dir, syscall.ENOTDIR)
+			}
+			continue
+		}
+		if err := m.mkdir(dir, perm); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *Memory) Remove(name string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, n, err := m.lookup("remove", name, false)
+	if err != nil {
+		return err
+	}
+	if n.mode.IsDir() && len(m.children(path)) > 0 {
+		return pathError("remove", name, syscall.ENOTEMPTY)
+	}
+	delete(m.nodes, path)
+	return nil
+}
+
+// Rename moves oldpath, and everything below it for a directory, to
+// newpath, replacing a file or empty directory there.
+func (m *Memory) Rename(oldpath, newpath string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	from, _, err := m.lookup("rename", oldpath, false)
+	if err != nil {
+		return err
+	}
+	to, err := m.resolve("rename", newpath, false, 0)
+	if err != nil {
+		return err
+	}
+	if err := m.parent("rename", newpath, to); err != nil {
+		return err
+	}
+	if n := m.nodes[to]; n != nil && n.mode.IsDir() && len(m.children(to)) > 0 {
+		return pathError("rename", newpath, syscall.ENOTEMPTY)
+	}
+	if from == to {
+		return nil
+	}
+
+	moved := make(map[string]*node)
+	for path, n := range m.nodes {
+		if path == from || strings.HasPrefix(path, from+"/") {
+			moved[to+strings.TrimPrefix(path, from)] = n
+			delete(m.nodes, path)
+		}
+	}
+	for path, n := range moved {
+		m.nodes[path] = n
+	}
+	return nil
+}
+
+func (m *Memory) Symlink(oldname, newname string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, err := m.resolve("symlink", newname, false, 0)
+	if err != nil {
+		return err
+	}
+	if m.nodes[path] != nil {
+		return pathError("symlink", newname, fs.ErrExist)
+	}
+	if err := m.parent("symlink", newname, path); err != nil {
+		return err
+	}
+	m.nodes[path] = &node{mode: fs.ModeSymlink | 0777, link: oldname, modTime: time.Now()}
+	return nil
+}
+
+func (m *Memory) Readlink(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("readlink", name, false)
+	if err != nil {
+		return "", err
+	}
+	if n.mode&fs.ModeSymlink == 0 {
+		return "", pathError("readlink", name, fs.ErrInvalid)
+	}
+	return n.link, nil
+}
+
+func (m *Memory) Chmod(name string, mode fs.FileMode) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("chmod", name, true)
+	if err != nil {
+		return err
+	}
+	n.mode = n.mode.Type() | mode.Perm()
+	return nil
+}
+
+func (m *Memory) Chtimes(name string, atime, mtime time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, n, err := m.lookup("chtimes", name, true)
+	if err != nil {
+		return err
+	}
+	n.modTime = mtime
+	return nil
+}
+
+// Lchown only checks that name exists; the memory filesystem has no owners.
+func (m *Memory) Lchown(name string, uid, gid int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	_, _, err := m.lookup("lchown", name, false)
+	return err
+}
+
+func (m *Memory) EvalSymlinks(name string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	path, _, err := m.lookup("lstat", name, true)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+// fileInfo describes a node of a Memory filesystem.
+type fileInfo struct {
+	name string
+	node node
+}
+
+func info(path string, n *node) fs.FileInfo {
+	return &fileInfo{name: filepath.Base(path), node: *n}
+}
+
+func (i *fileInfo) Name() string       { return i.name }
+func (i *fileInfo) Size() int64        { return int64(len(i.node.data)) }
+func (i *fileInfo) Mode() fs.FileMode  { return i.node.mode }
+func (i *fileInfo) ModTime() time.Time { return i.node.modTime }
+func (i *fileInfo) IsDir() bool        { return i.node.mode.IsDir() }
+func (i *fileInfo) Sys() interface{}   { return nil }
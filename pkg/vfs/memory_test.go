@@ -0,0 +1,123 @@
+package vfs
+
+import (
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestMemoryFiles(t *testing.T) {
+	m := NewMemory()
+	if err := m.MkdirAll("/home/user/.config", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.WriteFile("/home/user/.bashrc", []byte("alias ll='ls -l'\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := m.ReadFile("/home/user/.bashrc")
+	if err != nil || string(data) != "alias ll='ls -l'\n" {
+		t.Fatalf("ReadFile = %q, %v", data, err)
+	}
+	info, err := m.Stat("/home/user/.bashrc")
+	if err != nil || info.Mode() != 0644 || info.Size() != int64(len(data)) {
+		t.Fatalf("Stat = %v, %v", info, err)
+	}
+
+	if err := m.WriteFile("/home/missing/file", nil, 0644); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("WriteFile without parent: %v, want ErrNotExist", err)
+	}
+	if err := m.Mkdir("/home/user", 0755); !errors.Is(err, fs.ErrExist) {
+		t.Errorf("Mkdir of existing directory: %v, want ErrExist", err)
+	}
+	if err := m.Remove("/home/user"); err == nil {
+		t.Error("Remove of a non-empty directory succeeded")
+	}
+}
+
+func TestMemorySymlinks(t *testing.T) {
+	m := NewMemory()
+	m.MkdirAll("/dotfiles/nvim/.config/nvim", 0755)
+	m.WriteFile("/dotfiles/nvim/.config/nvim/init.lua", []byte("-- init"), 0644)
+	m.MkdirAll("/home/.config", 0755)
+
+	if err := m.Symlink("../../dotfiles/nvim/.config/nvim", "/home/.config/nvim"); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := m.ReadFile("/home/.config/nvim/init.lua")
+	if err != nil || string(data) != "-- init" {
+		t.Fatalf("ReadFile through link = %q, %v", data, err)
+	}
+	info, err := m.Lstat("/home/.config/nvim")
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		t.Fatalf("Lstat = %v, %v, want a symlink", info, err)
+	}
+	if link, _ := m.Readlink("/home/.config/nvim"); link != "../../dotfiles/nvim/.config/nvim" {
+		t.Errorf("Readlink = %q", link)
+	}
+	if path, err := m.EvalSymlinks("/home/.config/nvim/init.lua"); err != nil || path != "/dotfiles/nvim/.config/nvim/init.lua" {
+		t.Errorf("EvalSymlinks = %q, %v", path, err)
+	}
+
+	// Removing the link leaves its target alone.
+	if err := m.Remove("/home/.config/nvim"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("/dotfiles/nvim/.config/nvim/init.lua"); err != nil {
+		t.Errorf("target removed with the link: %v", err)
+	}
+
+	m.Symlink("/loop/b", "/loop-a")
+	m.Symlink("/loop-a", "/loop")
+	if _, err := m.Stat("/loop"); err == nil {
+		t.Error("Stat of a symlink loop succeeded")
+	}
+}
+
+func TestMemoryRename(t *testing.T) {
+	m := NewMemory()
+	m.MkdirAll("/a/b", 0755)
+	m.WriteFile("/a/b/c", []byte("c"), 0600)
+
+	if err := m.Rename("/a", "/z"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Stat("/a"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("old path still exists: %v", err)
+	}
+	if data, err := m.ReadFile("/z/b/c"); err != nil || string(data) != "c" {
+		t.Errorf("ReadFile after rename = %q, %v", data, err)
+	}
+}
+
+func TestWalkDir(t *testing.T) {
+	m := NewMemory()
+	m.MkdirAll("/root/b/skip", 0755)
+	m.MkdirAll("/root/a", 0755)
+	m.WriteFile("/root/a/file", nil, 0644)
+	m.WriteFile("/root/b/skip/hidden", nil, 0644)
+	m.WriteFile("/root/c", nil, 0644)
+
+	var visited []string
+	err := WalkDir(m, "/root", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Name() == "skip" {
+			return filepath.SkipDir
+		}
+		visited = append(visited, path)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"/root", "/root/a", "/root/a/file", "/root/b", "/root/c"}
+	if !reflect.DeepEqual(visited, want) {
+		t.Errorf("visited %v, want %v", visited, want)
+	}
+}
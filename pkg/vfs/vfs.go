@@ -0,0 +1,111 @@
+// Package vfs abstracts the filesystem calls the linker and backups make,
+// so they can run against the real filesystem or an in-memory one.
+package vfs
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FS is the subset of the os package dfmgr needs to change a home
+// directory. Paths are absolute.
+type FS interface {
+	Stat(name string) (fs.FileInfo, error)
+	Lstat(name string) (fs.FileInfo, error)
+	ReadDir(name string) ([]fs.DirEntry, error)
+	ReadFile(name string) ([]byte, error)
+	WriteFile(name string, data []byte, perm fs.FileMode) error
+	Mkdir(name string, perm fs.FileMode) error
+	MkdirAll(name string, perm fs.FileMode) error
+	Remove(name string) error
+	Rename(oldpath, newpath string) error
+	Symlink(oldname, newname string) error
+	Readlink(name string) (string, error)
+	Chmod(name string, mode fs.FileMode) error
+	Chtimes(name string, atime, mtime time.Time) error
+	Lchown(name string, uid, gid int) error
+	EvalSymlinks(path string) (string, error)
+}
+
+// OS is the real filesystem.
+type OS struct{}
+
+func (OS) Stat(name string) (fs.FileInfo, error)      { return os.Stat(name) }
+func (OS) Lstat(name string) (fs.FileInfo, error)     { return os.Lstat(name) }
+func (OS) ReadDir(name string) ([]fs.DirEntry, error) { return os.ReadDir(name) }
+func (OS) ReadFile(name string) ([]byte, error)       { return os.ReadFile(name) }
+
+func (OS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	return os.WriteFile(name, data, perm)
+}
+
+func (OS) Mkdir(name string, perm fs.FileMode) error    { return os.Mkdir(name, perm) }
+func (OS) MkdirAll(name string, perm fs.FileMode) error { return os.MkdirAll(name, perm) }
+func (OS) Remove(name string) error                     { return os.Remove(name) }
+func (OS) Rename(oldpath, newpath string) error         { return os.Rename(oldpath, newpath) }
+func (OS) Symlink(oldname, newname string) error        { return os.Symlink(oldname, newname) }
+func (OS) Readlink(name string) (string, error)         { return os.Readlink(name) }
+func (OS) Chmod(name string, mode fs.FileMode) error    { return os.Chmod(name, mode) }
+
+func (OS) Chtimes(name string, atime, mtime time.Time) error {
+	return os.Chtimes(name, atime, mtime)
+}
+
+func (OS) Lchown(name string, uid, gid int) error   { return os.Lchown(name, uid, gid) }
+func (OS) EvalSymlinks(path string) (string, error) { return filepath.EvalSymlinks(path) }
+
+// Or returns fsys, or the real filesystem when fsys is nil.
+func Or(fsys FS) FS {
+	if fsys == nil {
+		return OS{}
+	}
+	return fsys
+}
+
+// WalkDir walks the tree rooted at root like filepath.WalkDir, calling fn
+// for every file and directory in lexical order without following
+// symlinks.
+func WalkDir(fsys FS, root string, fn fs.WalkDirFunc) error {
+	info, err := fsys.Lstat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		err = walkDir(fsys, root, fs.FileInfoToDirEntry(info), fn)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+func walkDir(fsys FS, path string, d fs.DirEntry, fn fs.WalkDirFunc) error {
+	if err := fn(path, d, nil); err != nil || !d.IsDir() {
+		if err == filepath.SkipDir && d.IsDir() {
+			err = nil
+		}
+		return err
+	}
+
+	entries, err := fsys.ReadDir(path)
+	if err != nil {
+		// Give fn a chance to skip the directory it cannot read.
+		if err = fn(path, d, err); err != nil {
+			if err == filepath.SkipDir && d.IsDir() {
+				err = nil
+			}
+			return err
+		}
+	}
+
+	for _, entry := range entries {
+		if err := walkDir(fsys, filepath.Join(path, entry.Name()), entry, fn); err != nil {
+			if err == filepath.SkipDir {
+				break
+			}
+			return err
+		}
+	}
+	return nil
+}